@@ -205,6 +205,37 @@ export class HealthLockClient {
       console.log(`\nRecords list ${JSON.stringify(record)}:`);
     });
   }
+
+  // updateRecordData points a record at a new CID, e.g. one of the updates a
+  // TEE node returns after rotating its key
+  async updateRecordData(
+    owner: Keypair,
+    recordId: number,
+    cid: string
+  ): Promise<string> {
+    const recordIdBuffer = Buffer.alloc(8);
+    recordIdBuffer.writeBigUInt64LE(BigInt(recordId));
+    const [healthRecordPda] = PublicKey.findProgramAddressSync(
+      [
+        Buffer.from(this.HEALTH_RECORD_SEED),
+        owner.publicKey.toBuffer(),
+        recordIdBuffer,
+      ],
+      this.program.programId
+    );
+
+    const tx = await this.program.methods
+      .updateRecordData(new anchor.BN(recordId), cid)
+      .accounts({
+        healthRecord: healthRecordPda,
+        owner: owner.publicKey,
+      } as any)
+      .signers([owner])
+      .rpc();
+
+    console.log(`✅ record ${recordId} now points at ${cid}:`, tx);
+    return tx;
+  }
 }
 
 // ------------------------
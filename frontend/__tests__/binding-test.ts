@@ -0,0 +1,12 @@
+import {encodeRecordBinding, RecordBinding} from '../api/binding';
+import vectors from '../../tee-client/keys/testdata/binding_vectors.json';
+
+describe('record binding', () => {
+  (vectors as {name: string; binding: RecordBinding; encoding: string}[]).forEach(
+    v => {
+      it(`matches vector "${v.name}"`, () => {
+        expect(encodeRecordBinding(v.binding).toString('hex')).toBe(v.encoding);
+      });
+    },
+  );
+});
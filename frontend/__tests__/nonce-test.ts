@@ -0,0 +1,13 @@
+import {computeNonce, encodeNonceInput, NonceInput} from '../api/nonce';
+import vectors from '../../tee-client/tee/testdata/nonce_vectors.json';
+
+describe('attestation nonce', () => {
+  (vectors as {name: string; input: NonceInput; encoding: string; nonce: string}[]).forEach(
+    v => {
+      it(`matches vector "${v.name}"`, () => {
+        expect(encodeNonceInput(v.input).toString('hex')).toBe(v.encoding);
+        expect(computeNonce(v.input)).toBe(v.nonce);
+      });
+    },
+  );
+});
@@ -0,0 +1,46 @@
+import {Buffer} from 'buffer';
+import {sha256} from 'js-sha256';
+import {RecordBinding} from '../api/binding';
+import {
+  generateResponseKeyPair,
+  openResponse,
+  responseKeyPair,
+  walletResponseKeyPair,
+} from '../api/response';
+import vectors from '../../tee-client/keys/testdata/response_vectors.json';
+
+type ResponseVector = {
+  name: string;
+  wallet_seed?: string;
+  private_key?: string;
+  binding: RecordBinding;
+  size: number;
+  sha256: string;
+  response: string;
+};
+
+describe('sealed responses', () => {
+  (vectors as ResponseVector[]).forEach(v => {
+    const keyPair = v.wallet_seed
+      ? walletResponseKeyPair(Buffer.from(v.wallet_seed, 'hex'))
+      : responseKeyPair(Buffer.from(v.private_key ?? '', 'hex'));
+    const response = Buffer.from(v.response, 'base64');
+
+    it(`opens vector "${v.name}"`, () => {
+      const {binding, plaintext} = openResponse(response, keyPair);
+      expect(binding).toEqual(v.binding);
+      expect(plaintext.length).toBe(v.size);
+      expect(sha256(plaintext)).toBe(v.sha256);
+    });
+
+    it(`rejects vector "${v.name}" tampered with or cut off`, () => {
+      const flipped = Buffer.from(response);
+      flipped[flipped.length - 1] ^= 1;
+      expect(() => openResponse(flipped, keyPair)).toThrow();
+      expect(() => openResponse(response.subarray(0, response.length - 20), keyPair)).toThrow();
+      expect(() => openResponse(response, generateResponseKeyPair())).toThrow(
+        'not sealed to this key',
+      );
+    });
+  });
+});
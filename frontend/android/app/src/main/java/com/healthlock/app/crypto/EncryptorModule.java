@@ -10,10 +10,14 @@ import com.facebook.react.bridge.ReactApplicationContext;
 import com.facebook.react.bridge.ReactContextBaseJavaModule;
 import com.facebook.react.bridge.ReactMethod;
 import com.facebook.react.bridge.Promise;
+import com.facebook.react.bridge.ReadableArray;
+import com.facebook.react.bridge.ReadableMap;
+import com.facebook.react.bridge.WritableArray;
 import com.facebook.react.bridge.WritableMap;
 
 import java.io.InputStream;
 import java.security.KeyFactory;
+import java.security.MessageDigest;
 import java.security.PublicKey;
 import java.security.SecureRandom;
 import java.security.spec.X509EncodedKeySpec;
@@ -83,4 +87,89 @@ public class EncryptorModule extends ReactContextBaseJavaModule {
             promise.reject("ENCRYPTION_ERROR", e.getMessage(), e);
         }
     }
+
+    // Encrypts the file once and wraps the AES key for every TEE node key, in
+    // the version 3 envelope format of tee-client/keys/envelope.go. The
+    // ciphertext is bound to its record: base64Aad is the encoding of binding
+    // computed by api/binding.ts.
+    @ReactMethod
+    public void encryptForRecipients(String contentUri, ReadableArray base64PublicKeys, ReadableMap binding, String base64Aad, Promise promise) {
+        try {
+            if (base64PublicKeys.size() == 0) {
+                promise.reject("ENCRYPTION_ERROR", "No TEE nodes to encrypt for");
+                return;
+            }
+
+            Uri uri = Uri.parse(contentUri);
+            ContentResolver resolver = reactContext.getContentResolver();
+            InputStream inputStream = resolver.openInputStream(uri);
+
+            if (inputStream == null) {
+                promise.reject("READ_ERROR", "Unable to open URI: " + contentUri);
+                return;
+            }
+
+            byte[] fileData = inputStream.readAllBytes(); // API 26+
+            inputStream.close();
+
+            KeyGenerator kgen = KeyGenerator.getInstance("AES");
+            kgen.init(256);
+            SecretKey aesKey = kgen.generateKey();
+
+            byte[] nonce = new byte[12];
+            new SecureRandom().nextBytes(nonce);
+
+            Cipher aesCipher = Cipher.getInstance("AES/GCM/NoPadding");
+            aesCipher.init(Cipher.ENCRYPT_MODE, aesKey, new GCMParameterSpec(128, nonce));
+            aesCipher.updateAAD(Base64.decode(base64Aad, Base64.NO_WRAP));
+            byte[] ciphertext = aesCipher.doFinal(fileData);
+
+            WritableArray recipients = Arguments.createArray();
+            for (int i = 0; i < base64PublicKeys.size(); i++) {
+                byte[] derPub = Base64.decode(base64PublicKeys.getString(i), Base64.NO_WRAP);
+                PublicKey pub = KeyFactory.getInstance("RSA")
+                    .generatePublic(new X509EncodedKeySpec(derPub));
+
+                Cipher rsaCipher = Cipher.getInstance("RSA/ECB/OAEPWithSHA-256AndMGF1Padding");
+                rsaCipher.init(Cipher.ENCRYPT_MODE, pub);
+                byte[] wrappedKey = rsaCipher.doFinal(aesKey.getEncoded());
+
+                WritableMap recipient = Arguments.createMap();
+                recipient.putString("key_id", keyId(derPub));
+                recipient.putString("wrapped_key", Base64.encodeToString(wrappedKey, Base64.NO_WRAP));
+                recipients.pushMap(recipient);
+            }
+
+            WritableMap bindingOut = Arguments.createMap();
+            bindingOut.putString("program_id", binding.getString("program_id"));
+            bindingOut.putString("owner", binding.getString("owner"));
+            bindingOut.putString("record_id", binding.getString("record_id"));
+            bindingOut.putString("mime_type", binding.getString("mime_type"));
+
+            WritableMap result = Arguments.createMap();
+            result.putInt("version", 3);
+            result.putString("kem", "RSA-OAEP-SHA256");
+            result.putString("aead", "AES-256-GCM");
+            result.putString("kdf", "none");
+            result.putMap("binding", bindingOut);
+            result.putArray("recipients", recipients);
+            result.putString("ciphertext", Base64.encodeToString(ciphertext, Base64.NO_WRAP));
+            result.putString("nonce", Base64.encodeToString(nonce, Base64.NO_WRAP));
+
+            promise.resolve(result);
+
+        } catch (Exception e) {
+            promise.reject("ENCRYPTION_ERROR", e.getMessage(), e);
+        }
+    }
+
+    // hex sha256 of the DER public key, as in keys.KeyID
+    private static String keyId(byte[] derPub) throws Exception {
+        byte[] hash = MessageDigest.getInstance("SHA-256").digest(derPub);
+        StringBuilder hex = new StringBuilder();
+        for (byte b : hash) {
+            hex.append(String.format("%02x", b));
+        }
+        return hex.toString();
+    }
 }
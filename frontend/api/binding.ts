@@ -0,0 +1,48 @@
+import {Buffer} from 'buffer';
+import {PublicKey} from '@solana/web3.js';
+
+// Record binding authenticated as AES-GCM associated data, mirroring
+// tee-client/keys/binding.go. Test vectors shared with the Go client are in
+// tee-client/keys/testdata/binding_vectors.json.
+
+export const BINDING_VERSION = 1;
+const BINDING_DOMAIN = 'healthlock-record-aad';
+
+// RecordBinding is the JSON form stored in the envelope, keys in base58 and
+// record_id as a decimal string
+export interface RecordBinding {
+  program_id: string;
+  owner: string;
+  record_id: string;
+  mime_type: string;
+}
+
+const u32 = (n: number): Buffer => {
+  const buf = Buffer.alloc(4);
+  buf.writeUInt32LE(n);
+  return buf;
+};
+
+const u64 = (n: string): Buffer => {
+  const value = BigInt(n);
+  const buf = Buffer.alloc(8);
+  for (let i = 0; i < 8; i++) {
+    buf[i] = Number((value >> BigInt(8 * i)) & BigInt(0xff));
+  }
+  return buf;
+};
+
+const string = (s: string): Buffer => {
+  const data = Buffer.from(s, 'utf8');
+  return Buffer.concat([u32(data.length), data]);
+};
+
+export const encodeRecordBinding = (binding: RecordBinding): Buffer =>
+  Buffer.concat([
+    Buffer.from(BINDING_DOMAIN, 'utf8'),
+    Buffer.from([BINDING_VERSION]),
+    new PublicKey(binding.program_id).toBuffer(),
+    new PublicKey(binding.owner).toBuffer(),
+    u64(binding.record_id),
+    string(binding.mime_type),
+  ]);
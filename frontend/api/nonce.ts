@@ -0,0 +1,68 @@
+import {Buffer} from 'buffer';
+import {sha256} from 'js-sha256';
+
+// Canonical attestation nonce, mirroring tee-client/tee/nonce.go. Test vectors
+// shared with the Go client are in tee-client/tee/testdata/nonce_vectors.json.
+
+export const NONCE_VERSION = 1;
+const NONCE_DOMAIN = 'healthlock-tee-nonce';
+
+// NonceInput is the JSON form returned by the TEE, byte fields hex encoded and
+// slot as a decimal string
+export interface NonceInput {
+  program_id: string;
+  genesis_hash: string;
+  slot: string;
+  blockhash: string;
+  address: string;
+  pubkey: string;
+  binary_hash: string;
+  config_hash: string;
+  version: string;
+  client_nonce: string;
+}
+
+const fixed32 = (name: string, value: string): Buffer => {
+  const buf = Buffer.from(value, 'hex');
+  if (buf.length !== 32) {
+    throw new Error(`${name} must be 32 hex encoded bytes`);
+  }
+  return buf;
+};
+
+const u32 = (n: number): Buffer => {
+  const buf = Buffer.alloc(4);
+  buf.writeUInt32LE(n);
+  return buf;
+};
+
+const u64 = (n: string): Buffer => {
+  const value = BigInt(n);
+  const buf = Buffer.alloc(8);
+  for (let i = 0; i < 8; i++) {
+    buf[i] = Number((value >> BigInt(8 * i)) & BigInt(0xff));
+  }
+  return buf;
+};
+
+const vec = (data: Buffer): Buffer => Buffer.concat([u32(data.length), data]);
+
+export const encodeNonceInput = (input: NonceInput): Buffer =>
+  Buffer.concat([
+    Buffer.from(NONCE_DOMAIN, 'utf8'),
+    Buffer.from([NONCE_VERSION]),
+    fixed32('program_id', input.program_id),
+    fixed32('genesis_hash', input.genesis_hash),
+    u64(input.slot),
+    fixed32('blockhash', input.blockhash),
+    fixed32('address', input.address),
+    vec(Buffer.from(input.pubkey, 'hex')),
+    fixed32('binary_hash', input.binary_hash),
+    fixed32('config_hash', input.config_hash),
+    vec(Buffer.from(input.version, 'utf8')),
+    vec(Buffer.from(input.client_nonce, 'hex')),
+  ]);
+
+// computeNonce returns the hex nonce the TEE must have placed in report_data
+export const computeNonce = (input: NonceInput): string =>
+  sha256(encodeNonceInput(input));
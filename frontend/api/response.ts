@@ -0,0 +1,232 @@
+import {Buffer} from 'buffer';
+import forge from 'node-forge';
+import {x25519} from '@noble/curves/ed25519';
+import {expand, extract} from '@noble/hashes/hkdf';
+import {sha256} from '@noble/hashes/sha256';
+import {sha512} from '@noble/hashes/sha512';
+import {encodeRecordBinding, RecordBinding} from './binding';
+
+// Opening records the TEE returns sealed to the requester, mirroring
+// tee-client/keys/response.go. Test vectors shared with the Go client are in
+// tee-client/keys/testdata/response_vectors.json.
+
+// RESPONSE_KEY_SIGNER as responseKey has the record sealed to the X25519 form
+// of the signer's key, see walletResponseKeyPair
+export const RESPONSE_KEY_SIGNER = 'signer';
+
+const STREAM_MAGIC = 'HLSTREAM';
+const STREAM_PREFIX_SIZE = 7;
+const TAG_SIZE = 16;
+const KEM_X25519_HPKE = 'HPKE-X25519-HKDF-SHA256-AES-256-GCM';
+const HPKE_INFO = Buffer.from('healthlock envelope key', 'utf8');
+// DER prefix of an X25519 SubjectPublicKeyInfo, whose sha256 is the key id
+const X25519_SPKI_PREFIX = Buffer.from('302a300506032b656e032100', 'hex');
+
+export interface ResponseKeyPair {
+  privateKey: Uint8Array;
+  publicKey: Uint8Array;
+}
+
+interface Recipient {
+  key_id: string;
+  kem?: string;
+  wrapped_key: string;
+}
+
+interface StreamHeader {
+  version: number;
+  kem: string;
+  aead: string;
+  kdf: string;
+  binding?: RecordBinding;
+  recipients: Recipient[];
+  segment_size?: number;
+  nonce: string;
+}
+
+export const responseKeyPair = (privateKey: Uint8Array): ResponseKeyPair => ({
+  privateKey,
+  publicKey: x25519.getPublicKey(privateKey),
+});
+
+// generateResponseKeyPair creates an ephemeral key pair for one request
+export const generateResponseKeyPair = (): ResponseKeyPair =>
+  responseKeyPair(x25519.utils.randomPrivateKey());
+
+// walletResponseKeyPair derives the key pair of RESPONSE_KEY_SIGNER from a
+// Solana secret key, or its 32 byte seed: as in RFC 8032 the scalar is the
+// first half of the SHA-512 of the seed
+export const walletResponseKeyPair = (secretKey: Uint8Array): ResponseKeyPair =>
+  responseKeyPair(sha512(secretKey.subarray(0, 32)).subarray(0, 32));
+
+// responseKey is the responseKey of a decrypt request for an ephemeral key
+export const responseKey = (keyPair: ResponseKeyPair): string =>
+  Buffer.from(keyPair.publicKey).toString('base64');
+
+const i2osp = (n: number, size: number): Buffer => {
+  const buf = Buffer.alloc(size);
+  buf.writeUIntBE(n, 0, size);
+  return buf;
+};
+
+// HPKE base mode (RFC 9180) with DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and
+// AES-256-GCM, as the Go client wraps keys
+const HPKE_VERSION = Buffer.from('HPKE-v1', 'utf8');
+const KEM_SUITE = Buffer.concat([Buffer.from('KEM', 'utf8'), i2osp(0x20, 2)]);
+const HPKE_SUITE = Buffer.concat([
+  Buffer.from('HPKE', 'utf8'),
+  i2osp(0x20, 2),
+  i2osp(0x01, 2),
+  i2osp(0x02, 2),
+]);
+
+const labeledExtract = (
+  suite: Buffer,
+  salt: Uint8Array | undefined,
+  label: string,
+  ikm: Uint8Array,
+): Uint8Array =>
+  extract(
+    sha256,
+    Buffer.concat([HPKE_VERSION, suite, Buffer.from(label, 'utf8'), ikm]),
+    salt,
+  );
+
+const labeledExpand = (
+  suite: Buffer,
+  prk: Uint8Array,
+  label: string,
+  info: Uint8Array,
+  length: number,
+): Uint8Array =>
+  expand(
+    sha256,
+    prk,
+    Buffer.concat([
+      i2osp(length, 2),
+      HPKE_VERSION,
+      suite,
+      Buffer.from(label, 'utf8'),
+      info,
+    ]),
+    length,
+  );
+
+const binary = (data: Uint8Array): string => Buffer.from(data).toString('binary');
+
+const aesGcmOpen = (
+  key: Uint8Array,
+  nonce: Uint8Array,
+  sealed: Uint8Array,
+  aad: Uint8Array,
+): Buffer => {
+  if (sealed.length < TAG_SIZE) {
+    throw new Error('sealed data too short');
+  }
+  const decipher = forge.cipher.createDecipher(
+    'AES-GCM',
+    forge.util.createBuffer(binary(key)),
+  );
+  decipher.start({
+    iv: forge.util.createBuffer(binary(nonce)),
+    additionalData: binary(aad),
+    tagLength: TAG_SIZE * 8,
+    tag: forge.util.createBuffer(binary(sealed.subarray(sealed.length - TAG_SIZE))),
+  });
+  decipher.update(
+    forge.util.createBuffer(binary(sealed.subarray(0, sealed.length - TAG_SIZE))),
+  );
+  if (!decipher.finish()) {
+    throw new Error('failed authentication');
+  }
+  return Buffer.from(decipher.output.getBytes(), 'binary');
+};
+
+const unwrapKey = (keyPair: ResponseKeyPair, wrapped: Buffer): Buffer => {
+  const enc = wrapped.subarray(0, 32);
+  const dh = x25519.getSharedSecret(keyPair.privateKey, enc);
+  const eaePrk = labeledExtract(KEM_SUITE, undefined, 'eae_prk', dh);
+  const sharedSecret = labeledExpand(
+    KEM_SUITE,
+    eaePrk,
+    'shared_secret',
+    Buffer.concat([enc, keyPair.publicKey]),
+    32,
+  );
+
+  const context = Buffer.concat([
+    Buffer.from([0]),
+    labeledExtract(HPKE_SUITE, undefined, 'psk_id_hash', new Uint8Array()),
+    labeledExtract(HPKE_SUITE, undefined, 'info_hash', HPKE_INFO),
+  ]);
+  const secret = labeledExtract(HPKE_SUITE, sharedSecret, 'secret', new Uint8Array());
+  const key = labeledExpand(HPKE_SUITE, secret, 'key', context, 32);
+  const baseNonce = labeledExpand(HPKE_SUITE, secret, 'base_nonce', context, 12);
+  return aesGcmOpen(key, baseNonce, wrapped.subarray(32), new Uint8Array());
+};
+
+const segmentNonce = (prefix: Buffer, counter: number, last: boolean): Buffer =>
+  Buffer.concat([prefix, i2osp(counter, 4), Buffer.from([last ? 1 : 0])]);
+
+// openResponse decrypts a /download-record response sealed to keyPair, given
+// as bytes, so base64 responses are decoded first. It throws if the response
+// was sealed to another key, tampered with or cut off.
+export const openResponse = (
+  data: Uint8Array,
+  keyPair: ResponseKeyPair,
+): {binding: RecordBinding; plaintext: Buffer} => {
+  const response = Buffer.from(data);
+  if (response.subarray(0, STREAM_MAGIC.length).toString('binary') !== STREAM_MAGIC) {
+    throw new Error('response is not a sealed record');
+  }
+  const headerEnd = STREAM_MAGIC.length + 4 + response.readUInt32BE(STREAM_MAGIC.length);
+  const header: StreamHeader = JSON.parse(
+    response.subarray(STREAM_MAGIC.length + 4, headerEnd).toString('utf8'),
+  );
+  const segmentSize = header.segment_size ?? 0;
+  if (
+    header.version < 3 ||
+    !header.binding ||
+    segmentSize <= 0 ||
+    header.aead !== 'AES-256-GCM' ||
+    header.kdf !== 'none'
+  ) {
+    throw new Error('unsupported response envelope');
+  }
+
+  const keyID = Buffer.from(
+    sha256(Buffer.concat([X25519_SPKI_PREFIX, keyPair.publicKey])),
+  ).toString('hex');
+  const recipient = header.recipients.find(r => r.key_id === keyID);
+  if (!recipient) {
+    throw new Error('response is not sealed to this key');
+  }
+  if ((recipient.kem || header.kem) !== KEM_X25519_HPKE) {
+    throw new Error('unsupported response envelope');
+  }
+  const dataKey = unwrapKey(keyPair, Buffer.from(recipient.wrapped_key, 'base64'));
+
+  const prefix = Buffer.from(header.nonce, 'base64');
+  if (prefix.length !== STREAM_PREFIX_SIZE) {
+    throw new Error('invalid nonce prefix');
+  }
+  const aad = encodeRecordBinding(header.binding);
+  const sealedSize = segmentSize + TAG_SIZE;
+  const segments: Buffer[] = [];
+  // even an empty record ends with a sealed last segment
+  for (let offset = headerEnd, counter = 0; ; counter++) {
+    const remaining = response.length - offset;
+    if (remaining <= 0) {
+      throw new Error('response ends before its last segment');
+    }
+    const last = remaining <= sealedSize;
+    const sealed = response.subarray(offset, offset + Math.min(remaining, sealedSize));
+    segments.push(aesGcmOpen(dataKey, segmentNonce(prefix, counter, last), sealed, aad));
+    if (last) {
+      break;
+    }
+    offset += sealedSize;
+  }
+
+  return {binding: header.binding, plaintext: Buffer.concat(segments)};
+};
@@ -1,10 +1,29 @@
-import {PublicKey} from '@solana/web3.js';
+import {Connection, PublicKey} from '@solana/web3.js';
+import {PROGRAM_ID} from '../util/constants';
+
+// discriminator + signer + pubkey (4 + 512) + attestation hash + measurement
+// + attestation uri (4 + 128) + is_initialized + last_attested_at + status
+export const TEE_STATE_SIZE = 8 + 32 + 4 + 512 + 32 + 48 + 4 + 128 + 1 + 8 + 1;
+
+// nodes re-attest every few hours; anything older than this is not trusted
+export const MAX_ATTESTATION_AGE_SECS = 24 * 60 * 60;
+
+// mirrors the TEEStatus enum of the program
+export enum TEEStatus {
+  Active = 0,
+  Deactivated = 1,
+  Revoked = 2,
+}
 
 export interface TEEState {
   signer: string;
-  attestation: string;
   pubkey: string;
+  attestationHash: string;
+  measurement: string;
+  attestationUri: string;
   isInitialized: boolean;
+  lastAttestedAt: number; // unix seconds
+  status: TEEStatus;
 }
 
 export const parseTEEState = (data: Buffer): TEEState => {
@@ -19,17 +38,60 @@ export const parseTEEState = (data: Buffer): TEEState => {
   const pubkey = data.slice(offset, offset + pubkeyLen);
   offset += pubkeyLen;
 
-  const attestationLen = data.readUInt32LE(offset);
+  const attestationHash = data.slice(offset, offset + 32);
+  offset += 32;
+
+  const measurement = data.slice(offset, offset + 48);
+  offset += 48;
+
+  const attestationUriLen = data.readUInt32LE(offset);
   offset += 4;
-  const attestation = data.slice(offset, offset + attestationLen);
-  offset += attestationLen;
+  const attestationUri = data.slice(offset, offset + attestationUriLen);
+  offset += attestationUriLen;
 
   const isInitialized = data.readUInt8(offset) === 1;
+  offset += 1;
+
+  const lastAttestedAt = Number(data.readBigInt64LE(offset));
+  offset += 8;
+
+  const status = data.readUInt8(offset) as TEEStatus;
 
   return {
     signer: signer.toBase58(),
     pubkey: pubkey.toString('base64'),
-    attestation: attestation.toString('hex'),
+    attestationHash: attestationHash.toString('hex'),
+    measurement: measurement.toString('hex'),
+    attestationUri: attestationUri.toString('utf8'),
     isInitialized,
+    lastAttestedAt,
+    status,
   };
 };
+
+export const isAttestationFresh = (
+  state: TEEState,
+  maxAgeSecs: number = MAX_ATTESTATION_AGE_SECS,
+  nowSecs: number = Math.floor(Date.now() / 1000),
+): boolean => state.isInitialized && nowSecs - state.lastAttestedAt <= maxAgeSecs;
+
+export const isTEEActive = (state: TEEState): boolean =>
+  state.isInitialized && state.status === TEEStatus.Active;
+
+// isTEEUsable tells whether records may be sent to a node: it must be neither
+// deactivated nor revoked, and recently attested
+export const isTEEUsable = (state: TEEState): boolean =>
+  isTEEActive(state) && isAttestationFresh(state);
+
+// fetchUsableTEEStates returns every node records may currently be encrypted
+// to, skipping deactivated, revoked and stale nodes
+export const fetchUsableTEEStates = async (
+  connection: Connection,
+): Promise<TEEState[]> => {
+  const accounts = await connection.getProgramAccounts(PROGRAM_ID, {
+    filters: [{dataSize: TEE_STATE_SIZE}],
+  });
+  return accounts
+    .map(account => parseTEEState(account.account.data))
+    .filter(isTEEUsable);
+};
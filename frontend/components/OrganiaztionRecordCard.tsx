@@ -12,6 +12,7 @@ import {PublicKey} from '@solana/web3.js';
 import {PermissionsAndroid, Platform} from 'react-native';
 import {Buffer} from 'buffer';
 import {useToast} from './providers/ToastContext';
+import {generateResponseKeyPair, openResponse, responseKey} from '../api/response';
 
 /**
  * Saves a base64-encoded file to the Android public Downloads directory.
@@ -84,9 +85,13 @@ const OrganizationRecordCard = ({
         throw new Error('Missing signer public key');
       }
 
+      // the record is sealed to this key, so proxies in between see no PHI;
+      // it is signed along so they cannot swap it for their own
+      const responseKeys = generateResponseKeyPair();
+      const sealTo = responseKey(responseKeys);
       const message = `record-access:${selectedAccount?.publicKey?.toBase58()}:${
         record.owner
-      }:${recordID}`;
+      }:${recordID}:${sealTo}`;
 
       const signatureBytes = await signMessage(message);
       const signature = bs58.encode(signatureBytes);
@@ -102,6 +107,7 @@ const OrganizationRecordCard = ({
           signature: signature,
           recordId: recordID,
           recordOwner: record.owner,
+          responseKey: sealTo,
         }),
       });
 
@@ -110,13 +116,17 @@ const OrganizationRecordCard = ({
         throw new Error(`Server error: ${response.status} - ${errorText}`);
       }
 
-      const base64Data = await response.text();
+      const sealedData = await response.text();
 
-      if (!base64Data || base64Data.length === 0) {
+      if (!sealedData || sealedData.length === 0) {
         throw new Error('Downloaded file is empty');
       }
 
-      const decodedBytes = Buffer.from(base64Data, 'base64');
+      const decodedBytes = openResponse(
+        Buffer.from(sealedData, 'base64'),
+        responseKeys,
+      ).plaintext;
+      const base64Data = decodedBytes.toString('base64');
 
       // Preview first few bytes for file type detection
       console.log('🔍 First bytes:', decodedBytes.slice(0, 4));
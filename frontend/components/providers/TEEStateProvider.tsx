@@ -1,11 +1,7 @@
 import React, { createContext, useContext, useState, ReactNode } from 'react';
+import { TEEState } from '../../api/state';
 
-export type TEEState = {
-    attestation: string;
-    isInitialized: boolean;
-    pubkey: string;
-    signer: string;
-};
+export type { TEEState };
 
 type TEEContextType = {
     teeState: TEEState | null;
@@ -30,7 +30,7 @@ import {
 } from '@solana-mobile/mobile-wallet-adapter-protocol-web3js';
 import {getOrganization, Organization} from '../api/organization';
 import {useToast} from '../components/providers/ToastContext';
-import {parseTEEState} from '../api/state';
+import {isTEEUsable, parseTEEState, TEE_STATE_SIZE} from '../api/state';
 import {useTEEContext} from '../components/providers/TEEStateProvider';
 import ProfileCard from '../components/ProfileCard';
 import {shortenAddress} from '../util/address';
@@ -109,17 +109,15 @@ const DashboardScreen = () => {
     try {
       setLoading(true);
       const accounts = await connection.getProgramAccounts(PROGRAM_ID, {
-        filters: [{dataSize: 1073}],
+        filters: [{dataSize: TEE_STATE_SIZE}],
       });
 
       for (const account of accounts) {
         const parsed = parseTEEState(account.account.data);
-        setTEEState({
-          attestation: parsed.attestation,
-          isInitialized: parsed.isInitialized,
-          pubkey: parsed.pubkey,
-          signer: parsed.signer,
-        });
+        if (!isTEEUsable(parsed)) {
+          continue;
+        }
+        setTEEState(parsed);
       }
     } catch (err: any) {
       if (err.message) {
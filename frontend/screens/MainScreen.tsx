@@ -14,7 +14,7 @@ import RecordsScreen from './RecordsScreen';
 import {useTEEContext} from '../components/providers/TEEStateProvider';
 import {ERR_UNKNOWN, PROGRAM_ID} from '../util/constants';
 import {useConnection} from '../components/providers/ConnectionProvider';
-import {parseTEEState} from '../api/state';
+import {isTEEUsable, parseTEEState, TEE_STATE_SIZE} from '../api/state';
 import {useToast} from '../components/providers/ToastContext';
 import HomeScreen from './HomeScreen';
 import {useNavigation} from '../components/providers/NavigationProvider';
@@ -37,17 +37,15 @@ const MainScreen = () => {
     try {
       setLoading(true);
       const accounts = await connection.getProgramAccounts(PROGRAM_ID, {
-        filters: [{dataSize: 1073}],
+        filters: [{dataSize: TEE_STATE_SIZE}],
       });
 
       for (const account of accounts) {
         const parsed = parseTEEState(account.account.data);
-        setTEEState({
-          attestation: parsed.attestation,
-          isInitialized: parsed.isInitialized,
-          pubkey: parsed.pubkey,
-          signer: parsed.signer,
-        });
+        if (!isTEEUsable(parsed)) {
+          continue;
+        }
+        setTEEState(parsed);
       }
     } catch (err: any) {
       if (err.message) {
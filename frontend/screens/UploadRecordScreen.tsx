@@ -29,6 +29,8 @@ import {
   TransactionInstruction,
 } from '@solana/web3.js';
 import {PROGRAM_ID} from '../util/constants';
+import {fetchUsableTEEStates} from '../api/state';
+import {encodeRecordBinding, RecordBinding} from '../api/binding';
 import {useConnection} from '../components/providers/ConnectionProvider';
 import {useToast} from '../components/providers/ToastContext';
 import {useAuthorization} from '../components/providers/AuthorizationProvider';
@@ -45,6 +47,15 @@ function extractBase64FromPemWrappedKey(base64Pem: string): string {
     .replace(/\s+/g, '');
 }
 
+// DER of the rsaEncryption algorithm identifier, 1.2.840.113549.1.1.1
+const RSA_ENCRYPTION_OID = Buffer.from('06092a864886f70d010101', 'hex');
+
+// the app wraps record keys with RSA-OAEP only, so nodes registered with
+// another key type, such as x25519, are not recipients of uploads
+function isRSAPublicKey(base64Der: string): boolean {
+  return Buffer.from(base64Der, 'base64').includes(RSA_ENCRYPTION_OID);
+}
+
 interface RecordCounterData {
   recordId: number;
 }
@@ -84,16 +95,45 @@ const UploadRecordScreen = () => {
     }
     try {
       setUploadHealthRecordLoading(true);
-      const base64DerKey = extractBase64FromPemWrappedKey(teeState?.pubkey);
-      const enc: EncryptResult = await Encryptor.encryptFromUri(
+      // encrypt to every usable node so the record survives losing any one
+      const nodes = await fetchUsableTEEStates(connection);
+      if (nodes.length === 0) {
+        Alert.alert('Error', 'No attested TEE node is available');
+        return;
+      }
+      const owner = selectedAccount?.publicKey;
+      if (!owner) {
+        Alert.alert('Error', 'Connect a wallet first');
+        return;
+      }
+      const base64DerKeys = nodes
+        .map(node => extractBase64FromPemWrappedKey(node.pubkey))
+        .filter(isRSAPublicKey);
+      if (base64DerKeys.length === 0) {
+        Alert.alert('Error', 'No TEE node with an RSA key is available');
+        return;
+      }
+      // the ciphertext is bound to the record it will be stored as, so the
+      // record id is fixed before encrypting
+      const mimeType: string = selectedFile?.type ?? '';
+      const binding: RecordBinding = {
+        program_id: PROGRAM_ID.toBase58(),
+        owner: owner.toBase58(),
+        record_id: (await fetchNextRecordId()).toString(),
+        mime_type: mimeType,
+      };
+      const enc: EncryptResult = await Encryptor.encryptForRecipients(
         selectedFile?.uri,
-        base64DerKey,
+        base64DerKeys,
+        binding,
+        encodeRecordBinding(binding).toString('base64'),
       );
       const cid = await uploadJsonToPinata(enc);
       await uploadHealthRecordTransaction(
         cid,
-        selectedFile?.type,
+        mimeType,
         JSON.stringify(enc).length,
+        binding,
       );
     } catch (e: any) {
       console.error('=========================', e);
@@ -102,6 +142,28 @@ const UploadRecordScreen = () => {
     }
   };
 
+  const [recordCounterPda] = PublicKey.findProgramAddressSync(
+    [Buffer.from('record_counter')],
+    PROGRAM_ID,
+  );
+
+  const fetchNextRecordId = async (): Promise<number> => {
+    const recordCounterAccount = await connection.getAccountInfo(
+      recordCounterPda,
+    );
+    if (!recordCounterAccount || !recordCounterAccount.data) {
+      throw new Error(
+        'Record counter account not found. Please initialize the system first.',
+      );
+    }
+
+    const recordCounter = parseRecordCounter(recordCounterAccount.data);
+    if (!recordCounter) {
+      throw new Error('Failed to parse record counter data');
+    }
+    return recordCounter.recordId;
+  };
+
   const parseRecordCounter = (data: Buffer): RecordCounterData | null => {
     try {
       const view = new DataView(data.buffer);
@@ -151,9 +213,14 @@ const UploadRecordScreen = () => {
   };
 
   const toast = useToast();
-  const {authorizeSession} = useAuthorization();
+  const {authorizeSession, selectedAccount} = useAuthorization();
   const uploadHealthRecordTransaction = useCallback(
-    async (enc: string, mimeType: string, fileSize: number) => {
+    async (
+      enc: string,
+      mimeType: string,
+      fileSize: number,
+      binding: RecordBinding,
+    ) => {
       return await transact(async (wallet: Web3MobileWallet) => {
         try {
           const [authorizationResult, latestBlockhash] = await Promise.all([
@@ -162,33 +229,26 @@ const UploadRecordScreen = () => {
           ]);
 
           const userPubkey = authorizationResult.publicKey;
-
-          const [recordCounterPda] = PublicKey.findProgramAddressSync(
-            [Buffer.from('record_counter')],
-            PROGRAM_ID,
-          );
+          if (userPubkey.toBase58() !== binding.owner) {
+            throw new Error(
+              'Wallet account changed since the record was encrypted',
+            );
+          }
 
           const [userVaultPda] = PublicKey.findProgramAddressSync(
             [Buffer.from('user_vault'), userPubkey.toBuffer()],
             PROGRAM_ID,
           );
 
-          const recordCounterAccount = await connection.getAccountInfo(
-            recordCounterPda,
-          );
-          if (!recordCounterAccount || !recordCounterAccount.data) {
+          // the program assigns the next id; if another upload took the id
+          // the record was bound to, it has to be encrypted again
+          const currentRecordId = await fetchNextRecordId();
+          if (currentRecordId.toString() !== binding.record_id) {
             throw new Error(
-              'Record counter account not found. Please initialize the system first.',
+              'Another record was uploaded meanwhile, please try again',
             );
           }
 
-          const recordCounter = parseRecordCounter(recordCounterAccount.data);
-          if (!recordCounter) {
-            throw new Error('Failed to parse record counter data');
-          }
-
-          const currentRecordId = recordCounter.recordId;
-
           const recordIdBuffer = Buffer.alloc(8);
           try {
             recordIdBuffer.writeBigUInt64LE(BigInt(currentRecordId), 0);
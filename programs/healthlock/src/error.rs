@@ -39,5 +39,13 @@ pub enum ErrorCode {
     #[msg("record not found in organization")]
     RecordNotFoundInOrganization,
     #[msg("user vault is not active")]
-    UserIsNotActive
+    UserIsNotActive,
+    #[msg("attestation uri is too long")]
+    AttestationUriTooLong,
+    #[msg("TEE node is not registered")]
+    NodeNotRegistered,
+    #[msg("TEE node is deactivated or revoked")]
+    NodeNotActive,
+    #[msg("TEE node is already revoked")]
+    NodeAlreadyRevoked,
 }
\ No newline at end of file
@@ -1,4 +1,5 @@
 use anchor_lang::prelude::*;
+use crate::state::TEEStatus;
 
 
 #[event]
@@ -42,10 +43,34 @@ pub struct HealthRecordDeactivated {
     pub timestamp: i64,
 }
 
+#[event]
+pub struct HealthRecordDataUpdated {
+    pub owner: Pubkey,
+    pub record_id: String,
+    pub encrypted_data: String,
+    pub timestamp: i64,
+}
+
 #[event]
 pub struct OrganizationRegistered {
     pub owner: Pubkey,
     pub organization_account: Pubkey,
     pub name: String,
     pub timestamp: i64,
-}
\ No newline at end of file
+}
+
+#[event]
+pub struct TEEAttestationUpdated {
+    pub signer: Pubkey,
+    pub attestation_hash: [u8; 32],
+    pub measurement: [u8; 48],
+    pub timestamp: i64,
+}
+
+#[event]
+pub struct TEEStatusChanged {
+    pub signer: Pubkey,
+    pub status: TEEStatus,
+    pub authority: Pubkey,
+    pub timestamp: i64,
+}
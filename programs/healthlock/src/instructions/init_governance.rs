@@ -0,0 +1,55 @@
+use anchor_lang::prelude::*;
+use crate::{error::ErrorCode, program::Healthlock, state::Governance, ANCHOR_DESCRIMINATOR_SIZE};
+
+// Only the program's upgrade authority may create the governance account, so
+// it cannot be claimed by whoever calls first after deployment.
+pub fn initialize_governance(ctx: Context<InitializeGovernance>, authority: Pubkey) -> Result<()> {
+    ctx.accounts.governance.authority = authority;
+    Ok(())
+}
+
+pub fn set_governance_authority(ctx: Context<SetGovernanceAuthority>, new_authority: Pubkey) -> Result<()> {
+    let governance = &mut ctx.accounts.governance;
+
+    require!(
+        governance.authority == ctx.accounts.authority.key(),
+        ErrorCode::UnauthorizedAccess
+    );
+
+    governance.authority = new_authority;
+    Ok(())
+}
+
+#[derive(Accounts)]
+pub struct InitializeGovernance<'info> {
+    #[account(
+        init,
+        payer = payer,
+        space = ANCHOR_DESCRIMINATOR_SIZE + Governance::INIT_SPACE,
+        seeds = [b"governance"],
+        bump
+    )]
+    pub governance: Account<'info, Governance>,
+
+    #[account(constraint = program.programdata_address()? == Some(program_data.key()))]
+    pub program: Program<'info, Healthlock>,
+
+    #[account(constraint = program_data.upgrade_authority_address == Some(payer.key()) @ ErrorCode::UnauthorizedAccess)]
+    pub program_data: Account<'info, ProgramData>,
+
+    #[account(mut)]
+    pub payer: Signer<'info>,
+    pub system_program: Program<'info, System>,
+}
+
+#[derive(Accounts)]
+pub struct SetGovernanceAuthority<'info> {
+    #[account(
+        mut,
+        seeds = [b"governance"],
+        bump
+    )]
+    pub governance: Account<'info, Governance>,
+
+    pub authority: Signer<'info>,
+}
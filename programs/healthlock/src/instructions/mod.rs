@@ -21,3 +21,15 @@ pub use register_organization::*;
 
 pub mod register_tee;
 pub use register_tee::*;
+
+pub mod update_tee_attestation;
+pub use update_tee_attestation::*;
+
+pub mod init_governance;
+pub use init_governance::*;
+
+pub mod tee_status;
+pub use tee_status::*;
+
+pub mod update_record_data;
+pub use update_record_data::*;
@@ -1,18 +1,30 @@
 use anchor_lang::prelude::*;
-use crate::{error::ErrorCode, state::TEEState, ANCHOR_DESCRIMINATOR_SIZE};
+use crate::{error::ErrorCode, state::{TEEState, TEEStatus}, ANCHOR_DESCRIMINATOR_SIZE};
 
 
-pub fn register_tee_node(ctx: Context<RegisterTEENode>, pubkey: Vec<u8>, attestation: Vec<u8>) -> Result<()> {
+pub fn register_tee_node(
+    ctx: Context<RegisterTEENode>,
+    pubkey: Vec<u8>,
+    attestation_hash: [u8; 32],
+    measurement: [u8; 48],
+    attestation_uri: String,
+) -> Result<()> {
     let state = &mut ctx.accounts.state;
 
     if state.is_initialized {
         return Err(ErrorCode::NodeAlreadyRegistered.into());
     }
 
+    require!(attestation_uri.len() <= 128, ErrorCode::AttestationUriTooLong);
+
     state.signer = *ctx.accounts.signer.key;
     state.pubkey = pubkey;
-    state.attestation = attestation;
+    state.attestation_hash = attestation_hash;
+    state.measurement = measurement;
+    state.attestation_uri = attestation_uri;
     state.is_initialized = true;
+    state.last_attested_at = Clock::get()?.unix_timestamp;
+    state.status = TEEStatus::Active;
 
     Ok(())
 }
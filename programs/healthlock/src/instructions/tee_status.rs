@@ -0,0 +1,69 @@
+use anchor_lang::prelude::*;
+use crate::{error::ErrorCode, events::*, state::{Governance, TEEState, TEEStatus}};
+
+// Retires a node. Clients stop selecting it and it can no longer refresh its
+// attestation.
+pub fn deactivate_tee(ctx: Context<UpdateTEEStatus>) -> Result<()> {
+    require!(
+        ctx.accounts.state.status == TEEStatus::Active,
+        ErrorCode::NodeNotActive
+    );
+    set_tee_status(ctx, TEEStatus::Deactivated)
+}
+
+// Marks a node as compromised. Also applies to deactivated nodes, so a
+// retired node that later turns out to be compromised is flagged as such.
+pub fn revoke_tee(ctx: Context<UpdateTEEStatus>) -> Result<()> {
+    require!(
+        ctx.accounts.state.status != TEEStatus::Revoked,
+        ErrorCode::NodeAlreadyRevoked
+    );
+    set_tee_status(ctx, TEEStatus::Revoked)
+}
+
+fn set_tee_status(ctx: Context<UpdateTEEStatus>, status: TEEStatus) -> Result<()> {
+    let authority = ctx.accounts.authority.key();
+    let is_governance = ctx
+        .accounts
+        .governance
+        .as_ref()
+        .map_or(false, |governance| governance.authority == authority);
+
+    let state = &mut ctx.accounts.state;
+
+    require!(state.is_initialized, ErrorCode::NodeNotRegistered);
+    require!(
+        state.signer == authority || is_governance,
+        ErrorCode::UnauthorizedAccess
+    );
+
+    state.status = status;
+
+    emit!(TEEStatusChanged {
+        signer: state.signer,
+        status,
+        authority,
+        timestamp: Clock::get()?.unix_timestamp,
+    });
+
+    Ok(())
+}
+
+#[derive(Accounts)]
+pub struct UpdateTEEStatus<'info> {
+    #[account(
+        mut,
+        seeds = [b"state", state.signer.as_ref()],
+        bump
+    )]
+    pub state: Account<'info, TEEState>,
+
+    // required unless the node itself signs
+    #[account(
+        seeds = [b"governance"],
+        bump
+    )]
+    pub governance: Option<Account<'info, Governance>>,
+
+    pub authority: Signer<'info>,
+}
@@ -0,0 +1,46 @@
+use anchor_lang::prelude::*;
+
+use crate::state::*;
+use crate::error::ErrorCode;
+use crate::events::*;
+
+// Points a record at another copy of its encrypted data, e.g. one re-wrapped
+// to a rotated TEE node key. Only the owner can switch it.
+pub fn update_record_data(
+    ctx: Context<UpdateRecordData>,
+    _record_id: u64,
+    encrypted_data: String,
+) -> Result<()> {
+    let health_record = &mut ctx.accounts.health_record;
+
+    require!(
+        health_record.owner == ctx.accounts.owner.key(),
+        ErrorCode::UnauthorizedAccess
+    );
+    require!(encrypted_data.len() <= 1048, ErrorCode::RecordTooLarge);
+
+    health_record.encrypted_data = encrypted_data;
+
+    emit!(HealthRecordDataUpdated {
+        owner: ctx.accounts.owner.key(),
+        record_id: health_record.record_id.to_string(),
+        encrypted_data: health_record.encrypted_data.clone(),
+        timestamp: Clock::get()?.unix_timestamp,
+    });
+
+    msg!("Health record data updated: {}", health_record.record_id);
+    Ok(())
+}
+
+#[derive(Accounts)]
+#[instruction(record_id: u64)]
+pub struct UpdateRecordData<'info> {
+    #[account(
+        mut,
+        seeds = [b"health_record", owner.key().as_ref(), record_id.to_le_bytes().as_ref()],
+        bump
+    )]
+    pub health_record: Account<'info, HealthRecord>,
+
+    pub owner: Signer<'info>,
+}
@@ -0,0 +1,51 @@
+use anchor_lang::prelude::*;
+use crate::{error::ErrorCode, events::*, state::{TEEState, TEEStatus}};
+
+// Replaces the attestation of an already registered node. The attested nonce
+// binds the node's encryption key, so the key is replaced along with it.
+pub fn update_tee_attestation(
+    ctx: Context<UpdateTEEAttestation>,
+    pubkey: Vec<u8>,
+    attestation_hash: [u8; 32],
+    measurement: [u8; 48],
+    attestation_uri: String,
+) -> Result<()> {
+    let state = &mut ctx.accounts.state;
+
+    require!(state.is_initialized, ErrorCode::NodeNotRegistered);
+    require!(state.status == TEEStatus::Active, ErrorCode::NodeNotActive);
+    require!(
+        state.signer == ctx.accounts.signer.key(),
+        ErrorCode::UnauthorizedAccess
+    );
+    require!(attestation_uri.len() <= 128, ErrorCode::AttestationUriTooLong);
+
+    let now = Clock::get()?.unix_timestamp;
+
+    state.pubkey = pubkey;
+    state.attestation_hash = attestation_hash;
+    state.measurement = measurement;
+    state.attestation_uri = attestation_uri;
+    state.last_attested_at = now;
+
+    emit!(TEEAttestationUpdated {
+        signer: state.signer,
+        attestation_hash,
+        measurement,
+        timestamp: now,
+    });
+
+    Ok(())
+}
+
+#[derive(Accounts)]
+pub struct UpdateTEEAttestation<'info> {
+    #[account(
+        mut,
+        seeds = [b"state", signer.key().as_ref()],
+        bump
+    )]
+    pub state: Account<'info, TEEState>,
+
+    pub signer: Signer<'info>,
+}
@@ -22,9 +22,40 @@ pub mod healthlock {
     pub fn register_tee(
         ctx: Context<RegisterTEENode>,
         pubkey: Vec<u8>,
-        attestation: Vec<u8>,
+        attestation_hash: [u8; 32],
+        measurement: [u8; 48],
+        attestation_uri: String,
     ) -> Result<()> {
-        instructions::register_tee_node(ctx, pubkey, attestation)
+        instructions::register_tee_node(ctx, pubkey, attestation_hash, measurement, attestation_uri)
+    }
+
+    pub fn update_tee_attestation(
+        ctx: Context<UpdateTEEAttestation>,
+        pubkey: Vec<u8>,
+        attestation_hash: [u8; 32],
+        measurement: [u8; 48],
+        attestation_uri: String,
+    ) -> Result<()> {
+        instructions::update_tee_attestation(ctx, pubkey, attestation_hash, measurement, attestation_uri)
+    }
+
+    pub fn deactivate_tee(ctx: Context<UpdateTEEStatus>) -> Result<()> {
+        instructions::deactivate_tee(ctx)
+    }
+
+    pub fn revoke_tee(ctx: Context<UpdateTEEStatus>) -> Result<()> {
+        instructions::revoke_tee(ctx)
+    }
+
+    pub fn initialize_governance(ctx: Context<InitializeGovernance>, authority: Pubkey) -> Result<()> {
+        instructions::initialize_governance(ctx, authority)
+    }
+
+    pub fn set_governance_authority(
+        ctx: Context<SetGovernanceAuthority>,
+        new_authority: Pubkey,
+    ) -> Result<()> {
+        instructions::set_governance_authority(ctx, new_authority)
     }
 
     pub fn register_organization(
@@ -77,4 +108,12 @@ pub mod healthlock {
     pub fn deactivate_record(ctx: Context<DeactivateRecord>, record_id: u64) -> Result<()> {
         instructions::deactivate_record(ctx, record_id)
     }
+
+    pub fn update_record_data(
+        ctx: Context<UpdateRecordData>,
+        record_id: u64,
+        encrypted_data: String,
+    ) -> Result<()> {
+        instructions::update_record_data(ctx, record_id, encrypted_data)
+    }
 }
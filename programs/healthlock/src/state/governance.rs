@@ -0,0 +1,8 @@
+use anchor_lang::prelude::*;
+
+// Holds the authority allowed to deactivate or revoke any TEE node
+#[account]
+#[derive(InitSpace)]
+pub struct Governance {
+    pub authority: Pubkey,
+}
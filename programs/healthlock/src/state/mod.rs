@@ -12,3 +12,6 @@ pub use organization::*;
 
 pub mod tee_node;
 pub use tee_node::*;
+
+pub mod governance;
+pub use governance::*;
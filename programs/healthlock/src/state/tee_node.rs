@@ -6,7 +6,24 @@ pub struct TEEState {
     pub signer: Pubkey,
     #[max_len(512)]
     pub pubkey: Vec<u8>,
-    #[max_len(512)]
-    pub attestation: Vec<u8>,
+    // sha256 of the raw attestation report; the report itself and its cert
+    // chain live off-chain at `attestation_uri`
+    pub attestation_hash: [u8; 32],
+    pub measurement: [u8; 48],
+    #[max_len(128)]
+    pub attestation_uri: String,
     pub is_initialized: bool,
+    // unix timestamp of the last registration or attestation refresh
+    pub last_attested_at: i64,
+    pub status: TEEStatus,
+}
+
+#[derive(AnchorSerialize, AnchorDeserialize, Clone, Copy, PartialEq, Eq, InitSpace)]
+pub enum TEEStatus {
+    Active,
+    // retired by its operator or governance
+    Deactivated,
+    // known or suspected to be compromised
+    Revoked,
 }
+
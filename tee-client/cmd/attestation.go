@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	minClientNonceSize = 16
+	maxClientNonceSize = 64
+
+	// every client may have this many reports signed per window
+	attestationsPerWindow = 10
+	attestationWindow     = time.Minute
+	// a report is served again to retries with the same nonce for this long
+	attestationCacheTTL = 30 * time.Second
+	maxCachedReports    = 1024
+)
+
+type AttestationResponse struct {
+	Report    string         `json:"report"`               // base64
+	CertChain string         `json:"cert_chain,omitempty"` // base64, AMD cert table
+	Nonce     string         `json:"nonce"`                // hex, placed in report_data
+	Inputs    tee.NonceInput `json:"inputs"`               // everything hashed into nonce
+}
+
+// AttestationHandler serves a fresh attestation report bound to the nonce
+// supplied by the caller, so clients can check they are talking to a genuine
+// enclave before sending it any data. base carries the node identity and
+// software measurement; the network state is refreshed per request.
+func AttestationHandler(ctx types.Context, solClient *solana.Client, attestor tee.Attestor, base tee.NonceInput) http.HandlerFunc {
+	limiter := newAttestationLimiter()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		clientNonce, err := hex.DecodeString(r.URL.Query().Get("nonce"))
+		if err != nil {
+			writeJSONError(w, "nonce must be hex encoded", http.StatusBadRequest)
+			return
+		}
+		if len(clientNonce) < minClientNonceSize || len(clientNonce) > maxClientNonceSize {
+			writeJSONError(w, fmt.Sprintf("nonce must be %d to %d bytes", minClientNonceSize, maxClientNonceSize), http.StatusBadRequest)
+			return
+		}
+
+		key := hex.EncodeToString(clientNonce)
+		if resp, ok := limiter.cached(key, time.Now()); ok {
+			writeJSON(w, resp)
+			return
+		}
+		if wait := limiter.allow(clientAddress(r), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			writeJSONError(w, "Too many attestation requests", http.StatusTooManyRequests)
+			return
+		}
+
+		input, err := withNetworkState(ctx, solClient, base)
+		if err != nil {
+			writeJSONError(w, "Failed to fetch network state", http.StatusBadGateway)
+			return
+		}
+		input.ClientNonce = clientNonce
+
+		nonce := input.Nonce()
+
+		evidence, err := attestor.GenerateAttestationReport(nonce)
+		if err != nil {
+			fmt.Printf("❌ Failed to generate attestation report: %v\n", err)
+			writeJSONError(w, "Failed to generate attestation report", http.StatusInternalServerError)
+			return
+		}
+
+		resp := AttestationResponse{
+			Report: base64.StdEncoding.EncodeToString(evidence.Report),
+			Nonce:  nonce,
+			Inputs: input,
+		}
+		if len(evidence.CertTable) > 0 {
+			resp.CertChain = base64.StdEncoding.EncodeToString(evidence.CertTable)
+		}
+
+		limiter.store(key, resp, time.Now())
+		writeJSON(w, resp)
+	}
+}
+
+// attestationLimiter keeps the unauthenticated attestation endpoint from
+// keeping the secure processor busy: each report is a request to the
+// hardware, so clients get a few per window, and retries with the same
+// nonce are answered from cache
+type attestationLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*attestationWindowCount
+	reports map[string]*cachedReport
+}
+
+type attestationWindowCount struct {
+	start time.Time
+	count int
+}
+
+type cachedReport struct {
+	resp    AttestationResponse
+	expires time.Time
+}
+
+func newAttestationLimiter() *attestationLimiter {
+	return &attestationLimiter{
+		clients: make(map[string]*attestationWindowCount),
+		reports: make(map[string]*cachedReport),
+	}
+}
+
+// cached returns the report served for the client nonce key, if recent
+func (l *attestationLimiter) cached(key string, now time.Time) (AttestationResponse, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report, ok := l.reports[key]
+	if !ok || !now.Before(report.expires) {
+		return AttestationResponse{}, false
+	}
+	return report.resp, true
+}
+
+func (l *attestationLimiter) store(key string, resp AttestationResponse, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, report := range l.reports {
+		if !now.Before(report.expires) {
+			delete(l.reports, k)
+		}
+	}
+	if len(l.reports) < maxCachedReports {
+		l.reports[key] = &cachedReport{resp: resp, expires: now.Add(attestationCacheTTL)}
+	}
+}
+
+// allow counts a report for client, or returns how long it has to wait for
+// its next one
+func (l *attestationLimiter) allow(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c, window := range l.clients {
+		if now.Sub(window.start) >= attestationWindow {
+			delete(l.clients, c)
+		}
+	}
+
+	window, ok := l.clients[client]
+	if !ok {
+		window = &attestationWindowCount{start: now}
+		l.clients[client] = window
+	}
+	if window.count >= attestationsPerWindow {
+		return window.start.Add(attestationWindow).Sub(now)
+	}
+	window.count++
+	return 0
+}
+
+// clientAddress is the host a request comes from
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
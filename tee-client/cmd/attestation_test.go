@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttestationLimiter(t *testing.T) {
+	limiter := newAttestationLimiter()
+	now := time.Now()
+
+	for i := 0; i < attestationsPerWindow; i++ {
+		if wait := limiter.allow("10.0.0.1", now); wait != 0 {
+			t.Fatalf("report %d refused", i)
+		}
+	}
+	if wait := limiter.allow("10.0.0.1", now.Add(time.Second)); wait <= 0 || wait > attestationWindow {
+		t.Errorf("report over the limit allowed, wait %v", wait)
+	}
+	if wait := limiter.allow("10.0.0.2", now); wait != 0 {
+		t.Error("another client was limited")
+	}
+	if wait := limiter.allow("10.0.0.1", now.Add(attestationWindow)); wait != 0 {
+		t.Error("limit not lifted after the window")
+	}
+
+	limiter.store("nonce", AttestationResponse{Nonce: "n"}, now)
+	if resp, ok := limiter.cached("nonce", now.Add(time.Second)); !ok || resp.Nonce != "n" {
+		t.Error("retry not served from cache")
+	}
+	if _, ok := limiter.cached("nonce", now.Add(attestationCacheTTL)); ok {
+		t.Error("expired report served")
+	}
+}
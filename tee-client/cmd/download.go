@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	// DownloadTokenPath issues tokens to fetch a record by URL
+	DownloadTokenPath = "/v1/download-tokens"
+
+	downloadTokenTTL  = 5 * time.Minute
+	maxDownloadTokens = 4096
+
+	// decrypt requests carry a signature and at most an owner share
+	maxDecryptRequestSize = 64 << 10
+)
+
+// downloadTokens stand in for signed decrypt requests in URLs, so that
+// viewers can fetch and seek in records with plain GET requests while the
+// signature and any owner share stay out of query strings, and so out of
+// access logs, browser history and Referer headers
+type downloadTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*downloadToken
+}
+
+type downloadToken struct {
+	req     DecryptRequest
+	proof   []byte
+	expires time.Time
+}
+
+func newDownloadTokens() *downloadTokens {
+	return &downloadTokens{tokens: make(map[string]*downloadToken)}
+}
+
+// issue returns a token for req, proven by proof, that expires after
+// downloadTokenTTL
+func (d *downloadTokens) issue(req DecryptRequest, proof []byte) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	expires := time.Now().Add(downloadTokenTTL)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	if len(d.tokens) >= maxDownloadTokens {
+		return "", time.Time{}, &requestError{"Too many download tokens, try again later", http.StatusTooManyRequests}
+	}
+	d.tokens[token] = &downloadToken{req: req, proof: proof, expires: expires}
+	return token, expires, nil
+}
+
+// lookup returns the request of an unexpired token. Tokens can be used until
+// they expire, as viewers fetch a record in several range requests.
+func (d *downloadTokens) lookup(token string) (DecryptRequest, []byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	t, ok := d.tokens[token]
+	if !ok {
+		return DecryptRequest{}, nil, false
+	}
+	return t.req, t.proof, true
+}
+
+func (d *downloadTokens) expire(now time.Time) {
+	for token, t := range d.tokens {
+		if !now.Before(t.expires) {
+			delete(d.tokens, token)
+		}
+	}
+}
+
+// DownloadTokenHandler issues a download token for a signed decrypt request,
+// once the signer is known to have access to the record. Access is checked
+// again on every use of the token.
+func DownloadTokenHandler(ctx types.Context, solClient *solana.Client, tokens *downloadTokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req, body, reqErr := readDecryptRequest(w, r)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
+			return
+		}
+		if _, reqErr := authorizeRecordAccess(ctx, solClient, req); reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
+			return
+		}
+
+		token, expires, err := tokens.issue(req, body)
+		var issueErr *requestError
+		if errors.As(err, &issueErr) {
+			writeJSONError(w, issueErr.msg, issueErr.code)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, "Failed to issue download token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":   token,
+			"url":     "/download-record?token=" + url.QueryEscape(token),
+			"expires": expires.Unix(),
+		})
+	}
+}
+
+// readDecryptRequest reads the DecryptRequest in the JSON body of r, and
+// returns the body along
+func readDecryptRequest(w http.ResponseWriter, r *http.Request) (DecryptRequest, []byte, *requestError) {
+	var req DecryptRequest
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDecryptRequestSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return req, nil, &requestError{"Request too large", http.StatusRequestEntityTooLarge}
+	}
+	if err != nil {
+		return req, nil, &requestError{"Failed to read request", http.StatusBadRequest}
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, nil, &requestError{"Invalid JSON", http.StatusBadRequest}
+	}
+	return req, body, nil
+}
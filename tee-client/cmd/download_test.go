@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTokens(t *testing.T) {
+	tokens := newDownloadTokens()
+	req := DecryptRequest{CID: "bafy", Signer: "signer", Signature: "signature", RecordID: 7}
+	token, expires, err := tokens.issue(req, []byte(`{"cid":"bafy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expires); d <= 0 || d > downloadTokenTTL {
+		t.Errorf("token expires in %v", d)
+	}
+
+	got, proof, reqErr := decryptRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/download-record?token="+token, nil), tokens)
+	if reqErr != nil || got != req || string(proof) != `{"cid":"bafy"}` {
+		t.Fatalf("got %+v, %s, %v", got, proof, reqErr)
+	}
+
+	// signed requests and owner shares are not taken from query strings
+	for _, query := range []string{"", "?cid=bafy&signer=signer&signature=signature&recordOwner=owner&recordId=7", "?token=unknown"} {
+		if _, _, reqErr := decryptRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/download-record"+query, nil), tokens); reqErr == nil || reqErr.code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %v", query, reqErr)
+		}
+	}
+
+	tokens.expire(expires)
+	if _, _, ok := tokens.lookup(token); ok {
+		t.Error("expired token still valid")
+	}
+}
+
+func TestDecryptRequestBodyIsBounded(t *testing.T) {
+	body := `{"cid":"bafy","ownerShare":"` + strings.Repeat("A", maxDecryptRequestSize) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/download-record", strings.NewReader(body))
+	if _, _, reqErr := decryptRequest(httptest.NewRecorder(), r, newDownloadTokens()); reqErr == nil || reqErr.code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v", reqErr)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/download-record", strings.NewReader(`{"cid":"bafy","recordId":7}`))
+	if got, _, reqErr := decryptRequest(httptest.NewRecorder(), r, newDownloadTokens()); reqErr != nil || got.CID != "bafy" || got.RecordID != 7 {
+		t.Fatalf("got %+v, %v", got, reqErr)
+	}
+}
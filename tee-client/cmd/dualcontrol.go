@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/spf13/cobra"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const defaultGrantTTL = 10 * time.Minute
+
+var (
+	grantKeypair   string
+	grantIn        string
+	grantCID       string
+	grantNode      string
+	grantRequester string
+	grantTTL       time.Duration
+
+	grantShareCmd = &cobra.Command{
+		Use:   "grant-share",
+		Short: "Release the owner's key share of a dual control record for one request",
+		Run:   runGrantShare,
+	}
+)
+
+func init() {
+	grantShareCmd.Flags().StringVar(&cfgPath, "config", "", "Path to config.toml")
+	grantShareCmd.MarkFlagRequired("config")
+	grantShareCmd.Flags().StringVar(&grantKeypair, "keypair", "", "Owner's Solana keypair file, as written by solana-keygen")
+	grantShareCmd.MarkFlagRequired("keypair")
+	grantShareCmd.Flags().StringVar(&grantIn, "in", "", "Encrypted record file")
+	grantShareCmd.Flags().StringVar(&grantCID, "cid", "", "IPFS CID of the encrypted record")
+	grantShareCmd.Flags().StringVar(&grantNode, "node", "", "Address of the TEE node that will serve the request")
+	grantShareCmd.MarkFlagRequired("node")
+	grantShareCmd.Flags().StringVar(&grantRequester, "requester", "", "Address that will sign the request, the owner if empty")
+	grantShareCmd.Flags().DurationVar(&grantTTL, "ttl", defaultGrantTTL, "How long the grant is valid")
+
+	rootCmd.AddCommand(grantShareCmd)
+}
+
+// runGrantShare is the owner's side of dual control: it unwraps the owner
+// share of a record with the owner's wallet and seals it to one TEE node for
+// one requester, printing the ownerShare of the decrypt request
+func runGrantShare(cmd *cobra.Command, args []string) {
+	if (grantIn == "") == (grantCID == "") {
+		log.Fatal("Pass exactly one of --in and --cid")
+	}
+	if grantTTL <= 0 {
+		log.Fatal("--ttl must be positive")
+	}
+
+	config, err := config.LoadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	ctx := types.NewContext().WithConfig(config)
+
+	wallet, err := solanago.PrivateKeyFromSolanaKeygenFile(grantKeypair)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", grantKeypair, err)
+	}
+	owner, err := keys.OwnerRecoveryKeyPair(wallet)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	requester := wallet.PublicKey()
+	if grantRequester != "" {
+		if requester, err = solanago.PublicKeyFromBase58(grantRequester); err != nil {
+			log.Fatalf("Invalid requester: %v", err)
+		}
+	}
+	node, err := solanago.PublicKeyFromBase58(grantNode)
+	if err != nil {
+		log.Fatalf("Invalid node: %v", err)
+	}
+
+	data, err := readRecordData(grantIn, grantCID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	env, err := keys.ParseEnvelope(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if env.Binding == nil {
+		log.Fatal("The record is not bound to a health record")
+	}
+
+	share, err := owner.UnwrapOwnerShare(env)
+	if errors.Is(err, keys.ErrNotARecipient) {
+		log.Fatal("The owner share of the record is not wrapped to this wallet")
+	}
+	if err != nil {
+		log.Fatalf("Failed to unwrap the owner share: %v", err)
+	}
+
+	solClient, err := solana.NewClient(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state, err := solClient.GetTEEState(*ctx, node)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !state.Usable(solana.MaxAttestationAge, time.Now()) {
+		log.Fatalf("TEE node %s is %s or not recently attested", node, state.Status)
+	}
+	teeKey, err := nodePublicKey(state)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sealed, err := keys.SealOwnerShareGrant(teeKey, &keys.OwnerShareGrant{
+		Owner:     env.Binding.Owner,
+		RecordID:  env.Binding.RecordID,
+		Requester: requester,
+		Expires:   time.Now().Add(grantTTL),
+		Share:     share,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+// ownerShareGrant opens the owner share grant of a decrypt request for a dual
+// control record and checks that it releases the share to the requester
+func ownerShareGrant(keypair *keys.KeyPair, req DecryptRequest, binding keys.RecordBinding) (*keys.OwnerShareGrant, *requestError) {
+	if req.OwnerShare == nil {
+		return nil, &requestError{"Record requires the owner's key share", http.StatusUnauthorized}
+	}
+
+	grant, err := keypair.OpenOwnerShareGrant(req.OwnerShare)
+	if errors.Is(err, keys.ErrNotARecipient) {
+		return nil, &requestError{"Owner share is not sealed to this TEE node", http.StatusUnprocessableEntity}
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to open owner share: %v\n", err)
+		return nil, &requestError{"Invalid owner share", http.StatusBadRequest}
+	}
+
+	signer, err := solanago.PublicKeyFromBase58(req.Signer)
+	if err != nil {
+		return nil, &requestError{"Invalid signer pubkey", http.StatusBadRequest}
+	}
+	switch err := grant.Check(binding, signer, time.Now()); {
+	case errors.Is(err, keys.ErrOwnerShareExpired):
+		return nil, &requestError{"Owner share grant has expired", http.StatusUnauthorized}
+	case err != nil:
+		return nil, &requestError{"Owner share was not granted for this record and signer", http.StatusUnauthorized}
+	}
+	return grant, nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+// SealForActiveNodes encrypts plaintext to every active, recently attested
+// TEE node, so any of them can serve the record. With opts.Threshold, each
+// node gets a share of the key instead and that many must cooperate to
+// decrypt; opts.Recovery is typically keys.OwnerRecoveryKey(binding.Owner).
+func SealForActiveNodes(ctx types.Context, solClient *solana.Client, plaintext []byte, binding keys.RecordBinding, opts keys.SealOptions) ([]byte, error) {
+	recipients, err := activeNodeKeys(ctx, solClient)
+	if err != nil {
+		return nil, err
+	}
+	return keys.SealWithOptions(plaintext, binding, recipients, opts)
+}
+
+// SealStreamForActiveNodes is SealForActiveNodes for large files, writing a
+// streamed envelope of plaintext to w, to pin with UploadFileToPinata
+func SealStreamForActiveNodes(ctx types.Context, solClient *solana.Client, w io.Writer, plaintext io.Reader, binding keys.RecordBinding, opts keys.SealOptions) error {
+	recipients, err := activeNodeKeys(ctx, solClient)
+	if err != nil {
+		return err
+	}
+	return keys.SealStream(w, plaintext, binding, recipients, opts)
+}
+
+// activeNodeKeys returns the keys of the active, recently attested TEE nodes
+func activeNodeKeys(ctx types.Context, solClient *solana.Client) ([]crypto.PublicKey, error) {
+	nodes, err := solClient.GetUsableTEENodes(ctx, solana.MaxAttestationAge)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []crypto.PublicKey
+	for _, node := range nodes {
+		pub, err := nodePublicKey(node)
+		if err != nil {
+			fmt.Printf("⚠️  Skipping TEE node %s with unusable key: %v\n", node.Signer, err)
+			continue
+		}
+		recipients = append(recipients, pub)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no active, attested TEE nodes to encrypt to")
+	}
+	return recipients, nil
+}
+
+// pinEnvelope pins a sealed record: inline envelopes as JSON, like the
+// frontend does, and streamed ones as a file
+func pinEnvelope(jwt, name string, env *keys.Envelope, data []byte) (string, error) {
+	if env.SegmentSize != 0 {
+		return UploadFileToPinata(jwt, name, bytes.NewReader(data))
+	}
+	return UploadJsonToPinata(jwt, name, json.RawMessage(data))
+}
+
+// fetchEnvelope reads the envelope of the record at cid, without the
+// segments of streamed envelopes
+func fetchEnvelope(cid string) (*keys.Envelope, error) {
+	body, err := DownloadFromPinata(cid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	env, _, err := keys.ReadEnvelope(body)
+	return env, err
+}
+
+// nodePublicKey returns the key of a registered node, from its attestation
+// bundle if it registered a commitment to it
+func nodePublicKey(node *solana.TEEState) (crypto.PublicKey, error) {
+	pub, err := keys.ParseRegisteredPublicKey(node.Pubkey)
+	if errors.Is(err, keys.ErrKeyNotOnChain) {
+		return fetchPublishedKey(node)
+	}
+	return pub, err
+}
+
+// fetchPublishedKey reads the key of a node that registered a commitment to
+// it from the node's attestation bundle
+func fetchPublishedKey(node *solana.TEEState) (crypto.PublicKey, error) {
+	cid, ok := strings.CutPrefix(node.AttestationURI, "ipfs://")
+	if !ok {
+		return nil, fmt.Errorf("no attestation bundle at %q", node.AttestationURI)
+	}
+
+	data, err := DownloadJsonFromPinata(cid)
+	if err != nil {
+		return nil, err
+	}
+	var bundle tee.AttestationBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid attestation bundle: %w", err)
+	}
+	if err := bundle.Verify(&tee.AttestationCommitment{ReportHash: node.AttestationHash, Measurement: node.Measurement}); err != nil {
+		return nil, err
+	}
+
+	return keys.ParsePublishedPublicKey(node.Pubkey, bundle.PublicKey)
+}
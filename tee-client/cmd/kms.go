@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/kms"
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	defaultKMSDir          = "kms"
+	defaultKMSSyncInterval = time.Hour
+)
+
+// kmsService runs this node's key management service
+type kmsService struct {
+	ctx      types.Context
+	node     *kms.Node
+	interval time.Duration
+}
+
+func newKMSService(ctx types.Context, cfg *config.Config, solClient *solana.Client, keypair *keys.KeyPair, attestor tee.Attestor) (*kmsService, error) {
+	if len(cfg.KMS.Peers) == 0 {
+		return nil, errors.New("kms requires kms.peers, as keys must be replicated before records are sealed with them")
+	}
+	if !cfg.Rest.TLS {
+		return nil, errors.New("kms replication requires rest.tls, as peers are verified over RA-TLS")
+	}
+	if cfg.IPFS.PinataJWT == "" {
+		return nil, errors.New("kms requires ipfs.pinata-jwt, as this node pins the records it seals")
+	}
+	interval, err := parseInterval("kms.sync-interval", cfg.KMS.SyncInterval, defaultKMSSyncInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	sealer, ok := attestor.(tee.Sealer)
+	if !ok {
+		return nil, errors.New("kms requires a TEE that can seal storage")
+	}
+	sealingKey, err := sealer.SealingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cfg.KMS.Dir
+	if dir == "" {
+		dir = defaultKMSDir
+	}
+	store, err := kms.OpenStore(dir, sealingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &kms.Node{
+		Store:   store,
+		KeyPair: keypair,
+		Address: solClient.GetPubKey(),
+		Sign:    solClient.Sign,
+		NodeKey: func(_ context.Context, signer solanago.PublicKey) (crypto.PublicKey, error) {
+			state, err := solClient.GetTEEState(ctx, signer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up TEE node %s: %w", signer, err)
+			}
+			if !state.Usable(solana.MaxAttestationAge, time.Now()) {
+				return nil, fmt.Errorf("TEE node %s is %s or not recently attested", signer, state.Status)
+			}
+			return nodePublicKey(state)
+		},
+		Client: &http.Client{
+			Timeout:   peerTimeout,
+			Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(attestor.VerifyAttestationReport)},
+		},
+		Peers: cfg.KMS.Peers,
+	}
+
+	return &kmsService{ctx: ctx, node: node, interval: interval}, nil
+}
+
+// Run pulls keys and deletions from peers now and then every interval, until
+// the context is cancelled
+func (s *kmsService) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.node.Sync(s.ctx.Context()); err != nil {
+			fmt.Printf("⚠️  KMS sync incomplete: %v\n", err)
+		}
+
+		select {
+		case <-s.ctx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dataKey returns the key of a record held by the KMS, after checking that
+// it was created for this very record
+func (s *kmsService) dataKey(env *keys.Envelope, binding keys.RecordBinding) ([]byte, *requestError) {
+	entry, err := s.node.Store.Get(env.KMSKeyID)
+	switch {
+	case errors.Is(err, kms.ErrKeyDestroyed):
+		return nil, &requestError{"Record key has been destroyed", http.StatusGone}
+	case errors.Is(err, kms.ErrUnknownKey):
+		return nil, &requestError{"Record key is not held by this TEE node", http.StatusUnprocessableEntity}
+	case err != nil:
+		fmt.Printf("❌ Failed to read kms key %s: %v\n", env.KMSKeyID, err)
+		return nil, &requestError{"Failed to read record key", http.StatusInternalServerError}
+	}
+	if entry.Binding != binding {
+		return nil, &requestError{"Record key belongs to another record", http.StatusUnprocessableEntity}
+	}
+	return entry.Key, nil
+}
+
+// KMSSealResponse tells the owner where the sealed record was pinned, for
+// the HealthRecord to be created with
+type KMSSealResponse struct {
+	CID   string `json:"cid"`
+	KeyID string `json:"keyId"`
+}
+
+// KMSSealHandler encrypts an uploaded record under a new KMS key and pins
+// it. The form carries the file, its record_id and mime_type, and the
+// owner's signer address and signature over
+// "kms-seal:<signer>:<record_id>:<hex sha256 of the file>".
+func KMSSealHandler(cfg *config.Config, solClient *solana.Client, service *kmsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 5<<20) // 5MB
+		if err := r.ParseMultipartForm(5 << 20); err != nil {
+			writeJSONError(w, "File too large or malformed form", http.StatusBadRequest)
+			return
+		}
+
+		owner, err := solanago.PublicKeyFromBase58(r.FormValue("signer"))
+		if err != nil {
+			writeJSONError(w, "Invalid signer pubkey", http.StatusBadRequest)
+			return
+		}
+		recordID, err := strconv.ParseUint(r.FormValue("record_id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, "Invalid record_id", http.StatusBadRequest)
+			return
+		}
+		mimeType := r.FormValue("mime_type")
+		if mimeType == "" {
+			writeJSONError(w, "Missing mime_type", http.StatusBadRequest)
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		plaintext, err := io.ReadAll(file)
+		if err != nil {
+			writeJSONError(w, "Failed to read uploaded file", http.StatusInternalServerError)
+			return
+		}
+
+		message := fmt.Sprintf("kms-seal:%s:%d:%x", owner, recordID, sha256.Sum256(plaintext))
+		sig, err := solanago.SignatureFromBase58(r.FormValue("signature"))
+		if err != nil {
+			writeJSONError(w, "Invalid signature", http.StatusBadRequest)
+			return
+		}
+		if !sig.Verify(owner, []byte(message)) {
+			writeJSONError(w, "Signature verification failed", http.StatusUnauthorized)
+			return
+		}
+
+		binding := keys.RecordBinding{
+			ProgramID: solClient.GetProgramID(),
+			Owner:     owner,
+			RecordID:  recordID,
+			MimeType:  mimeType,
+		}
+		entry, err := service.node.Generate(r.Context(), binding)
+		if errors.Is(err, kms.ErrNotReplicated) {
+			writeJSONError(w, "No peer TEE node took the record key, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			fmt.Printf("❌ Failed to create kms key: %v\n", err)
+			writeJSONError(w, "Failed to create record key", http.StatusInternalServerError)
+			return
+		}
+
+		sealed, err := keys.SealKMS(plaintext, binding, entry.KeyID, entry.Key)
+		if err != nil {
+			fmt.Printf("❌ Failed to seal record: %v\n", err)
+			writeJSONError(w, "Failed to encrypt record", http.StatusInternalServerError)
+			return
+		}
+
+		cid, err := UploadJsonToPinata(cfg.IPFS.PinataJWT, fmt.Sprintf("record-%s-%d", owner, recordID), json.RawMessage(sealed))
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			writeJSONError(w, "Failed to pin record", http.StatusBadGateway)
+			return
+		}
+
+		fmt.Printf("🔐 Sealed record %d of %s under kms key %s: %s\n", recordID, owner, entry.KeyID, cid)
+		writeJSON(w, KMSSealResponse{CID: cid, KeyID: entry.KeyID})
+	}
+}
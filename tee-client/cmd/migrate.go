@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/internal/fsutil"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/migrate"
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+// migrateTimeout bounds the whole migration exchange with the old node
+const migrateTimeout = time.Minute
+
+var (
+	migrateFrom string
+	migrateOut  string
+
+	keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the key of this TEE node",
+	}
+
+	migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Fetch the key of an old node after attesting to it",
+		Run:   runMigrate,
+	}
+)
+
+func init() {
+	migrateCmd.Flags().StringVar(&cfgPath, "config", "", "Path to config.toml")
+	migrateCmd.MarkFlagRequired("config")
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "RA-TLS url of the old node, e.g. https://old-node:8085")
+	migrateCmd.MarkFlagRequired("from")
+	migrateCmd.Flags().StringVar(&migrateOut, "out", "node.key", "Where to store the migrated key, sealed to this machine, for start --key-file")
+
+	keysCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+// runMigrate is the new node's side: it attests to an ephemeral key, receives
+// the old node's key wrapped to it and stores it for start --key-file, sealed
+// so that only this TEE image on this machine can load it
+func runMigrate(cmd *cobra.Command, args []string) {
+	config, err := config.LoadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	attestor, err := tee.NewAttestor(types.NewContext().WithConfig(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// fail before the old node hands out its key
+	sealingKey, err := keyFileSealingKey(attestor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the old node must pass our own measurement policy too
+	client := &http.Client{
+		Timeout:   migrateTimeout,
+		Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(attestor.VerifyAttestationReport)},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	kp, err := migrate.Fetch(ctx, client, migrateFrom, attestor, migrate.PrintEvent)
+	if err != nil {
+		log.Fatalf("Key migration failed: %v", err)
+	}
+
+	if err := saveKeyPair(migrateOut, kp, sealingKey); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("✅ Migrated key stored in %s; start this node with --key-file %s\n", migrateOut, migrateOut)
+}
+
+// migrationHandler is the old node's side, serving its key to new nodes whose
+// attestation passes the measurement policy
+func migrationHandler(cfg *config.Config, keypair *keys.KeyPair, attestor tee.Attestor) (http.HandlerFunc, error) {
+	if !cfg.Rest.TLS {
+		return nil, errors.New("key migration requires rest.tls, as new nodes verify the old one over RA-TLS")
+	}
+	// with no measurements the policy accepts any image, which could then
+	// take the key
+	if len(cfg.Attestation.Policy.Measurements) == 0 {
+		return nil, errors.New("key migration requires attestation.policy.measurements")
+	}
+	return migrate.Handler(keypair, attestor, migrate.PrintEvent), nil
+}
+
+// keyFileSealingKey returns the key node key files are sealed with. The disk
+// lies outside the trust boundary of a confidential VM, so keys are never
+// stored in plaintext.
+func keyFileSealingKey(attestor tee.Attestor) ([]byte, error) {
+	sealer, ok := attestor.(tee.Sealer)
+	if !ok {
+		return nil, errors.New("key files require a TEE that can seal storage")
+	}
+	return sealer.SealingKey()
+}
+
+// saveKeyPair stores kp in path, sealed under sealingKey
+func saveKeyPair(path string, kp *keys.KeyPair, sealingKey []byte) error {
+	sealed, err := keys.SealKeyFile(kp, sealingKey)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileSync(path, sealed)
+}
+
+// loadKeyPair reads a key stored by saveKeyPair. A key stored in plaintext PEM
+// by earlier versions is sealed in place, and its plaintext overwritten.
+func loadKeyPair(path string, sealingKey []byte) (*keys.KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := keys.OpenKeyFile(data, sealingKey)
+	if !errors.Is(err, keys.ErrKeyFileNotSealed) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		return kp, nil
+	}
+
+	kp, err = parsePEMKeyPair(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := sealKeyFileInPlace(path, kp, sealingKey); err != nil {
+		return nil, fmt.Errorf("failed to seal %s: %w", path, err)
+	}
+	fmt.Printf("🔒 Sealed the plaintext key in %s\n", path)
+	return kp, nil
+}
+
+// sealKeyFileInPlace replaces the plaintext key file at path with kp sealed
+func sealKeyFileInPlace(path string, kp *keys.KeyPair, sealingKey []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := saveKeyPair(path, kp, sealingKey); err != nil {
+		return err
+	}
+	// f still holds the plaintext, which no name points to anymore
+	return fsutil.Overwrite(f)
+}
+
+// loadPEMKeyPair reads a private key in plaintext PEM, such as one a user
+// keeps off any TEE node
+func loadPEMKeyPair(path string) (*keys.KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePEMKeyPair(path, data)
+}
+
+func parsePEMKeyPair(path string, data []byte) (*keys.KeyPair, error) {
+	kp, err := keys.ImportKeyPairPEM(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return kp, nil
+}
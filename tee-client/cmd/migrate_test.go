@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestLoadKeyPairSealsPlaintextKeyFiles(t *testing.T) {
+	sealingKey := bytes.Repeat([]byte{1}, 32)
+	kp, err := keys.NewKeyPair(keys.KeyTypeX25519, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey, err := kp.ExportPrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored by an earlier version
+	path := filepath.Join(t.TempDir(), "node.key")
+	if err := os.WriteFile(path, []byte(pemKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		loaded, err := loadKeyPair(path, sealingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.X25519.Equal(kp.X25519) {
+			t.Fatal("loaded another key")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("PRIVATE KEY")) {
+			t.Fatal("key file is still in plaintext")
+		}
+	}
+
+	if _, err := loadKeyPair(path, bytes.Repeat([]byte{2}, 32)); err == nil {
+		t.Error("key file loaded with another sealing key")
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/spf13/cobra"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+var (
+	openResponseKeypair string
+	openResponseKey     string
+	openResponseIn      string
+	openResponseBase64  bool
+	openResponseOut     string
+
+	openResponseCmd = &cobra.Command{
+		Use:   "open-response",
+		Short: "Decrypt a record the TEE returned sealed to the requester",
+		Run:   runOpenResponse,
+	}
+)
+
+func init() {
+	openResponseCmd.Flags().StringVar(&openResponseKeypair, "keypair", "", "Requester's Solana keypair file, for records requested with responseKey \"signer\"")
+	openResponseCmd.Flags().StringVar(&openResponseKey, "key", "", "PEM X25519 private key whose public key was sent as responseKey")
+	openResponseCmd.Flags().StringVar(&openResponseIn, "in", "", "Response body of /download-record")
+	openResponseCmd.Flags().BoolVar(&openResponseBase64, "base64", false, "The response is base64 encoded, as served unless asked for binary")
+	openResponseCmd.Flags().StringVar(&openResponseOut, "out", "", "Where to write the decrypted record")
+	openResponseCmd.MarkFlagRequired("in")
+	openResponseCmd.MarkFlagRequired("out")
+
+	rootCmd.AddCommand(openResponseCmd)
+}
+
+// runOpenResponse opens a /download-record response sealed to the requester,
+// so organizations behind proxies that see their traffic decrypt locally
+func runOpenResponse(cmd *cobra.Command, args []string) {
+	if (openResponseKeypair == "") == (openResponseKey == "") {
+		log.Fatal("Pass exactly one of --keypair and --key")
+	}
+
+	kp, err := loadResponseKeyPair()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := os.Open(openResponseIn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	var response io.Reader = in
+	if openResponseBase64 {
+		response = base64.NewDecoder(base64.StdEncoding, in)
+	}
+
+	binding, plaintext, err := kp.OpenResponse(response)
+	if errors.Is(err, keys.ErrNotARecipient) {
+		log.Fatal("The response was not sealed to this key")
+	}
+	if err != nil {
+		log.Fatalf("Failed to open response: %v", err)
+	}
+
+	out, err := os.OpenFile(openResponseOut, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(out, plaintext); err != nil {
+		out.Close()
+		// a cut off or tampered record is not left behind
+		os.Remove(openResponseOut)
+		log.Fatalf("Failed to open response: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("✅ Opened record %d of %s (%s) to %s\n", binding.RecordID, binding.Owner, binding.MimeType, openResponseOut)
+}
+
+func loadResponseKeyPair() (*keys.KeyPair, error) {
+	if openResponseKey != "" {
+		return loadPEMKeyPair(openResponseKey)
+	}
+
+	wallet, err := solanago.PrivateKeyFromSolanaKeygenFile(openResponseKeypair)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", openResponseKeypair, err)
+	}
+	return keys.RequesterKeyPair(wallet)
+}
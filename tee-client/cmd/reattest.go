@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	defaultReattestInterval = 6 * time.Hour
+	defaultTCBCheckInterval = 10 * time.Minute
+)
+
+// attestationRound is one fresh attestation of the node, ready to publish
+type attestationRound struct {
+	nonceInput tee.NonceInput
+	evidence   *tee.Evidence
+	commitment *tee.AttestationCommitment
+	tcb        uint64
+	publicKey  string // base64 DER of the node key
+}
+
+// withNetworkState binds base to the latest state of the cluster
+func withNetworkState(ctx types.Context, solClient *solana.Client, base tee.NonceInput) (tee.NonceInput, error) {
+	network, err := solClient.GetNetworkState(ctx)
+	if err != nil {
+		return base, err
+	}
+	base.GenesisHash = network.GenesisHash
+	base.Slot = network.Slot
+	base.Blockhash = network.Blockhash
+	return base, nil
+}
+
+func attest(ctx types.Context, solClient *solana.Client, attestor tee.Attestor, base tee.NonceInput) (*attestationRound, error) {
+	input, err := withNetworkState(ctx, solClient, base)
+	if err != nil {
+		return nil, err
+	}
+
+	evidence, err := attestor.GenerateAttestationReport(input.Nonce())
+	if err != nil {
+		return nil, err
+	}
+
+	commitment, err := tee.NewAttestationCommitment(evidence.Report)
+	if err != nil {
+		return nil, err
+	}
+
+	tcb, err := tee.ReportedTCB(evidence.Report)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ctx.GetKeyPairs().ExportPublicKeyBase64()
+	if err != nil {
+		return nil, err
+	}
+
+	return &attestationRound{nonceInput: input, evidence: evidence, commitment: commitment, tcb: tcb, publicKey: publicKey}, nil
+}
+
+func (r *attestationRound) publish(cfg *config.Config, address string) (string, error) {
+	return publishAttestationBundle(cfg, address, &tee.AttestationBundle{
+		Version:    1,
+		Report:     r.evidence.Report,
+		CertChain:  r.evidence.CertTable,
+		Nonce:      r.nonceInput.Nonce(),
+		NonceInput: r.nonceInput,
+		PublicKey:  r.publicKey,
+	})
+}
+
+// Reattestor keeps the on-chain attestation of the node fresh. It attests
+// every TCB check interval and submits the result when the re-attestation
+// interval has passed or the hardware reports a different TCB, e.g. after a
+// firmware update on the host.
+type Reattestor struct {
+	ctx       types.Context
+	cfg       *config.Config
+	solClient *solana.Client
+	attestor  tee.Attestor
+	base      tee.NonceInput
+	status    *StatusWatcher
+
+	interval      time.Duration
+	checkInterval time.Duration
+
+	lastTCB      uint64
+	lastAttested time.Time
+}
+
+// NewReattestor schedules re-attestation after the attestation submitted at
+// startup
+func NewReattestor(ctx types.Context, cfg *config.Config, solClient *solana.Client, attestor tee.Attestor, base tee.NonceInput, last *attestationRound, status *StatusWatcher) (*Reattestor, error) {
+	interval, err := parseInterval("attestation.reattest-interval", cfg.Attestation.ReattestInterval, defaultReattestInterval)
+	if err != nil {
+		return nil, err
+	}
+	checkInterval, err := parseInterval("attestation.tcb-check-interval", cfg.Attestation.TCBCheckInterval, defaultTCBCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reattestor{
+		ctx:           ctx,
+		cfg:           cfg,
+		solClient:     solClient,
+		attestor:      attestor,
+		base:          base,
+		status:        status,
+		interval:      interval,
+		checkInterval: checkInterval,
+		lastTCB:       last.tcb,
+		lastAttested:  time.Now(),
+	}, nil
+}
+
+func parseInterval(name, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return d, nil
+}
+
+// Run blocks until the context is cancelled
+func (r *Reattestor) Run() {
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Context().Done():
+			return
+		case now := <-ticker.C:
+			if err := r.check(now); err != nil {
+				fmt.Printf("❌ Re-attestation failed: %v\n", err)
+			}
+		}
+	}
+}
+
+func (r *Reattestor) check(now time.Time) error {
+	// the program refuses refreshes for nodes that are no longer active
+	if !r.status.Active() {
+		return nil
+	}
+
+	round, err := attest(r.ctx, r.solClient, r.attestor, r.base)
+	if err != nil {
+		return err
+	}
+
+	tcbChanged := round.tcb != r.lastTCB
+	if !tcbChanged && now.Sub(r.lastAttested) < r.interval {
+		return nil
+	}
+	if tcbChanged {
+		fmt.Printf("🔄 Reported TCB changed from %#x to %#x, re-attesting\n", r.lastTCB, round.tcb)
+	}
+
+	// a node that drifted out of policy must not refresh its registration;
+	// clients will see its attestation go stale instead
+	if err := r.attestor.VerifyAttestationReport(round.evidence, round.nonceInput.Nonce()); err != nil {
+		return fmt.Errorf("node no longer satisfies the attestation policy: %w", err)
+	}
+
+	uri, err := round.publish(r.cfg, r.solClient.GetPubKeyString())
+	if err != nil {
+		return err
+	}
+
+	signature, err := r.solClient.UpdateTEEAttestation(r.ctx, r.base.PublicKey, round.commitment.ReportHash, round.commitment.Measurement, uri)
+	if err != nil {
+		return err
+	}
+	fmt.Println("✅ Attestation refreshed on chain:", signature)
+
+	r.lastTCB = round.tcb
+	r.lastAttested = now
+	return nil
+}
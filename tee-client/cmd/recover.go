@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/spf13/cobra"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+var (
+	recoverKeypair     string
+	recoverRecoveryKey string
+	recoverIn          string
+	recoverCID         string
+	recoverOut         string
+
+	recoverCmd = &cobra.Command{
+		Use:   "recover",
+		Short: "Decrypt a record with its owner's wallet or recovery key, without any TEE",
+		Run:   runRecover,
+	}
+)
+
+func init() {
+	recoverCmd.Flags().StringVar(&recoverKeypair, "keypair", "", "Owner's Solana keypair file, as written by solana-keygen")
+	recoverCmd.Flags().StringVar(&recoverRecoveryKey, "recovery-key", "", "PEM private key the record was sealed to instead of the owner's wallet")
+	recoverCmd.Flags().StringVar(&recoverIn, "in", "", "Encrypted record file")
+	recoverCmd.Flags().StringVar(&recoverCID, "cid", "", "IPFS CID of the encrypted record")
+	recoverCmd.Flags().StringVar(&recoverOut, "out", "", "Where to write the decrypted record")
+	recoverCmd.MarkFlagRequired("out")
+
+	rootCmd.AddCommand(recoverCmd)
+}
+
+// runRecover opens a record through the recovery entry of its envelope, the
+// escape hatch for when no TEE node is left to serve it
+func runRecover(cmd *cobra.Command, args []string) {
+	if (recoverKeypair == "") == (recoverRecoveryKey == "") {
+		log.Fatal("Pass exactly one of --keypair and --recovery-key")
+	}
+	if (recoverIn == "") == (recoverCID == "") {
+		log.Fatal("Pass exactly one of --in and --cid")
+	}
+
+	kp, err := loadRecoveryKeyPair()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := readRecordData(recoverIn, recoverCID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plaintext, err := kp.Recover(data)
+	if errors.Is(err, keys.ErrNotARecipient) {
+		log.Fatal("The record was not sealed to this key")
+	}
+	if err != nil {
+		log.Fatalf("Recovery failed: %v", err)
+	}
+
+	if err := os.WriteFile(recoverOut, plaintext, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	env, _ := keys.ParseEnvelope(data)
+	if b := env.Binding; b != nil {
+		fmt.Printf("✅ Recovered record %d of %s (%s) to %s\n", b.RecordID, b.Owner, b.MimeType, recoverOut)
+	} else {
+		fmt.Printf("✅ Recovered record to %s\n", recoverOut)
+	}
+}
+
+func loadRecoveryKeyPair() (*keys.KeyPair, error) {
+	if recoverRecoveryKey != "" {
+		return loadPEMKeyPair(recoverRecoveryKey)
+	}
+
+	wallet, err := solanago.PrivateKeyFromSolanaKeygenFile(recoverKeypair)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", recoverKeypair, err)
+	}
+	return keys.OwnerRecoveryKeyPair(wallet)
+}
+
+// readRecordData reads an encrypted record from a file or from IPFS
+func readRecordData(in, cid string) ([]byte, error) {
+	if cid != "" {
+		return DownloadJsonFromPinata(cid)
+	}
+	return os.ReadFile(in)
+}
@@ -2,38 +2,51 @@ package cmd
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
+	"crypto/ecdh"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/spf13/cobra"
 	"github.com/vitwit/healthlock/tee-client/config"
 	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/kms"
+	"github.com/vitwit/healthlock/tee-client/migrate"
+	"github.com/vitwit/healthlock/tee-client/ratls"
 	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/staging"
 	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/threshold"
+	"github.com/vitwit/healthlock/tee-client/tus"
 	"github.com/vitwit/healthlock/tee-client/types"
 
 	solanago "github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
 )
 
+// defaultMaxUploadMB is the upload limit without rest.max-upload-mb
+const defaultMaxUploadMB = 1024
+
 var (
 	cfgPath string
 
 	debug bool
 
+	keyFile string
+
+	rotateKey bool
+
 	rootCmd = &cobra.Command{
 		Use:   "start",
 		Short: "Start the service with the specified config file",
@@ -54,6 +67,8 @@ func init() {
 	rootCmd.MarkFlagRequired("config")
 
 	rootCmd.Flags().BoolVar(&debug, "debug", false, "Enable debug mode")
+	rootCmd.Flags().StringVar(&keyFile, "key-file", "", "Use the key stored by keys migrate instead of generating one")
+	rootCmd.Flags().BoolVar(&rotateKey, "rotate-key", false, "Replace the key in --key-file with a new one and rewrap existing records to it")
 }
 
 func runStart(cmd *cobra.Command, args []string) {
@@ -67,8 +82,25 @@ func runStart(cmd *cobra.Command, args []string) {
 	ctx := types.NewContext()
 	ctx = ctx.WithConfig(config)
 
-	// generate keys
-	keyPairs, err := keys.GenerateKeyPair(2048, debug)
+	// make sure that TEE hardware
+	attestor, err := tee.NewAttestor(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// generate keys, or take over those of a replaced node
+	if rotateKey && keyFile == "" {
+		log.Fatal("--rotate-key requires --key-file, the key it rotates")
+	}
+	var keyPairs *keys.KeyPair
+	if keyFile != "" {
+		var sealingKey []byte
+		if sealingKey, err = keyFileSealingKey(attestor); err == nil {
+			keyPairs, err = loadNodeKeyPair(keyFile, config.Keys.Type, rotateKey, sealingKey)
+		}
+	} else {
+		keyPairs, err = keys.NewKeyPair(config.Keys.Type, debug)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -105,28 +137,61 @@ func runStart(cmd *cobra.Command, args []string) {
 		time.Sleep(time.Second * 20)
 	}
 
-	// make sure that TEE hardware
-	attestor, err := tee.NewAttestor()
+	registeredKey, err := keyPairs.RegisteredKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// keys too large for TEEState can only be found in the attestation bundle
+	if _, err := keys.ParseRegisteredPublicKey(registeredKey); errors.Is(err, keys.ErrKeyNotOnChain) && config.IPFS.PinataJWT == "" {
+		log.Fatalf("keys.type %q requires ipfs.pinata-jwt, as its public key is published in the attestation bundle", keyPairs.Type())
+	}
+
+	binaryHash, configHash, err := tee.MeasureSoftware(cfgPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pubKeyBase64, err := keyPairs.ExportPublicKeyBase64()
+	// network state is filled in on every attestation
+	nonceInput := tee.NonceInput{
+		ProgramID:  solanaClient.GetProgramID(),
+		Address:    solanaClient.GetPubKey(),
+		PublicKey:  registeredKey,
+		BinaryHash: binaryHash,
+		ConfigHash: configHash,
+		Version:    Version,
+	}
+
+	round, err := attest(*ctx, solanaClient, attestor, nonceInput)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	nonce, err := tee.BuildAttestationNonce(solanaClient.GetPubKeyString(), pubKeyBase64)
+	attestationURI, err := round.publish(config, solanaClient.GetPubKeyString())
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	report, err := attestor.GenerateAttestationReport(nonce)
+	// a restarted node refreshes its existing registration with its new key
+	registered, err := solanaClient.IsTEENodeRegistered(*ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	signature, err := solanaClient.RegisterTEENode(*ctx, []byte(pubKeyBase64), report)
+	var signature *solanago.Signature
+	if registered {
+		// with a reused wallet the node may have been retired or revoked
+		status, err := solanaClient.GetTEEStatus(*ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if status != solana.TEEStatusActive {
+			log.Fatalf("TEE node %s is %s on chain; start with a new wallet", solanaClient.GetPubKeyString(), status)
+		}
+
+		signature, err = solanaClient.UpdateTEEAttestation(*ctx, registeredKey, round.commitment.ReportHash, round.commitment.Measurement, attestationURI)
+	} else {
+		signature, err = solanaClient.RegisterTEENode(*ctx, registeredKey, round.commitment.ReportHash, round.commitment.Measurement, attestationURI)
+	}
 	if err != nil {
 		if debug {
 			fmt.Println(err)
@@ -137,8 +202,50 @@ func runStart(cmd *cobra.Command, args []string) {
 
 	fmt.Println(signature)
 
-	startRESTServer(ctx, config, solanaClient, keyPairs)
+	statusWatcher, err := NewStatusWatcher(*ctx, config, solanaClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go statusWatcher.Run()
+
+	reattestor, err := NewReattestor(*ctx, config, solanaClient, attestor, nonceInput, round, statusWatcher)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go reattestor.Run()
+
+	// the new key is registered, so records can move over to it
+	var rotation *keyRotation
+	if keyPairs.Previous() != nil {
+		rotation, err = newKeyRotation(*ctx, config, solanaClient, keyPairs, keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go rotation.Run()
+	}
+
+	startRESTServer(ctx, config, solanaClient, keyPairs, attestor, nonceInput, statusWatcher, rotation)
+
+}
+
+// publishAttestationBundle pins the full attestation evidence to IPFS and
+// returns the uri to register on chain
+func publishAttestationBundle(cfg *config.Config, address string, bundle *tee.AttestationBundle) (string, error) {
+	if cfg.IPFS.PinataJWT == "" {
+		if cfg.Solana.NetworkType == "mainnet" {
+			return "", fmt.Errorf("ipfs.pinata-jwt is required to publish the attestation bundle")
+		}
+		fmt.Println("⚠️  ipfs.pinata-jwt not set, attestation bundle will not be published")
+		return "", nil
+	}
+
+	cid, err := UploadJsonToPinata(cfg.IPFS.PinataJWT, "attestation-"+address, bundle)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish attestation bundle: %w", err)
+	}
+	fmt.Println("Attestation bundle published:", cid)
 
+	return "ipfs://" + cid, nil
 }
 
 func printConfigPretty(config *config.Config) {
@@ -146,16 +253,90 @@ func printConfigPretty(config *config.Config) {
 	fmt.Printf("Solana RPC: %s\n", config.Solana.RPC)
 	fmt.Printf("Solana WebSocket: %s\n", config.Solana.WebSocket)
 	fmt.Printf("Solana Program ID: %s\n", config.Solana.ProgramID)
+	fmt.Printf("Version: %s\n", Version)
 	fmt.Printf("REST Port: %d\n", config.Rest.Port)
+	fmt.Printf("REST TLS: %t\n", config.Rest.TLS)
 	fmt.Println("********************")
 }
 
-func startRESTServer(ctx *types.Context, cfg *config.Config, solClient *solana.Client, keyPairs *keys.KeyPair) {
-	http.HandleFunc("/download-record", DecryptAndServeHandler(*ctx, solClient, keyPairs))
-	http.HandleFunc("/upload-record", UploadRecordHandler(*ctx, solClient, keyPairs))
+func startRESTServer(ctx *types.Context, cfg *config.Config, solClient *solana.Client, keyPairs *keys.KeyPair, attestor tee.Attestor, nonceInput tee.NonceInput, status *StatusWatcher, rotation *keyRotation) {
+	var thresholdDec *thresholdDecryptor
+	if cfg.Threshold.Enabled {
+		var err error
+		thresholdDec, err = newThresholdDecryptor(*ctx, cfg, solClient, keyPairs, attestor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.HandleFunc(threshold.SharePath, status.RequireActive(thresholdDec.node.ShareHandler()))
+	}
+
+	if cfg.Migration.Enabled {
+		handler, err := migrationHandler(cfg, keyPairs, attestor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.HandleFunc(migrate.Path, status.RequireActive(handler))
+	}
+
+	var kmsSvc *kmsService
+	if cfg.KMS.Enabled {
+		var err error
+		kmsSvc, err = newKMSService(*ctx, cfg, solClient, keyPairs, attestor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go kmsSvc.Run()
+		http.HandleFunc(kms.ReplicatePath, status.RequireActive(kmsSvc.node.ReplicateHandler()))
+		http.HandleFunc(kms.SyncPath, status.RequireActive(kmsSvc.node.SyncHandler()))
+		// a node that is not active learns of deletions from peers once it
+		// is active again and syncs
+		http.HandleFunc(kms.DestroyPath, status.RequireActive(kmsSvc.node.DestroyHandler()))
+		http.HandleFunc("/v1/kms/records", status.RequireActive(KMSSealHandler(cfg, solClient, kmsSvc)))
+	}
+
+	if rotation != nil {
+		http.HandleFunc(RotationPath, rotation.Handler())
+	}
+
+	tokens := newDownloadTokens()
+	http.HandleFunc(DownloadTokenPath, status.RequireActive(DownloadTokenHandler(*ctx, solClient, tokens)))
+	http.HandleFunc("/download-record", status.RequireActive(DecryptAndServeHandler(*ctx, solClient, keyPairs, thresholdDec, kmsSvc, tokens)))
+	stagingKey, err := newStagingKey(attestor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	janitor, err := newStagingJanitor(*ctx, cfg, stagingKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go janitor.Run()
+	http.HandleFunc("/upload-record", UploadRecordHandler(*ctx, cfg, solClient, keyPairs, janitor.store))
+	http.HandleFunc(SealUploadPath, SealUploadHandler(*ctx, cfg, solClient, janitor.store))
+
+	uploads, err := newResumableUploads(*ctx, cfg, stagingKey, janitor.store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go uploads.Run()
+	http.HandleFunc(tus.Path, uploads.server.Handler())
+	http.HandleFunc(tus.Path+"/", uploads.server.Handler())
+	http.HandleFunc("/v1/attestation", AttestationHandler(*ctx, solClient, attestor, nonceInput))
 
 	addr := ":" + strconv.Itoa(cfg.Rest.Port)
-	fmt.Printf("Starting REST server at http://localhost%s\n", ":8085")
+
+	if cfg.Rest.TLS {
+		server := &http.Server{
+			Addr:      addr,
+			TLSConfig: ratls.ServerConfig(ratls.NewCertificateProvider(attestor, ratls.DefaultValidity)),
+		}
+		fmt.Printf("Starting REST server at https://localhost%s\n", addr)
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			log.Fatalf("Failed to start REST server: %v", err)
+		}
+		return
+	}
+
+	fmt.Printf("Starting REST server at http://localhost%s\n", addr)
 	if err := http.ListenAndServe(addr, nil); err != nil {
 		log.Fatalf("Failed to start REST server: %v", err)
 	}
@@ -167,18 +348,20 @@ type DecryptRequest struct {
 	Signature   string `json:"signature"` // Signed message
 	RecordOwner string `json:"recordOwner"`
 	RecordID    uint64 `json:"recordId"`
+	// OwnerShare is the owner's keys.OwnerShareGrant sealed to this node,
+	// required for dual control records, see the grant-share command
+	OwnerShare *keys.Recipient `json:"ownerShare,omitempty"`
+	// ResponseKey, when set, has the record returned sealed to the requester
+	// rather than in plaintext: keys.ResponseKeySigner for the X25519 form
+	// of the signer's key, or a base64 X25519 public key. It is part of the
+	// signed message, see accessMessage.
+	ResponseKey string `json:"responseKey,omitempty"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
-type FrontendEncryptedPayload struct {
-	EncryptedAESKey string `json:"encrypted_aes_key"`
-	Ciphertext      string `json:"ciphertext"`
-	Nonce           string `json:"nonce"`
-}
-
 func writeJSONError(w http.ResponseWriter, msg string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -216,18 +399,13 @@ func detectFileType(data []byte) string {
 	return "unknown"
 }
 
-func DecryptAndServeHandler(ctx types.Context, solClient *solana.Client, keypair *keys.KeyPair) http.HandlerFunc {
+func DecryptAndServeHandler(ctx types.Context, solClient *solana.Client, keypair *keys.KeyPair, thresholdDec *thresholdDecryptor, kmsSvc *kmsService, tokens *downloadTokens) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("📩 Request incoming")
 
-		if r.Method != http.MethodPost {
-			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		var req DecryptRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSONError(w, "Invalid JSON", http.StatusBadRequest)
+		req, body, reqErr := decryptRequest(w, r, tokens)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
 			return
 		}
 
@@ -237,243 +415,496 @@ func DecryptAndServeHandler(ctx types.Context, solClient *solana.Client, keypair
 			return
 		}
 
-		// Parse pubkeys
-		recordOwnerPubkey, err := solanago.PublicKeyFromBase58(req.RecordOwner)
-		if err != nil {
-			writeJSONError(w, "Invalid record_owner pubkey", http.StatusBadRequest)
+		record, reqErr := authorizeRecordAccess(ctx, solClient, req)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
 			return
 		}
-		signerPubkey, err := solanago.PublicKeyFromBase58(req.Signer)
-		if err != nil {
-			writeJSONError(w, "Invalid signer pubkey", http.StatusBadRequest)
+		responseKey, reqErr := requestResponseKey(req)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
 			return
 		}
 
-		// Construct and verify signature
-		message := fmt.Sprintf("record-access:%s:%s:%d", req.Signer, req.RecordOwner, req.RecordID)
-		sig, err := solanago.SignatureFromBase58(req.Signature)
+		blob, err := openPinataBlob(req.CID)
 		if err != nil {
-			writeJSONError(w, "Invalid signature", http.StatusBadRequest)
-			return
-		}
-		if !sig.Verify(signerPubkey, []byte(message)) {
-			writeJSONError(w, "Signature verification failed", http.StatusUnauthorized)
+			fmt.Printf("❌ Failed to fetch from IPFS: %v\n", err)
+			writeJSONError(w, "Failed to fetch file from IPFS", http.StatusBadGateway)
 			return
 		}
+		defer blob.Close()
 
-		// Read from Solana
-		record, err := solClient.ReadHealthRecord(ctx, recordOwnerPubkey, req.RecordID)
+		// the blob must have been encrypted for the record it is requested as;
+		// of streamed envelopes only the header is read here
+		env, content, err := keys.ReadEnvelopeAt(blob, blob.Size())
 		if err != nil {
-			writeJSONError(w, "Failed to fetch health record", http.StatusInternalServerError)
+			fmt.Printf("❌ Failed to parse record envelope: %v\n", err)
+			writeJSONError(w, "Unsupported or malformed record data", http.StatusUnprocessableEntity)
 			return
 		}
-
-		if !record.Owner.Equals(recordOwnerPubkey) {
-			authorized := false
-			for i := 0; i < len(record.AccessList); i++ {
-				if record.AccessList[i].Organization.Equals(signerPubkey) {
-					authorized = true
-					break
-				}
-			}
-
-			if !authorized {
-				writeJSONError(w, "Not authorized to view this document", http.StatusUnauthorized)
-				return
-			}
-		}
-
-		ipfsData, err := DownloadJsonFromPinata(req.CID)
-		if err != nil {
-			fmt.Printf("❌ Failed to fetch from IPFS: %v\n", err)
-			writeJSONError(w, "Failed to fetch file from IPFS", http.StatusBadGateway)
+		binding := recordBinding(solClient, record)
+		if err := env.CheckBinding(binding); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			writeJSONError(w, "Record data does not belong to this record", http.StatusUnprocessableEntity)
 			return
 		}
 
-		fmt.Printf("📦 Fetched %d bytes from IPFS\n", len(ipfsData))
-
-		// 🔓 Parse encrypted payload (matches your frontend format)
-		var enc FrontendEncryptedPayload
-		if err := json.Unmarshal(ipfsData, &enc); err != nil {
-			fmt.Printf("❌ Failed to parse encrypted payload: %v\n", err)
-			writeJSONError(w, "Invalid encrypted payload format", http.StatusBadRequest)
+		// 🔓 Unwrap this node's entry of the envelope and decrypt. The first
+		// segment is decrypted here, so a record that fails authentication
+		// is still reported before the response starts.
+		dataKey, reqErr := recordDataKey(r.Context(), keypair, thresholdDec, kmsSvc, env, binding, req, body)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
 			return
 		}
-
-		// Validate required fields in encrypted payload
-		if enc.EncryptedAESKey == "" || enc.Ciphertext == "" || enc.Nonce == "" {
-			writeJSONError(w, "Missing required fields in encrypted payload", http.StatusBadRequest)
+		plaintext, err := keys.OpenStreamAt(env, dataKey, content)
+		if err != nil {
+			fmt.Printf("❌ Failed to decrypt record: %v\n", err)
+			writeJSONError(w, "Decryption failed - invalid data or key", http.StatusUnauthorized)
 			return
 		}
-
-		// 🔓 Decode base64 values
-		encryptedAESKey, err := base64.StdEncoding.DecodeString(enc.EncryptedAESKey)
+		size, err := plaintext.Seek(0, io.SeekEnd)
 		if err != nil {
-			fmt.Printf("❌ Failed to decode encrypted AES key: %v\n", err)
-			writeJSONError(w, "Invalid base64: Encrypted AES key", http.StatusBadRequest)
+			writeJSONError(w, "Decryption failed - invalid data or key", http.StatusUnauthorized)
 			return
 		}
 
-		ciphertext, err := base64.StdEncoding.DecodeString(enc.Ciphertext)
-		if err != nil {
-			fmt.Printf("❌ Failed to decode ciphertext: %v\n", err)
-			writeJSONError(w, "Invalid base64: Ciphertext", http.StatusBadRequest)
+		// ✅ Detect file type from decrypted content for better client handling
+		head := make([]byte, 8)
+		if _, err := plaintext.Seek(0, io.SeekStart); err != nil {
+			writeJSONError(w, "Decryption failed - invalid data or key", http.StatusUnauthorized)
 			return
 		}
-
-		nonce, err := base64.StdEncoding.DecodeString(enc.Nonce)
-		if err != nil {
-			fmt.Printf("❌ Failed to decode nonce: %v\n", err)
-			writeJSONError(w, "Invalid base64: Nonce", http.StatusBadRequest)
+		n, err := io.ReadFull(plaintext, head)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			fmt.Printf("❌ Failed to decrypt record: %v\n", err)
+			writeJSONError(w, "Decryption failed - invalid data or key", http.StatusUnauthorized)
 			return
 		}
-
-		// Validate nonce length (AES-GCM standard is 12 bytes)
-		if len(nonce) != 12 {
-			fmt.Printf("❌ Invalid nonce length: got %d, expected 12\n", len(nonce))
-			writeJSONError(w, "Invalid nonce length (expected 12 bytes)", http.StatusBadRequest)
+		if _, err := plaintext.Seek(0, io.SeekStart); err != nil {
+			writeJSONError(w, "Decryption failed - invalid data or key", http.StatusUnauthorized)
 			return
 		}
 
-		fmt.Printf("🔑 Decrypting AES key with RSA (key size: %d bytes)\n", len(encryptedAESKey))
-
-		// 🔐 Decrypt AES key with RSA OAEP (SHA-256) - matches your Android code
-		aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, keypair.PrivateKey, encryptedAESKey, nil)
-		if err != nil {
-			fmt.Printf("❌ Failed to decrypt AES key: %v\n", err)
-			writeJSONError(w, "Failed to decrypt AES key", http.StatusUnauthorized)
+		if responseKey != nil {
+			serveSealedResponse(w, r, plaintext, binding, responseKey)
 			return
 		}
 
-		fmt.Printf("🔓 AES key decrypted successfully (key length: %d bytes)\n", len(aesKey))
-
-		// 🔐 Decrypt ciphertext with AES-GCM
-		block, err := aes.NewCipher(aesKey)
-		if err != nil {
-			fmt.Printf("❌ Failed to create AES cipher: %v\n", err)
-			writeJSONError(w, "AES cipher init failed", http.StatusInternalServerError)
+		w.Header().Set("X-Detected-File-Type", detectFileType(head[:n]))
+		w.Header().Set("X-Original-Size", strconv.FormatInt(size, 10))
+		w.Header().Set("Vary", "Accept")
+
+		if wantsBinary(r, record.MimeType) {
+			// the mime type and title are whatever the owner put on chain, so
+			// the content must not run as a page of this origin
+			w.Header().Set("Content-Type", recordContentType(record.MimeType))
+			w.Header().Set("Content-Disposition", recordDisposition(record))
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Content-Security-Policy", "sandbox")
+			// a CID names its content, and so does the record decrypted from it
+			w.Header().Set("ETag", strconv.Quote(req.CID))
+			w.Header().Set("Cache-Control", "private, no-cache")
+
+			// ServeContent answers Range and conditional requests. A segment
+			// failing authentication past the first cuts the response short
+			// of its Content-Length, which clients report as an error.
+			http.ServeContent(w, r, "", time.Time{}, plaintext)
+			fmt.Printf("📤 Served record %s to client, binary\n", req.CID)
 			return
 		}
 
-		aesgcm, err := cipher.NewGCM(block)
-		if err != nil {
-			fmt.Printf("❌ Failed to create AES-GCM: %v\n", err)
-			writeJSONError(w, "AES-GCM init failed", http.StatusInternalServerError)
+		// Set headers to indicate this is base64-encoded binary data with file type info
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"decrypted_file\"")
+		w.Header().Set("Content-Length", strconv.FormatInt(int64(base64.StdEncoding.EncodedLen(int(size))), 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
 			return
 		}
 
-		fmt.Printf("🔓 Decrypting %d bytes of ciphertext\n", len(ciphertext))
-
-		plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+		// ✅ Serve decrypted data as base64 for React Native compatibility
+		encoder := base64.NewEncoder(base64.StdEncoding, w)
+		served, err := io.Copy(encoder, plaintext)
+		if err == nil {
+			err = encoder.Close()
+		}
 		if err != nil {
-			fmt.Printf("❌ AES decryption failed: %v\n", err)
-			writeJSONError(w, "AES decryption failed - invalid data or key", http.StatusUnauthorized)
-			return
+			fmt.Printf("❌ Failed to serve record after %d bytes: %v\n", served, err)
+			// the status is sent already; abort the response so the client
+			// cannot take a cut off record for a whole one
+			panic(http.ErrAbortHandler)
 		}
 
-		fmt.Printf("✅ Successfully decrypted %d bytes of data\n", len(plaintext))
+		fmt.Printf("📤 Successfully served %d bytes to client, base64 encoded\n", served)
+	}
+}
 
-		// ✅ Detect file type from decrypted content for better client handling
-		fileType := detectFileType(plaintext)
+// decryptRequest reads the DecryptRequest of r: the JSON body of a POST, or
+// for a GET or HEAD the request a download token in the query stands for,
+// which lets viewers fetch and seek in records by URL. It also returns the
+// request as JSON, the access proof forwarded to threshold peers.
+func decryptRequest(w http.ResponseWriter, r *http.Request, tokens *downloadTokens) (DecryptRequest, []byte, *requestError) {
+	switch r.Method {
+	case http.MethodPost:
+		return readDecryptRequest(w, r)
+
+	case http.MethodGet, http.MethodHead:
+		req, body, ok := tokens.lookup(r.URL.Query().Get("token"))
+		if !ok {
+			return req, nil, &requestError{"Missing or expired download token, see " + DownloadTokenPath, http.StatusUnauthorized}
+		}
+		return req, body, nil
+	}
+	return DecryptRequest{}, nil, &requestError{"Method Not Allowed", http.StatusMethodNotAllowed}
+}
 
-		// ✅ Serve decrypted data as base64 for React Native compatibility
-		base64Data := base64.StdEncoding.EncodeToString(plaintext)
+// requestResponseKey returns the key the record is to be sealed to for the
+// requester, or nil to return it in plaintext
+func requestResponseKey(req DecryptRequest) (*ecdh.PublicKey, *requestError) {
+	if req.ResponseKey == "" {
+		return nil, nil
+	}
+	// checked by authorizeRecordAccess
+	signer, err := solanago.PublicKeyFromBase58(req.Signer)
+	if err != nil {
+		return nil, &requestError{"Invalid signer pubkey", http.StatusBadRequest}
+	}
+	key, err := keys.ParseResponseKey(req.ResponseKey, signer)
+	if err != nil {
+		return nil, &requestError{"Invalid responseKey", http.StatusBadRequest}
+	}
+	return key, nil
+}
 
-		// Set headers to indicate this is base64-encoded binary data with file type info
+// serveSealedResponse serves plaintext sealed to the requester's key as it
+// is read, in binary or base64 as for plaintext records. Every response is
+// sealed anew, so there is no ETag or Range support, and nothing about the
+// record is sent in the clear but its binding in the envelope.
+func serveSealedResponse(w http.ResponseWriter, r *http.Request, plaintext io.Reader, binding keys.RecordBinding, key *ecdh.PublicKey) {
+	binary := wantsBinary(r, "")
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Response-Encryption", "envelope")
+	if binary {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Header().Set("Content-Disposition", "attachment; filename=\"decrypted_file\"")
-		w.Header().Set("Content-Length", strconv.Itoa(len(base64Data)))
-		w.Header().Set("X-Original-Size", strconv.Itoa(len(plaintext)))
-		w.Header().Set("X-Detected-File-Type", fileType)
-		w.WriteHeader(http.StatusOK)
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
 
-		bytesWritten, err := w.Write([]byte(base64Data))
-		if err != nil {
-			fmt.Printf("❌ Failed to write response: %v\n", err)
-			return
+	var out io.Writer = w
+	var encoder io.WriteCloser
+	if !binary {
+		encoder = base64.NewEncoder(base64.StdEncoding, w)
+		out = encoder
+	}
+	err := keys.SealResponse(out, plaintext, binding, key)
+	if err == nil && encoder != nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to serve sealed record: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	fmt.Println("📤 Successfully served record sealed to the requester")
+}
+
+// wantsBinary tells whether r asks for the record as it is rather than base64
+// encoded: with ?encoding=binary, or with an Accept header naming the record's
+// mime type, its type family, or application/octet-stream before text/plain.
+// Clients that say nothing, like the mobile app, keep getting base64.
+func wantsBinary(r *http.Request, mimeType string) bool {
+	switch r.URL.Query().Get("encoding") {
+	case "binary":
+		return true
+	case "base64":
+		return false
+	}
+
+	recordType, _, _ := mime.ParseMediaType(mimeType)
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch {
+		case mediaType == "text/plain":
+			return false
+		case mediaType == "application/octet-stream", recordType != "" && mediaType == recordType:
+			return true
+		case recordType != "" && strings.HasSuffix(mediaType, "/*") && mediaType != "*/*" &&
+			strings.HasPrefix(recordType, strings.TrimSuffix(mediaType, "*")):
+			return true
 		}
+	}
+	return false
+}
 
-		fmt.Printf("📤 Successfully served %d bytes (base64) to client, original size: %d bytes\n", bytesWritten, len(plaintext))
+// recordContentType returns the on-chain mime type of a record, if valid
+func recordContentType(mimeType string) string {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "application/octet-stream"
 	}
+	return mime.FormatMediaType(mediaType, params)
 }
 
-func UploadRecordHandler(ctx types.Context, solClient *solana.Client, keypair *keys.KeyPair) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
+// recordDisposition shows a record inline, under its title as the filename
+// with an extension for its mime type
+func recordDisposition(record *solana.HealthRecord) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(record.Title))
+	if name == "" {
+		name = "record"
+	}
+	if filepath.Ext(name) == "" {
+		if mediaType, _, err := mime.ParseMediaType(record.MimeType); err == nil {
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				name += exts[0]
+			}
 		}
+	}
 
-		// Limit upload size to 5MB
-		r.Body = http.MaxBytesReader(w, r.Body, 5<<20) // 5MB
+	if disposition := mime.FormatMediaType("inline", map[string]string{"filename": name}); disposition != "" {
+		return disposition
+	}
+	return `inline; filename="record"`
+}
 
-		// Parse multipart form (up to 5MB)
-		err := r.ParseMultipartForm(5 << 20)
-		if err != nil {
-			writeJSONError(w, "File too large or malformed form", http.StatusBadRequest)
-			return
-		}
+// recordDataKey returns the data key of env: from this node's entry, from
+// the key management service, from threshold peers with proof, or from this
+// node's entry and the owner's share in req
+func recordDataKey(ctx context.Context, keypair *keys.KeyPair, thresholdDec *thresholdDecryptor, kmsSvc *kmsService, env *keys.Envelope, binding keys.RecordBinding, req DecryptRequest, proof []byte) ([]byte, *requestError) {
+	dataKey, err := keypair.DataKey(env)
+	switch {
+	case errors.Is(err, keys.ErrKMSEnvelope):
+		if kmsSvc == nil {
+			return nil, &requestError{"Key management is not enabled on this TEE node", http.StatusUnprocessableEntity}
+		}
+		return kmsSvc.dataKey(env, binding)
+	case errors.Is(err, keys.ErrThresholdEnvelope):
+		if thresholdDec == nil {
+			return nil, &requestError{"Threshold decryption is not enabled on this TEE node", http.StatusUnprocessableEntity}
+		}
+		dataKey, err = thresholdDec.dataKey(ctx, env, proof)
+	case errors.Is(err, keys.ErrDualControlEnvelope):
+		// the TEE share alone does not open the record
+		grant, reqErr := ownerShareGrant(keypair, req, binding)
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		dataKey, err = keypair.DualControlKey(env, grant.Share)
+	}
+	if errors.Is(err, keys.ErrNotARecipient) {
+		return nil, &requestError{"Record is not encrypted for this TEE node", http.StatusUnprocessableEntity}
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to decrypt record: %v\n", err)
+		return nil, &requestError{"Decryption failed - invalid data or key", http.StatusUnauthorized}
+	}
+	return dataKey, nil
+}
 
-		signer := r.FormValue("signer")
-		if signer == "" {
-			writeJSONError(w, "Missing signer address", http.StatusBadRequest)
-			return
-		}
+// requestError is an access check failure to report to the client as is
+type requestError struct {
+	msg  string
+	code int
+}
 
-		signerPubkey, err := solanago.PublicKeyFromBase58(signer)
-		if err != nil {
-			writeJSONError(w, "Invalid signer pubkey", http.StatusBadRequest)
-			return
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// authorizeRecordAccess checks that req is signed by the record owner or by an
+// organization on its access list and asks for the data the record points
+// to, and returns the record
+func authorizeRecordAccess(ctx types.Context, solClient *solana.Client, req DecryptRequest) (*solana.HealthRecord, *requestError) {
+	recordOwnerPubkey, signerPubkey, reqErr := verifyAccessRequest(req)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+
+	// Read from Solana
+	record, err := solClient.ReadHealthRecord(ctx, recordOwnerPubkey, req.RecordID)
+	if err != nil {
+		return nil, &requestError{"Failed to fetch health record", http.StatusInternalServerError}
+	}
+
+	if reqErr := checkRecordAccess(record, recordOwnerPubkey, signerPubkey); reqErr != nil {
+		return nil, reqErr
+	}
+	// unbound envelopes of earlier versions name no record, so only the CID
+	// on chain keeps them from being served as another record
+	if req.CID != record.Checksum {
+		return nil, &requestError{"CID is not the data of this record", http.StatusUnprocessableEntity}
+	}
+	return record, nil
+}
+
+// verifyAccessRequest checks the signature of req and returns the record
+// owner it names and its signer. A valid signature only proves who asks, not
+// that they may read the record, see checkRecordAccess.
+func verifyAccessRequest(req DecryptRequest) (solanago.PublicKey, solanago.PublicKey, *requestError) {
+	// Parse pubkeys
+	recordOwnerPubkey, err := solanago.PublicKeyFromBase58(req.RecordOwner)
+	if err != nil {
+		return solanago.PublicKey{}, solanago.PublicKey{}, &requestError{"Invalid record_owner pubkey", http.StatusBadRequest}
+	}
+	signerPubkey, err := solanago.PublicKeyFromBase58(req.Signer)
+	if err != nil {
+		return solanago.PublicKey{}, solanago.PublicKey{}, &requestError{"Invalid signer pubkey", http.StatusBadRequest}
+	}
+
+	// Construct and verify signature
+	message := accessMessage(req)
+	sig, err := solanago.SignatureFromBase58(req.Signature)
+	if err != nil {
+		return solanago.PublicKey{}, solanago.PublicKey{}, &requestError{"Invalid signature", http.StatusBadRequest}
+	}
+	if !sig.Verify(signerPubkey, []byte(message)) {
+		return solanago.PublicKey{}, solanago.PublicKey{}, &requestError{"Signature verification failed", http.StatusUnauthorized}
+	}
+	return recordOwnerPubkey, signerPubkey, nil
+}
+
+// accessMessage is what the signer of req signs. A response key is signed
+// along, so nobody replaying the signature can have the record sealed to
+// another key, or returned in plaintext.
+func accessMessage(req DecryptRequest) string {
+	message := fmt.Sprintf("record-access:%s:%s:%d", req.Signer, req.RecordOwner, req.RecordID)
+	if req.ResponseKey != "" {
+		message += ":" + req.ResponseKey
+	}
+	return message
+}
+
+// checkRecordAccess lets signer read record if it is the record's owner or an
+// organization on its access list. The owner a request names must be the
+// record's.
+func checkRecordAccess(record *solana.HealthRecord, owner, signer solanago.PublicKey) *requestError {
+	if !record.Owner.Equals(owner) {
+		return &requestError{"Record does not belong to record_owner", http.StatusUnauthorized}
+	}
+	if signer.Equals(record.Owner) {
+		return nil
+	}
+	for _, access := range record.AccessList {
+		if access.Organization.Equals(signer) {
+			return nil
 		}
+	}
+	return &requestError{"Not authorized to view this document", http.StatusUnauthorized}
+}
 
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			writeJSONError(w, "Missing file", http.StatusBadRequest)
+// recordBinding is what envelopes of record must be bound to
+func recordBinding(solClient *solana.Client, record *solana.HealthRecord) keys.RecordBinding {
+	return keys.RecordBinding{
+		ProgramID: solClient.GetProgramID(),
+		Owner:     record.Owner,
+		RecordID:  record.RecordID,
+		MimeType:  record.MimeType,
+	}
+}
+
+// UploadRecordHandler stages an uploaded file, encrypted, under
+// upload/<signer>. The file is written to disk as it is received, so the
+// signer field must come before it in the form.
+func UploadRecordHandler(ctx types.Context, cfg *config.Config, solClient *solana.Client, keypair *keys.KeyPair, store *staging.Store) http.HandlerFunc {
+	maxUploadMB := cfg.Rest.MaxUploadMB
+	if maxUploadMB <= 0 {
+		maxUploadMB = defaultMaxUploadMB
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		defer file.Close()
 
-		// Read file and compute SHA-256 checksum
-		fileBytes, err := io.ReadAll(file)
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxUploadMB)<<20)
+
+		form, err := r.MultipartReader()
 		if err != nil {
-			writeJSONError(w, "Failed to read uploaded file", http.StatusInternalServerError)
+			writeJSONError(w, "File too large or malformed form", http.StatusBadRequest)
 			return
 		}
 
-		checksum := fmt.Sprintf("%x", sha256.Sum256(fileBytes))
-		dirPath := filepath.Join("upload", signerPubkey.String())
-		filePath := filepath.Join(dirPath, checksum)
+		var signer string
+		for {
+			part, err := form.NextPart()
+			if errors.Is(err, io.EOF) {
+				writeJSONError(w, "Missing file", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				writeJSONError(w, "File too large or malformed form", http.StatusBadRequest)
+				return
+			}
 
-		// If file already exists, don't overwrite
-		if _, err := os.Stat(filePath); err == nil {
-			resp := map[string]string{
-				"checksum": checksum,
-				"path":     filePath,
-				"status":   "already exists",
+			switch part.FormName() {
+			case "signer":
+				value, err := io.ReadAll(io.LimitReader(part, 1<<10))
+				if err != nil {
+					writeJSONError(w, "File too large or malformed form", http.StatusBadRequest)
+					return
+				}
+				signer = string(value)
+			case "file":
+				if signer == "" {
+					writeJSONError(w, "Missing signer address", http.StatusBadRequest)
+					return
+				}
+				signerPubkey, err := solanago.PublicKeyFromBase58(signer)
+				if err != nil {
+					writeJSONError(w, "Invalid signer pubkey", http.StatusBadRequest)
+					return
+				}
+				serveStagedUpload(w, store, signerPubkey, part)
+				return
 			}
-			writeJSON(w, resp)
-			return
 		}
+	}
+}
 
-		// Ensure directory exists
-		if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
-			writeJSONError(w, "Failed to create upload directory", http.StatusInternalServerError)
-			return
-		}
+// serveStagedUpload stages file for signer and responds with its checksum
+// and storage path
+func serveStagedUpload(w http.ResponseWriter, store *staging.Store, signer solanago.PublicKey, file io.Reader) {
+	staged, existed, err := store.Put(signer, file)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to stage upload: %v\n", err)
+		writeJSONError(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
 
-		// Save file
-		if err := os.WriteFile(filePath, fileBytes, 0644); err != nil {
-			writeJSONError(w, "Failed to save file", http.StatusInternalServerError)
-			return
-		}
+	writeJSON(w, uploadResponse(staged, existed))
+}
 
-		// Respond with checksum and storage path
-		resp := map[string]string{
-			"checksum": checksum,
-			"path":     fmt.Sprintf("upload/%s/%s", signerPubkey.String(), checksum),
-		}
-		writeJSON(w, resp)
+// uploadResponse tells the client the checksum and storage path of a staged
+// file
+func uploadResponse(staged *staging.File, existed bool) map[string]string {
+	resp := map[string]string{
+		"checksum": staged.Checksum,
+		"path":     staged.Path,
+	}
+	// If file already exists, it is not overwritten
+	if existed {
+		resp["status"] = "already exists"
 	}
+	return resp
 }
 
 // writeJSON writes a map or struct as a JSON response
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/solana"
+)
+
+// signedRequest is a request for record 7 of owner, signed by signer
+func signedRequest(t *testing.T, signer solanago.PrivateKey, owner solanago.PublicKey) DecryptRequest {
+	req := DecryptRequest{Signer: signer.PublicKey().String(), RecordOwner: owner.String(), RecordID: 7}
+	sig, err := signer.Sign([]byte(fmt.Sprintf("record-access:%s:%s:%d", req.Signer, req.RecordOwner, req.RecordID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Signature = sig.String()
+	return req
+}
+
+func TestRecordAccess(t *testing.T) {
+	owner := solanago.NewWallet()
+	org := solanago.NewWallet()
+	stranger := solanago.NewWallet()
+	record := &solana.HealthRecord{
+		Owner:      owner.PublicKey(),
+		RecordID:   7,
+		AccessList: []solana.AccessPermission{{Organization: org.PublicKey()}},
+	}
+
+	cases := []struct {
+		name   string
+		signer solanago.PrivateKey
+		owner  solanago.PublicKey
+		status int
+	}{
+		{"owner", owner.PrivateKey, owner.PublicKey(), 0},
+		{"organization with access", org.PrivateKey, owner.PublicKey(), 0},
+		// a valid signature of a wallet the record knows nothing about
+		{"stranger", stranger.PrivateKey, owner.PublicKey(), http.StatusUnauthorized},
+		{"stranger naming itself as owner", stranger.PrivateKey, stranger.PublicKey(), http.StatusUnauthorized},
+		{"organization naming itself as owner", org.PrivateKey, org.PublicKey(), http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := signedRequest(t, c.signer, c.owner)
+			claimedOwner, signer, reqErr := verifyAccessRequest(req)
+			if reqErr != nil {
+				t.Fatalf("signature refused: %v", reqErr)
+			}
+			reqErr = checkRecordAccess(record, claimedOwner, signer)
+			switch {
+			case c.status == 0 && reqErr != nil:
+				t.Errorf("refused: %v", reqErr)
+			case c.status != 0 && (reqErr == nil || reqErr.code != c.status):
+				t.Errorf("expected %d, got %v", c.status, reqErr)
+			}
+		})
+	}
+
+	forged := signedRequest(t, stranger.PrivateKey, owner.PublicKey())
+	forged.Signer = owner.PublicKey().String()
+	if _, _, reqErr := verifyAccessRequest(forged); reqErr == nil {
+		t.Error("accepted a signature of another wallet")
+	}
+}
+
+func TestResponseKeyIsSigned(t *testing.T) {
+	signer := solanago.NewWallet()
+	req := DecryptRequest{
+		Signer:      signer.PublicKey().String(),
+		RecordOwner: solanago.NewWallet().PublicKey().String(),
+		RecordID:    7,
+		ResponseKey: "signer",
+	}
+	sig, err := signer.PrivateKey.Sign([]byte(accessMessage(req)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Signature = sig.String()
+	if _, _, reqErr := verifyAccessRequest(req); reqErr != nil {
+		t.Fatalf("refused: %v", reqErr)
+	}
+
+	// a proxy replaying the signature can neither swap the key nor drop it
+	for _, responseKey := range []string{"cHJveHkga2V5IHByb3h5IGtleSBwcm94eSBrZXkgISE=", ""} {
+		replayed := req
+		replayed.ResponseKey = responseKey
+		if _, _, reqErr := verifyAccessRequest(replayed); reqErr == nil {
+			t.Errorf("accepted the signature with response key %q", responseKey)
+		}
+	}
+}
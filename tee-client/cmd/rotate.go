@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/internal/fsutil"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	defaultRotationInterval = time.Hour
+
+	// RotationPath serves the CID updates of a key rotation
+	RotationPath = "/v1/keys/rotation"
+)
+
+// previousKeyFile is where the key replaced by a rotation is kept until
+// every record is rewrapped
+func previousKeyFile(keyFile string) string {
+	return keyFile + ".previous"
+}
+
+// loadNodeKeyPair reads the key stored in keyFile. With rotate it replaces it
+// with a new key of keyType, keeping the old one next to it; either way a
+// previous key left there is loaded in decrypt-only mode. Both files are
+// sealed under sealingKey.
+func loadNodeKeyPair(keyFile, keyType string, rotate bool, sealingKey []byte) (*keys.KeyPair, error) {
+	kp, err := loadKeyPair(keyFile, sealingKey)
+	if err != nil {
+		return nil, err
+	}
+	prevFile := previousKeyFile(keyFile)
+
+	if rotate {
+		if _, err := os.Stat(prevFile); err == nil {
+			return nil, fmt.Errorf("%s holds the key of an unfinished rotation", prevFile)
+		}
+
+		next, err := keys.NewKeyPair(keyType, false)
+		if err != nil {
+			return nil, err
+		}
+		// the old key is stored first, so a crash cannot lose it
+		if err := saveKeyPair(prevFile, kp, sealingKey); err != nil {
+			return nil, err
+		}
+		if err := saveKeyPair(keyFile, next, sealingKey); err != nil {
+			return nil, err
+		}
+		next.SetPrevious(kp)
+		fmt.Printf("🔑 Rotated the node key; the previous key is kept in %s to decrypt until records are rewrapped\n", prevFile)
+		return next, nil
+	}
+
+	prev, err := loadKeyPair(prevFile, sealingKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return kp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kp.SetPrevious(prev)
+	fmt.Printf("🔑 Resuming the key rotation with the previous key in %s\n", prevFile)
+	return kp, nil
+}
+
+// CIDUpdate is a record whose envelope was rewrapped to the rotated key. Its
+// owner switches the record to NewCID with the update_record_data
+// instruction.
+type CIDUpdate struct {
+	Owner    solanago.PublicKey `json:"owner"`
+	RecordID uint64             `json:"recordId"`
+	OldCID   string             `json:"oldCid"`
+	NewCID   string             `json:"newCid"`
+}
+
+type recordRef struct {
+	owner    solanago.PublicKey
+	recordID uint64
+}
+
+// keyRotation moves every record from the previous key of the node to its
+// current one. It pins a rewrapped copy of each envelope and waits for the
+// owners to switch their records to it, then discards the previous key.
+type keyRotation struct {
+	ctx       types.Context
+	cfg       *config.Config
+	solClient *solana.Client
+	keypair   *keys.KeyPair
+	prevFile  string
+	interval  time.Duration
+
+	mu      sync.Mutex
+	updates map[recordRef]CIDUpdate
+}
+
+func newKeyRotation(ctx types.Context, cfg *config.Config, solClient *solana.Client, keypair *keys.KeyPair, keyFile string) (*keyRotation, error) {
+	if cfg.IPFS.PinataJWT == "" {
+		return nil, errors.New("key rotation requires ipfs.pinata-jwt, as this node pins the rewrapped records")
+	}
+	interval, err := parseInterval("keys.rotation-interval", cfg.Keys.RotationInterval, defaultRotationInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyRotation{
+		ctx:       ctx,
+		cfg:       cfg,
+		solClient: solClient,
+		keypair:   keypair,
+		prevFile:  previousKeyFile(keyFile),
+		interval:  interval,
+		updates:   make(map[recordRef]CIDUpdate),
+	}, nil
+}
+
+// Run walks all records now and then every interval, until none needs the
+// previous key or the context is cancelled
+func (r *keyRotation) Run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		pending, err := r.walk()
+		switch {
+		case err != nil:
+			fmt.Printf("⚠️  Key rotation walk failed: %v\n", err)
+		case pending == 0:
+			if err := r.finish(); err != nil {
+				fmt.Printf("❌ Failed to discard the previous key: %v\n", err)
+			} else {
+				return
+			}
+		default:
+			fmt.Printf("🔄 Key rotation: %d records still need the previous key\n", pending)
+		}
+
+		select {
+		case <-r.ctx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// walk rewraps the records that need it and returns how many records still
+// need the previous key
+func (r *keyRotation) walk() (int, error) {
+	records, err := r.solClient.ListHealthRecords(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, record := range records {
+		done, err := r.rewrap(record)
+		if err != nil {
+			fmt.Printf("⚠️  Failed to rewrap record %d of %s: %v\n", record.RecordID, record.Owner, err)
+		}
+		if !done {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// rewrap pins a copy of the envelope of record rewrapped to the current key,
+// if it needs one, and tells whether the record no longer needs the
+// previous key
+func (r *keyRotation) rewrap(record *solana.HealthRecord) (bool, error) {
+	ref := recordRef{owner: record.Owner, recordID: record.RecordID}
+	cid := record.Checksum
+
+	r.mu.Lock()
+	update, ok := r.updates[ref]
+	r.mu.Unlock()
+	if ok && update.OldCID == cid {
+		// waiting for the owner to sign
+		return false, nil
+	}
+
+	data, err := DownloadJsonFromPinata(cid)
+	if err != nil {
+		return false, err
+	}
+	// nobody can decrypt what is not an envelope
+	env, err := keys.ParseEnvelope(data)
+	if err != nil {
+		return true, nil
+	}
+
+	rewrapped, err := r.keypair.Rewrap(data)
+	switch {
+	case errors.Is(err, keys.ErrRewrapped), errors.Is(err, keys.ErrNotARecipient), errors.Is(err, keys.ErrKMSEnvelope):
+		r.mu.Lock()
+		delete(r.updates, ref)
+		r.mu.Unlock()
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	newCID, err := pinEnvelope(r.cfg.IPFS.PinataJWT, fmt.Sprintf("record-%s-%d", record.Owner, record.RecordID), env, rewrapped)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.updates[ref] = CIDUpdate{Owner: record.Owner, RecordID: record.RecordID, OldCID: cid, NewCID: newCID}
+	r.mu.Unlock()
+	fmt.Printf("🔁 Rewrapped record %d of %s: %s -> %s\n", record.RecordID, record.Owner, cid, newCID)
+	return false, nil
+}
+
+// finish discards the previous key once no record needs it
+func (r *keyRotation) finish() error {
+	if err := fsutil.Shred(r.prevFile); err != nil {
+		return err
+	}
+	r.keypair.SetPrevious(nil)
+	fmt.Println("✅ Key rotation finished, the previous key is discarded")
+	return nil
+}
+
+// RotationResponse lists the CID updates waiting for their owners
+type RotationResponse struct {
+	Updates []CIDUpdate `json:"updates"`
+}
+
+// Handler serves the pending CID updates, of one owner with ?owner=
+func (r *keyRotation) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var owner *solanago.PublicKey
+		if s := req.URL.Query().Get("owner"); s != "" {
+			pk, err := solanago.PublicKeyFromBase58(s)
+			if err != nil {
+				writeJSONError(w, "Invalid owner pubkey", http.StatusBadRequest)
+				return
+			}
+			owner = &pk
+		}
+
+		resp := RotationResponse{Updates: []CIDUpdate{}}
+		r.mu.Lock()
+		for _, update := range r.updates {
+			if owner == nil || update.Owner.Equals(*owner) {
+				resp.Updates = append(resp.Updates, update)
+			}
+		}
+		r.mu.Unlock()
+
+		sort.Slice(resp.Updates, func(i, j int) bool {
+			a, b := resp.Updates[i], resp.Updates[j]
+			if !a.Owner.Equals(b.Owner) {
+				return a.Owner.String() < b.Owner.String()
+			}
+			return a.RecordID < b.RecordID
+		})
+		writeJSON(w, resp)
+	}
+}
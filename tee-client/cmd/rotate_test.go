@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestRotationKeepsKeyFilesSealed(t *testing.T) {
+	sealingKey := bytes.Repeat([]byte{1}, 32)
+	keyFile := filepath.Join(t.TempDir(), "node.key")
+	old, err := keys.NewKeyPair(keys.KeyTypeX25519, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveKeyPair(keyFile, old, sealingKey); err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := loadNodeKeyPair(keyFile, keys.KeyTypeX25519, true, sealingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev := kp.Previous(); prev == nil || !prev.X25519.Equal(old.X25519) {
+		t.Fatal("previous key is not kept")
+	}
+	for _, path := range []string{keyFile, previousKeyFile(keyFile)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.OpenKeyFile(data, sealingKey); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+
+	r := &keyRotation{keypair: kp, prevFile: previousKeyFile(keyFile)}
+	if err := r.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(previousKeyFile(keyFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("previous key file left behind: %v", err)
+	}
+	if kp.Previous() != nil {
+		t.Error("previous key still in use")
+	}
+}
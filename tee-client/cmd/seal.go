@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/staging"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+// SealUploadPath seals a staged upload into a record and pins it
+const SealUploadPath = "/v1/records/seal"
+
+var errUploadAborted = errors.New("upload aborted")
+
+// SealUploadRequest asks to seal the file signer staged with checksum as
+// record record_id, signed over
+// "seal-upload:<signer>:<record_id>:<mime_type>:<checksum>"
+type SealUploadRequest struct {
+	Signer    string `json:"signer"`
+	Checksum  string `json:"checksum"`
+	RecordID  uint64 `json:"recordId"`
+	MimeType  string `json:"mimeType"`
+	Signature string `json:"signature"`
+}
+
+// SealUploadResponse tells the owner where the sealed record was pinned, for
+// the HealthRecord to be created with
+type SealUploadResponse struct {
+	CID string `json:"cid"`
+}
+
+// SealUploadHandler seals a file staged by /upload-record or /v1/uploads as a
+// streamed envelope to the active TEE nodes and pins it, reading, encrypting
+// and uploading it segment by segment so large files never sit in memory.
+// The staged file is shredded once pinned.
+func SealUploadHandler(ctx types.Context, cfg *config.Config, solClient *solana.Client, store *staging.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SealUploadRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4<<10)).Decode(&req); err != nil {
+			writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		owner, reqErr := verifySealUploadRequest(req)
+		if reqErr != nil {
+			writeJSONError(w, reqErr.msg, reqErr.code)
+			return
+		}
+
+		staged, err := store.Open(owner, req.Checksum)
+		if errors.Is(err, staging.ErrUnknownFile) {
+			writeJSONError(w, "No such staged upload", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Printf("❌ Failed to open staged upload: %v\n", err)
+			writeJSONError(w, "Failed to read staged upload", http.StatusInternalServerError)
+			return
+		}
+		defer staged.Close()
+
+		binding := keys.RecordBinding{
+			ProgramID: solClient.GetProgramID(),
+			Owner:     owner,
+			RecordID:  req.RecordID,
+			MimeType:  req.MimeType,
+		}
+		cid, sealErr, err := sealAndPin(cfg.IPFS.PinataJWT, fmt.Sprintf("record-%s-%d", owner, req.RecordID), func(w io.Writer) error {
+			return SealStreamForActiveNodes(ctx, solClient, w, staged, binding, keys.SealOptions{})
+		})
+		if sealErr != nil {
+			fmt.Printf("❌ Failed to seal record: %v\n", sealErr)
+			writeJSONError(w, "Failed to encrypt record", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			writeJSONError(w, "Failed to pin record", http.StatusBadGateway)
+			return
+		}
+
+		fmt.Printf("🔐 Sealed staged upload %s as record %d of %s: %s\n", req.Checksum, req.RecordID, owner, cid)
+		// the sealed copy is pinned, the plaintext has no reason to stay
+		staged.Close()
+		if err := store.Remove(owner, req.Checksum); err != nil {
+			fmt.Printf("⚠️  Failed to shred sealed upload %s: %v\n", req.Checksum, err)
+		}
+		writeJSON(w, SealUploadResponse{CID: cid})
+	}
+}
+
+// verifySealUploadRequest checks the signature of req and returns its signer,
+// who owns both the staged file and the record
+func verifySealUploadRequest(req SealUploadRequest) (solanago.PublicKey, *requestError) {
+	owner, err := solanago.PublicKeyFromBase58(req.Signer)
+	if err != nil {
+		return solanago.PublicKey{}, &requestError{"Invalid signer pubkey", http.StatusBadRequest}
+	}
+	if req.MimeType == "" {
+		return solanago.PublicKey{}, &requestError{"Missing mimeType", http.StatusBadRequest}
+	}
+
+	message := fmt.Sprintf("seal-upload:%s:%d:%s:%s", req.Signer, req.RecordID, req.MimeType, req.Checksum)
+	sig, err := solanago.SignatureFromBase58(req.Signature)
+	if err != nil {
+		return solanago.PublicKey{}, &requestError{"Invalid signature", http.StatusBadRequest}
+	}
+	if !sig.Verify(owner, []byte(message)) {
+		return solanago.PublicKey{}, &requestError{"Signature verification failed", http.StatusUnauthorized}
+	}
+	return owner, nil
+}
+
+// sealAndPin pins what seal writes as a file while it is being written. It
+// returns the error of seal separately, since a failed seal also fails the
+// upload.
+func sealAndPin(jwt, name string, seal func(w io.Writer) error) (cid string, sealErr, err error) {
+	pr, pw := io.Pipe()
+	sealed := make(chan error, 1)
+	go func() {
+		err := seal(pw)
+		pw.CloseWithError(err)
+		sealed <- err
+	}()
+
+	cid, err = UploadFileToPinata(jwt, name, pr)
+	// unblocks seal if the upload gave up early
+	pr.CloseWithError(errUploadAborted)
+	if sealErr = <-sealed; sealErr != nil && !errors.Is(sealErr, errUploadAborted) {
+		return "", sealErr, nil
+	}
+	return cid, nil, err
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+)
+
+func TestSealUploadRequestIsSigned(t *testing.T) {
+	owner := solanago.NewWallet()
+	req := SealUploadRequest{
+		Signer:   owner.PublicKey().String(),
+		Checksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		RecordID: 3,
+		MimeType: "application/dicom",
+	}
+	sig, err := owner.PrivateKey.Sign([]byte(fmt.Sprintf("seal-upload:%s:%d:%s:%s", req.Signer, req.RecordID, req.MimeType, req.Checksum)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Signature = sig.String()
+
+	got, reqErr := verifySealUploadRequest(req)
+	if reqErr != nil {
+		t.Fatal(reqErr)
+	}
+	if !got.Equals(owner.PublicKey()) {
+		t.Errorf("got owner %s", got)
+	}
+
+	// the signature covers the file, the record and how it is bound
+	for name, tamper := range map[string]func(*SealUploadRequest){
+		"checksum":  func(r *SealUploadRequest) { r.Checksum = "00" + r.Checksum[2:] },
+		"record id": func(r *SealUploadRequest) { r.RecordID++ },
+		"mime type": func(r *SealUploadRequest) { r.MimeType = "text/html" },
+		"signer":    func(r *SealUploadRequest) { r.Signer = solanago.NewWallet().PublicKey().String() },
+	} {
+		tampered := req
+		tamper(&tampered)
+		if _, reqErr := verifySealUploadRequest(tampered); reqErr == nil || reqErr.code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %v", name, reqErr)
+		}
+	}
+}
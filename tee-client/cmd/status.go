@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/spf13/cobra"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const defaultStatusCheckInterval = time.Minute
+
+var (
+	governanceKeypair string
+	statusNode        string
+
+	deactivateCmd = &cobra.Command{
+		Use:   "deactivate",
+		Short: "Retire this TEE node on chain, or another one as governance",
+		Run: func(cmd *cobra.Command, args []string) {
+			runSetStatus((*solana.Client).DeactivateTEENode, (*solana.Client).GovernanceDeactivateTEENode)
+		},
+	}
+
+	revokeCmd = &cobra.Command{
+		Use:   "revoke",
+		Short: "Mark this TEE node as compromised on chain, or another one as governance",
+		Run: func(cmd *cobra.Command, args []string) {
+			runSetStatus((*solana.Client).RevokeTEENode, (*solana.Client).GovernanceRevokeTEENode)
+		},
+	}
+)
+
+func init() {
+	for _, c := range []*cobra.Command{deactivateCmd, revokeCmd} {
+		c.Flags().StringVar(&cfgPath, "config", "", "Path to config.toml")
+		c.MarkFlagRequired("config")
+		c.Flags().StringVar(&governanceKeypair, "governance-keypair", "", "Sign as the governance authority with this Solana keypair file, for --node")
+		c.Flags().StringVar(&statusNode, "node", "", "Wallet address of the TEE node to act on, with --governance-keypair")
+		rootCmd.AddCommand(c)
+	}
+}
+
+// runSetStatus submits a status change signed by the wallet of the last start,
+// or with --governance-keypair one for --node signed by the governance
+// authority
+func runSetStatus(self func(*solana.Client, types.Context) (*solanago.Signature, error), governed func(*solana.Client, types.Context, solanago.PublicKey) (*solanago.Signature, error)) {
+	if (governanceKeypair == "") != (statusNode == "") {
+		log.Fatal("--governance-keypair and --node go together")
+	}
+
+	config, err := config.LoadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	ctx := types.NewContext().WithConfig(config)
+
+	solanaClient, err := solana.NewClient(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var signature *solanago.Signature
+	if governanceKeypair != "" {
+		node, nodeErr := solanago.PublicKeyFromBase58(statusNode)
+		if nodeErr != nil {
+			log.Fatalf("Invalid --node address: %v", nodeErr)
+		}
+		wallet, walletErr := solanago.PrivateKeyFromSolanaKeygenFile(governanceKeypair)
+		if walletErr != nil {
+			log.Fatalf("Failed to read governance keypair: %v", walletErr)
+		}
+		solanaClient.UseWallet(wallet)
+		signature, err = governed(solanaClient, *ctx, node)
+	} else {
+		if err := solanaClient.LoadWallet(); err != nil {
+			log.Fatal(err)
+		}
+		signature, err = self(solanaClient, *ctx)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(signature)
+}
+
+// StatusWatcher follows the on-chain status of this node, so it stops
+// serving decrypts as soon as it is deactivated or revoked
+type StatusWatcher struct {
+	ctx       types.Context
+	solClient *solana.Client
+	interval  time.Duration
+
+	status atomic.Uint32
+}
+
+func NewStatusWatcher(ctx types.Context, cfg *config.Config, solClient *solana.Client) (*StatusWatcher, error) {
+	interval, err := parseInterval("attestation.status-check-interval", cfg.Attestation.StatusCheckInterval, defaultStatusCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	// starts out active; runStart has already refused a node that is not
+	return &StatusWatcher{ctx: ctx, solClient: solClient, interval: interval}, nil
+}
+
+// Check refreshes the status from chain. A node never becomes active again,
+// so once deactivated or revoked the status is kept even if a later read
+// fails or disagrees.
+func (w *StatusWatcher) Check() (solana.TEEStatus, error) {
+	status, err := w.solClient.GetTEEStatus(w.ctx)
+	if err != nil {
+		return w.Status(), err
+	}
+	if w.Active() {
+		w.status.Store(uint32(status))
+	}
+	return w.Status(), nil
+}
+
+func (w *StatusWatcher) Status() solana.TEEStatus {
+	return solana.TEEStatus(w.status.Load())
+}
+
+func (w *StatusWatcher) Active() bool {
+	return w.Status() == solana.TEEStatusActive
+}
+
+// Run blocks until the context is cancelled
+func (w *StatusWatcher) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Context().Done():
+			return
+		case <-ticker.C:
+			wasActive := w.Active()
+			if _, err := w.Check(); err != nil {
+				fmt.Printf("❌ Failed to check node status: %v\n", err)
+				continue
+			}
+			if wasActive && !w.Active() {
+				fmt.Printf("⛔ Node is %s on chain, decryption disabled\n", w.Status())
+			}
+		}
+	}
+}
+
+// RequireActive rejects requests once the node is no longer active
+func (w *StatusWatcher) RequireActive(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !w.Active() {
+			writeJSONError(rw, fmt.Sprintf("TEE node is %s", w.Status()), http.StatusServiceUnavailable)
+			return
+		}
+		next(rw, r)
+	}
+}
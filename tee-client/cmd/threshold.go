@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/threshold"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+// peerTimeout bounds one key share request to a peer
+const peerTimeout = 15 * time.Second
+
+// thresholdDecryptor opens records whose key is split across TEE nodes
+type thresholdDecryptor struct {
+	node   *threshold.Node
+	client *http.Client
+	peers  []string
+}
+
+func newThresholdDecryptor(ctx types.Context, cfg *config.Config, solClient *solana.Client, keypair *keys.KeyPair, attestor tee.Attestor) (*thresholdDecryptor, error) {
+	if !cfg.Rest.TLS {
+		return nil, errors.New("threshold decryption requires rest.tls, as peers are verified over RA-TLS")
+	}
+	if len(cfg.Threshold.Peers) == 0 {
+		return nil, errors.New("threshold decryption requires threshold.peers")
+	}
+	if keypair.Type() != keys.KeyTypeRSA2048 {
+		return nil, fmt.Errorf("threshold decryption requires keys.type %q, as key shares are signed with the node key", keys.KeyTypeRSA2048)
+	}
+
+	node := &threshold.Node{
+		KeyPair: keypair,
+		Address: solClient.GetPubKey(),
+		Lookup: func(_ context.Context, signer solanago.PublicKey) (*solana.TEEState, error) {
+			return solClient.GetTEEState(ctx, signer)
+		},
+		// a peer hands out its share only after checking the client's proof
+		// itself, and reads the envelope from IPFS rather than trusting ours
+		Authorize: func(_ context.Context, proof json.RawMessage) (*keys.Envelope, error) {
+			var req DecryptRequest
+			if err := json.Unmarshal(proof, &req); err != nil {
+				return nil, fmt.Errorf("invalid access proof: %w", err)
+			}
+			record, reqErr := authorizeRecordAccess(ctx, solClient, req)
+			if reqErr != nil {
+				return nil, reqErr
+			}
+
+			env, err := fetchEnvelope(req.CID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch record from IPFS: %w", err)
+			}
+			if err := env.CheckBinding(recordBinding(solClient, record)); err != nil {
+				return nil, err
+			}
+			return env, nil
+		},
+		MaxAge: solana.MaxAttestationAge,
+	}
+
+	return &thresholdDecryptor{
+		node: node,
+		client: &http.Client{
+			Timeout:   peerTimeout,
+			Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(attestor.VerifyAttestationReport)},
+		},
+		peers: cfg.Threshold.Peers,
+	}, nil
+}
+
+// dataKey gathers key shares of env from peers for the client request proof
+// and combines them
+func (d *thresholdDecryptor) dataKey(ctx context.Context, env *keys.Envelope, proof json.RawMessage) ([]byte, error) {
+	shares, err := d.node.Gather(ctx, d.client, d.peers, env, proof)
+	if err != nil {
+		return nil, err
+	}
+	return keys.ThresholdKey(env, shares)
+}
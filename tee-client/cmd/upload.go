@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/staging"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/tus"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+const (
+	defaultUploadDir       = "upload"
+	defaultUploadRetention = 24 * time.Hour
+	defaultResumableDir    = "tus"
+	defaultResumableExpiry = 24 * time.Hour
+
+	uploadCleanupInterval = time.Hour
+)
+
+// newStagingKey derives the key staged uploads are encrypted with from the
+// TEE sealing key
+func newStagingKey(attestor tee.Attestor) (*keys.StagingKey, error) {
+	sealer, ok := attestor.(tee.Sealer)
+	if !ok {
+		return nil, errors.New("staging uploads requires a TEE that can seal storage")
+	}
+	sealingKey, err := sealer.SealingKey()
+	if err != nil {
+		return nil, err
+	}
+	return keys.NewStagingKey(sealingKey)
+}
+
+// stagingJanitor shreds staged uploads once retention ends. Uploads are
+// shredded as soon as /v1/records/seal pins them, so what is left is never
+// referenced by a HealthRecord.
+type stagingJanitor struct {
+	ctx       types.Context
+	store     *staging.Store
+	retention time.Duration
+}
+
+func newStagingJanitor(ctx types.Context, cfg *config.Config, key *keys.StagingKey) (*stagingJanitor, error) {
+	retention, err := parseInterval("upload.retention", cfg.Upload.Retention, defaultUploadRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cfg.Upload.Dir
+	if dir == "" {
+		dir = defaultUploadDir
+	}
+	store, err := staging.OpenStore(dir, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stagingJanitor{ctx: ctx, store: store, retention: retention}, nil
+}
+
+// Run sweeps the store now and then every hour, until the context is
+// cancelled
+func (j *stagingJanitor) Run() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.sweep(); err != nil {
+			fmt.Printf("⚠️  Upload retention sweep incomplete: %v\n", err)
+		}
+
+		select {
+		case <-j.ctx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *stagingJanitor) sweep() error {
+	removed, err := j.store.Sweep(time.Now().Add(-j.retention))
+	if removed > 0 {
+		fmt.Printf("🧹 Shredded %d staged uploads that were never sealed\n", removed)
+	}
+	return err
+}
+
+// resumableUploads serves tus uploads, which are staged like the files of
+// /upload-record once complete
+type resumableUploads struct {
+	ctx    types.Context
+	server *tus.Server
+}
+
+func newResumableUploads(ctx types.Context, cfg *config.Config, key *keys.StagingKey, store *staging.Store) (*resumableUploads, error) {
+	expiry, err := parseInterval("upload.resumable-expiry", cfg.Upload.ResumableExpiry, defaultResumableExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cfg.Upload.ResumableDir
+	if dir == "" {
+		dir = defaultResumableDir
+	}
+	server, err := tus.NewServer(dir, key)
+	if err != nil {
+		return nil, err
+	}
+
+	maxUploadMB := cfg.Rest.MaxUploadMB
+	if maxUploadMB <= 0 {
+		maxUploadMB = defaultMaxUploadMB
+	}
+	server.MaxSize = int64(maxUploadMB) << 20
+	server.Expiry = expiry
+	// the signer is checked up front, not after the whole file arrived
+	server.Validate = func(metadata map[string]string) error {
+		_, err := uploadSigner(metadata)
+		return err
+	}
+	server.Finish = func(metadata map[string]string, content io.Reader) (interface{}, error) {
+		signer, err := uploadSigner(metadata)
+		if err != nil {
+			return nil, err
+		}
+		staged, existed, err := store.Put(signer, content)
+		if err != nil {
+			return nil, err
+		}
+		return uploadResponse(staged, existed), nil
+	}
+
+	return &resumableUploads{ctx: ctx, server: server}, nil
+}
+
+// Run removes expired uploads now and then every hour, until the context is
+// cancelled
+func (u *resumableUploads) Run() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := u.server.Cleanup(time.Now())
+		if err != nil {
+			fmt.Printf("⚠️  Upload cleanup incomplete: %v\n", err)
+		}
+		if removed > 0 {
+			fmt.Printf("🧹 Removed %d expired resumable uploads\n", removed)
+		}
+
+		select {
+		case <-u.ctx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uploadSigner reads the signer of a resumable upload from its metadata
+func uploadSigner(metadata map[string]string) (solanago.PublicKey, error) {
+	signer := metadata["signer"]
+	if signer == "" {
+		return solanago.PublicKey{}, errors.New("Missing signer address")
+	}
+	pk, err := solanago.PublicKeyFromBase58(signer)
+	if err != nil {
+		return solanago.PublicKey{}, errors.New("Invalid signer pubkey")
+	}
+	return pk, nil
+}
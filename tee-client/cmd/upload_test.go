@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+func TestStagingJanitorShredsExpiredUploads(t *testing.T) {
+	key, err := keys.NewStagingKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Upload.Dir = t.TempDir()
+	cfg.Upload.Retention = "24h"
+	janitor, err := newStagingJanitor(types.Context{}, cfg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := solanago.NewWallet().PublicKey()
+	abandoned, _, err := janitor.store.Put(owner, bytes.NewReader([]byte("never sealed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-25 * time.Hour)
+	os.Chtimes(abandoned.Path, old, old)
+	recent, _, err := janitor.store.Put(owner, bytes.NewReader([]byte("being sealed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := janitor.sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(abandoned.Path); !os.IsNotExist(err) {
+		t.Errorf("upload past retention was kept")
+	}
+	if _, err := os.Stat(recent.Path); err != nil {
+		t.Errorf("upload within retention was removed: %v", err)
+	}
+}
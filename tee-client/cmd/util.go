@@ -1,25 +1,264 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // DownloadJsonFromPinata downloads a JSON object from IPFS using the given CID.
 func DownloadJsonFromPinata(cid string) ([]byte, error) {
-	url := fmt.Sprintf("https://gateway.pinata.cloud/ipfs/%s", cid)
+	body, err := DownloadFromPinata(cid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
 
-	resp, err := http.Get(url)
+	return io.ReadAll(body)
+}
+
+// DownloadFromPinata opens the content of cid on IPFS for streaming; the
+// caller must close it
+func DownloadFromPinata(cid string) (io.ReadCloser, error) {
+	resp, err := http.Get(gatewayURL(cid))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch data from IPFS: %v", err)
 	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("IPFS gateway returned error: %s", string(body))
+	}
+
+	return resp.Body, nil
+}
+
+// pinataBlob reads the content of a CID on IPFS at any offset, with range
+// requests to the gateway. A read that goes on where the last one ended
+// reuses its response, so reading in order costs a single request.
+type pinataBlob struct {
+	url  string
+	size int64
+
+	mu   sync.Mutex
+	body io.ReadCloser
+	pos  int64 // offset body reads from
+}
+
+// openPinataBlob opens the content of cid on IPFS for reading at any offset;
+// the caller must close it
+func openPinataBlob(cid string) (*pinataBlob, error) {
+	b := &pinataBlob{url: gatewayURL(cid), size: -1}
+	if err := b.open(0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Size returns the size of the content
+func (b *pinataBlob) Size() int64 {
+	return b.size
+}
+
+func (b *pinataBlob) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	if b.body == nil || b.pos != off {
+		if err := b.open(off); err != nil {
+			return 0, err
+		}
+	}
+
+	want := p
+	if rest := b.size - off; int64(len(want)) > rest {
+		want = want[:rest]
+	}
+	n, err := io.ReadFull(b.body, want)
+	b.pos += int64(n)
+	if err != nil {
+		b.close()
+		return n, fmt.Errorf("failed to read from IPFS: %w", err)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *pinataBlob) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.close()
+}
+
+// open requests the content from off on
+func (b *pinataBlob) open(off int64) error {
+	b.close()
+
+	req, err := http.NewRequest(http.MethodGet, b.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch data from IPFS: %v", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		if b.size < 0 {
+			b.size = size
+		}
+	case http.StatusOK:
+		// the gateway ignored the range
+		if resp.ContentLength < 0 {
+			resp.Body.Close()
+			return errors.New("IPFS gateway returned content of unknown size")
+		}
+		if b.size < 0 {
+			b.size = resp.ContentLength
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to read from IPFS: %w", err)
+		}
+	default:
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("IPFS gateway returned error: %s", string(body))
+	}
+
+	b.body = resp.Body
+	b.pos = off
+	return nil
+}
+
+func (b *pinataBlob) close() error {
+	if b.body == nil {
+		return nil
+	}
+	err := b.body.Close()
+	b.body = nil
+	return err
+}
+
+// contentRangeSize returns the complete length of a Content-Range header
+func contentRangeSize(header string) (int64, error) {
+	i := strings.LastIndexByte(header, '/')
+	if !strings.HasPrefix(header, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return size, nil
+}
+
+func gatewayURL(cid string) string {
+	return fmt.Sprintf("https://gateway.pinata.cloud/ipfs/%s", cid)
+}
+
+// UploadJsonToPinata pins a JSON object to IPFS and returns its CID.
+func UploadJsonToPinata(jwt, name string, content interface{}) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"pinataContent":  content,
+		"pinataMetadata": map[string]string{"name": name},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.pinata.cloud/pinning/pinJSONToIPFS", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to pin data to IPFS: %v", err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("IPFS gateway returned error: %s", string(body))
+		return "", fmt.Errorf("pinata returned error: %s", string(body))
+	}
+
+	var out struct {
+		IpfsHash string `json:"IpfsHash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode pinata response: %v", err)
+	}
+
+	return out.IpfsHash, nil
+}
+
+// UploadFileToPinata pins content read from r to IPFS as a file, streaming
+// it, and returns its CID
+func UploadFileToPinata(jwt, name string, r io.Reader) (string, error) {
+	body, w := io.Pipe()
+	form := multipart.NewWriter(w)
+	go func() {
+		err := form.WriteField("pinataMetadata", fmt.Sprintf(`{"name":%q}`, name))
+		if err == nil {
+			var part io.Writer
+			if part, err = form.CreateFormFile("file", name); err == nil {
+				_, err = io.Copy(part, r)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.pinata.cloud/pinning/pinFileToIPFS", body)
+	if err != nil {
+		body.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to pin data to IPFS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return "", fmt.Errorf("pinata returned error: %s", string(body))
+	}
+
+	var out struct {
+		IpfsHash string `json:"IpfsHash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode pinata response: %v", err)
 	}
 
-	return io.ReadAll(resp.Body)
+	return out.IpfsHash, nil
 }
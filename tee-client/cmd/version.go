@@ -0,0 +1,5 @@
+package cmd
+
+// Version of the running tee-client, set at build time with
+// -ldflags "-X github.com/vitwit/healthlock/tee-client/cmd.Version=..."
+var Version = "dev"
@@ -3,6 +3,14 @@ package config
 type Config struct {
 	Solana SolanaConfig `toml:"solana"`
 	Rest   RestConfig   `toml:"rest"`
+	IPFS   IPFSConfig   `toml:"ipfs"`
+
+	Keys        KeysConfig        `toml:"keys"`
+	Attestation AttestationConfig `toml:"attestation"`
+	Threshold   ThresholdConfig   `toml:"threshold"`
+	Migration   MigrationConfig   `toml:"migration"`
+	KMS         KMSConfig         `toml:"kms"`
+	Upload      UploadConfig      `toml:"upload"`
 }
 
 type SolanaConfig struct {
@@ -13,5 +21,81 @@ type SolanaConfig struct {
 }
 
 type RestConfig struct {
-	Port int `toml:"port"`
+	Port        int  `toml:"port"`
+	TLS         bool `toml:"tls"`           // serve over RA-TLS, with attestation embedded in the certificate
+	MaxUploadMB int  `toml:"max-upload-mb"` // largest file /upload-record accepts, 1024 by default
+}
+
+type IPFSConfig struct {
+	PinataJWT string `toml:"pinata-jwt"` // used to publish the attestation bundle
+}
+
+// KeysConfig selects the node's encryption key, whose public key is
+// registered on chain and used by clients to wrap record keys
+type KeysConfig struct {
+	Type             string `toml:"type"`              // "rsa-2048" (default), or "x25519" or "x25519-mlkem768", wrapped with HPKE
+	RotationInterval string `toml:"rotation-interval"` // e.g. "1h", how often a key rotation walks the records still on the previous key
+}
+
+type AttestationConfig struct {
+	CertCacheDir     string `toml:"cert-cache-dir"`    // local copy of AMD KDS certificates and CRLs
+	Offline          bool   `toml:"offline"`           // never contact AMD KDS, use cert-cache-dir only
+	CheckRevocations bool   `toml:"check-revocations"` // check VCEKs against the AMD CRL
+
+	ReattestInterval    string `toml:"reattest-interval"`     // e.g. "6h", refresh the on-chain attestation this often
+	TCBCheckInterval    string `toml:"tcb-check-interval"`    // e.g. "10m", re-attest early when the reported TCB changes
+	StatusCheckInterval string `toml:"status-check-interval"` // e.g. "1m", how often to check for deactivation or revocation
+
+	Policy AttestationPolicyConfig `toml:"policy"`
+}
+
+// AttestationPolicyConfig describes which SEV-SNP reports are acceptable
+type AttestationPolicyConfig struct {
+	Measurements    []string  `toml:"measurements"` // hex, report must match one of them
+	MinimumGuestSVN uint32    `toml:"minimum-guest-svn"`
+	MinimumTCB      TCBConfig `toml:"minimum-tcb"`
+	AllowDebug      bool      `toml:"allow-debug"`
+	AllowSMT        bool      `toml:"allow-smt"`
+	VMPL            *int      `toml:"vmpl"`
+	FamilyID        string    `toml:"family-id"` // hex, 16 bytes
+	ImageID         string    `toml:"image-id"`  // hex, 16 bytes
+	NonceOffset     int       `toml:"nonce-offset"`
+}
+
+type TCBConfig struct {
+	BlSpl    uint8 `toml:"bl-spl"`
+	TeeSpl   uint8 `toml:"tee-spl"`
+	SnpSpl   uint8 `toml:"snp-spl"`
+	UcodeSpl uint8 `toml:"ucode-spl"`
+}
+
+// ThresholdConfig enables decrypting records whose key is split across TEE
+// nodes, by gathering key shares from peers over RA-TLS
+type ThresholdConfig struct {
+	Enabled   bool     `toml:"enabled"`
+	Threshold int      `toml:"threshold"` // key shares needed to decrypt, used when sealing records
+	Peers     []string `toml:"peers"`     // RA-TLS urls of other nodes, e.g. "https://node2:8085"
+}
+
+// MigrationConfig lets a node hand its key to a replacement node that
+// attests to a trusted image, see keys migrate
+type MigrationConfig struct {
+	Enabled bool `toml:"enabled"`
+}
+
+// KMSConfig enables the key management service, which keeps per-record keys
+// in sealed local storage so owners can have them destroyed
+type KMSConfig struct {
+	Enabled      bool     `toml:"enabled"`
+	Dir          string   `toml:"dir"`           // sealed key storage, default "kms"
+	Peers        []string `toml:"peers"`         // RA-TLS urls of nodes keys are replicated to
+	SyncInterval string   `toml:"sync-interval"` // e.g. "1h", how often to pull keys and deletions from peers
+}
+
+// UploadConfig is about files staged on this node before they are sealed
+type UploadConfig struct {
+	Dir             string `toml:"dir"`              // encrypted staged uploads, default "upload"
+	Retention       string `toml:"retention"`        // e.g. "24h", how long an upload that is never sealed is kept
+	ResumableDir    string `toml:"resumable-dir"`    // encrypted partial uploads, default "tus"
+	ResumableExpiry string `toml:"resumable-expiry"` // e.g. "24h", how long an idle resumable upload is kept
 }
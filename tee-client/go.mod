@@ -3,15 +3,17 @@ module github.com/vitwit/healthlock/tee-client
 go 1.23.6
 
 require (
+	filippo.io/edwards25519 v1.0.0-rc.1
 	github.com/BurntSushi/toml v1.5.0
+	github.com/cloudflare/circl v1.6.1
 	github.com/gagliardetto/binary v0.8.0
 	github.com/gagliardetto/solana-go v1.12.0
 	github.com/google/go-sev-guest v0.13.0
 	github.com/spf13/cobra v1.1.1
+	golang.org/x/crypto v0.17.0
 )
 
 require (
-	filippo.io/edwards25519 v1.0.0-rc.1 // indirect
 	github.com/andres-erbsen/clock v0.0.0-20160526145045-9e14626cd129 // indirect
 	github.com/blendle/zapdriver v1.3.1 // indirect
 	github.com/buger/jsonparser v1.1.1 // indirect
@@ -41,7 +43,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/ratelimit v0.2.0 // indirect
 	go.uber.org/zap v1.21.0 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/term v0.15.0 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
@@ -0,0 +1,211 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Algorithm identifiers carried in the kem, aead and kdf fields of envelopes
+const (
+	KEMRSAOAEPSHA256 = "RSA-OAEP-SHA256"
+	KEMX25519HPKE    = "HPKE-X25519-HKDF-SHA256-AES-256-GCM"
+	KEMXWingHPKE     = "HPKE-X-Wing-AES-256-GCM" // X25519 and ML-KEM-768
+	KEMKMS           = "KMS"                     // no wrapped key, see SealKMS
+	AEADAES256GCM    = "AES-256-GCM"
+	KDFNone          = "none" // the unwrapped key is the content key
+	KDFHKDFSHA256    = "HKDF-SHA256"
+)
+
+// ErrUnsupportedAlgorithm is returned for envelopes naming an algorithm that
+// is not registered
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// KEM wraps a data key to a recipient's public key and unwraps it with the
+// recipient's key pair
+type KEM interface {
+	Wrap(recipient crypto.PublicKey, key []byte) ([]byte, error)
+	Unwrap(kp *KeyPair, wrapped []byte) ([]byte, error)
+}
+
+// AEAD encrypts record contents under the content key
+type AEAD interface {
+	KeySize() int
+	New(key []byte) (cipher.AEAD, error)
+}
+
+// KDF derives the content key from the data key
+type KDF interface {
+	Derive(key []byte, size int) ([]byte, error)
+}
+
+// Suite names the algorithms of an envelope
+type Suite struct {
+	KEM  string
+	AEAD string
+	KDF  string
+}
+
+// DefaultSuite is what envelopes older than version 2 implicitly use. Seal
+// uses its AEAD and KDF, and the KEM matching each recipient's key type.
+var DefaultSuite = Suite{KEM: KEMRSAOAEPSHA256, AEAD: AEADAES256GCM, KDF: KDFNone}
+
+var (
+	registryMu sync.RWMutex
+	kems       = make(map[string]KEM)
+	aeads      = make(map[string]AEAD)
+	kdfs       = make(map[string]KDF)
+)
+
+func init() {
+	RegisterKEM(KEMRSAOAEPSHA256, rsaOAEPKEM{})
+	RegisterKEM(KEMX25519HPKE, x25519HPKE)
+	RegisterKEM(KEMXWingHPKE, xwingHPKE)
+	RegisterKEM(KEMKMS, kmsKEM{})
+	RegisterAEAD(AEADAES256GCM, aesGCM{})
+	RegisterKDF(KDFNone, noKDF{})
+	RegisterKDF(KDFHKDFSHA256, hkdfSHA256{})
+}
+
+// RegisterKEM makes a KEM available to envelopes under name. It panics if
+// the name is taken, like database/sql.Register.
+func RegisterKEM(name string, kem KEM) {
+	register(kems, "kem", name, kem)
+}
+
+// RegisterAEAD makes an AEAD available to envelopes under name
+func RegisterAEAD(name string, aead AEAD) {
+	register(aeads, "aead", name, aead)
+}
+
+// RegisterKDF makes a KDF available to envelopes under name
+func RegisterKDF(name string, kdf KDF) {
+	register(kdfs, "kdf", name, kdf)
+}
+
+func register[T any](registry map[string]T, kind, name string, alg T) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("keys: %s %q registered twice", kind, name))
+	}
+	registry[name] = alg
+}
+
+func lookup[T any](registry map[string]T, kind, name string) (T, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	alg, ok := registry[name]
+	if !ok {
+		return alg, fmt.Errorf("%w: %s %q", ErrUnsupportedAlgorithm, kind, name)
+	}
+	return alg, nil
+}
+
+// resolvedSuite holds the implementations named by a Suite
+type resolvedSuite struct {
+	kem  KEM
+	aead AEAD
+	kdf  KDF
+}
+
+func (s Suite) resolve() (*resolvedSuite, error) {
+	kem, err := lookup(kems, "kem", s.KEM)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := lookup(aeads, "aead", s.AEAD)
+	if err != nil {
+		return nil, err
+	}
+	kdf, err := lookup(kdfs, "kdf", s.KDF)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedSuite{kem: kem, aead: aead, kdf: kdf}, nil
+}
+
+// contentCipher derives the content key from the data key and keys the AEAD
+func (s *resolvedSuite) contentCipher(dataKey []byte) (cipher.AEAD, error) {
+	key, err := s.kdf.Derive(dataKey, s.aead.KeySize())
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.New(key)
+}
+
+// kemFor returns the KEM that wraps keys for pub
+func kemFor(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return KEMRSAOAEPSHA256, nil
+	case *XWingPublicKey:
+		return KEMXWingHPKE, nil
+	case *ecdh.PublicKey:
+		if pub.Curve() == ecdh.X25519() {
+			return KEMX25519HPKE, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no kem for %T keys", ErrUnsupportedAlgorithm, pub)
+}
+
+type rsaOAEPKEM struct{}
+
+func (rsaOAEPKEM) Wrap(recipient crypto.PublicKey, key []byte) ([]byte, error) {
+	pub, ok := recipient.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s needs an RSA public key, got %T", KEMRSAOAEPSHA256, recipient)
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+}
+
+func (rsaOAEPKEM) Unwrap(kp *KeyPair, wrapped []byte) ([]byte, error) {
+	if kp.PrivateKey == nil {
+		return nil, fmt.Errorf("%s needs an RSA key pair", KEMRSAOAEPSHA256)
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, kp.PrivateKey, wrapped, nil)
+}
+
+type aesGCM struct{}
+
+func (aesGCM) KeySize() int { return 32 }
+
+func (aesGCM) New(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type noKDF struct{}
+
+func (noKDF) Derive(key []byte, size int) ([]byte, error) {
+	if len(key) != size {
+		return nil, fmt.Errorf("kdf %q needs a %d byte key, got %d", KDFNone, size, len(key))
+	}
+	return key, nil
+}
+
+// hkdfInfo separates content keys from any other use of a data key
+var hkdfInfo = []byte("healthlock content key")
+
+type hkdfSHA256 struct{}
+
+func (hkdfSHA256) Derive(key []byte, size int) ([]byte, error) {
+	out := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, hkdfInfo), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package keys_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+var updateVectors = flag.Bool("update", false, "regenerate testdata/binding_vectors.json")
+
+const bindingVectorsPath = "testdata/binding_vectors.json"
+
+// bindingVector is one entry of testdata/binding_vectors.json, shared with
+// the TS client so both sides derive the same associated data
+type bindingVector struct {
+	Name     string             `json:"name"`
+	Binding  keys.RecordBinding `json:"binding"`
+	Encoding string             `json:"encoding"` // hex
+}
+
+func bindingInputs() []bindingVector {
+	large := testBinding
+	large.RecordID = 1<<64 - 1
+	large.MimeType = "image/png"
+
+	return []bindingVector{
+		{Name: "empty", Binding: keys.RecordBinding{}},
+		{Name: "pdf record", Binding: testBinding},
+		{Name: "largest record id", Binding: large},
+	}
+}
+
+func TestBindingVectors(t *testing.T) {
+	if *updateVectors {
+		vectors := bindingInputs()
+		for i := range vectors {
+			vectors[i].Encoding = hex.EncodeToString(vectors[i].Binding.AssociatedData())
+		}
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bindingVectorsPath, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.FromSlash(bindingVectorsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []bindingVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			if got := hex.EncodeToString(v.Binding.AssociatedData()); got != v.Encoding {
+				t.Errorf("encoding mismatch\n got: %s\nwant: %s", got, v.Encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package keys
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+)
+
+// dualControlShareSize is the size of the TEE and the owner share of a dual
+// control data key
+const dualControlShareSize = 32
+
+// ownerShareGrantVersion is the version of the OwnerShareGrant encoding
+const ownerShareGrantVersion uint8 = 1
+
+var ownerShareDomain = []byte("healthlock-owner-share")
+
+var (
+	// ErrOwnerShareExpired is returned by OwnerShareGrant.Check for grants
+	// past their expiry
+	ErrOwnerShareExpired = errors.New("owner share grant has expired")
+	// ErrOwnerShareMismatch is returned by OwnerShareGrant.Check for grants
+	// of another record or requester
+	ErrOwnerShareMismatch = errors.New("owner share grant is for another record or requester")
+)
+
+// OwnerShareGrant releases the owner's share of one dual control record to
+// one requester until Expires. The owner seals it to the TEE node serving the
+// request with SealOwnerShareGrant, so only that node learns the share and a
+// captured grant is of no use for other records or requesters. It is encoded
+// as:
+//
+//	"healthlock-owner-share" || version:u8 || owner:[32] || record_id:u64
+//	|| requester:[32] || expires:i64 || share:[32]
+//
+// Integers are little endian and expires is in Unix seconds.
+type OwnerShareGrant struct {
+	Owner     solanago.PublicKey
+	RecordID  uint64
+	Requester solanago.PublicKey
+	Expires   time.Time
+	Share     []byte
+}
+
+func (g *OwnerShareGrant) marshal() []byte {
+	var buf []byte
+	buf = append(buf, ownerShareDomain...)
+	buf = append(buf, ownerShareGrantVersion)
+	buf = append(buf, g.Owner[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, g.RecordID)
+	buf = append(buf, g.Requester[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(g.Expires.Unix()))
+	return append(buf, g.Share...)
+}
+
+func parseOwnerShareGrant(data []byte) (*OwnerShareGrant, error) {
+	header := len(ownerShareDomain) + 1
+	if len(data) != header+32+8+32+8+dualControlShareSize || string(data[:len(ownerShareDomain)]) != string(ownerShareDomain) {
+		return nil, errors.New("malformed owner share grant")
+	}
+	if v := data[header-1]; v != ownerShareGrantVersion {
+		return nil, fmt.Errorf("unsupported owner share grant version %d", v)
+	}
+
+	data = data[header:]
+	g := &OwnerShareGrant{}
+	copy(g.Owner[:], data[:32])
+	g.RecordID = binary.LittleEndian.Uint64(data[32:40])
+	copy(g.Requester[:], data[40:72])
+	g.Expires = time.Unix(int64(binary.LittleEndian.Uint64(data[72:80])), 0)
+	g.Share = data[80:]
+	return g, nil
+}
+
+// Check verifies that the grant releases the share of the record bound by
+// binding to requester, and has not expired at now
+func (g *OwnerShareGrant) Check(binding RecordBinding, requester solanago.PublicKey, now time.Time) error {
+	if !g.Owner.Equals(binding.Owner) || g.RecordID != binding.RecordID || !g.Requester.Equals(requester) {
+		return ErrOwnerShareMismatch
+	}
+	if !now.Before(g.Expires) {
+		return ErrOwnerShareExpired
+	}
+	return nil
+}
+
+// UnwrapOwnerShare returns the owner share of a dual control envelope, for
+// the key pair it is wrapped to, see OwnerRecoveryKeyPair
+func (kp *KeyPair) UnwrapOwnerShare(env *Envelope) ([]byte, error) {
+	if env.OwnerShare == nil {
+		return nil, errors.New("envelope is not a dual control envelope")
+	}
+	if err := kp.checkKeyID(env.OwnerShare); err != nil {
+		return nil, err
+	}
+	return kp.unwrapRecipient(env.OwnerShare, env.KEM)
+}
+
+// SealOwnerShareGrant wraps grant to the key of the TEE node that is to serve
+// the request. The result names its KEM, as there is no envelope to take it
+// from.
+func SealOwnerShareGrant(tee crypto.PublicKey, grant *OwnerShareGrant) (*Recipient, error) {
+	if len(grant.Share) != dualControlShareSize {
+		return nil, fmt.Errorf("owner share must be %d bytes, got %d", dualControlShareSize, len(grant.Share))
+	}
+	return WrapKey(tee, grant.marshal())
+}
+
+// OpenOwnerShareGrant unwraps a grant sealed to kp. Callers must Check it
+// against the record and requester being served.
+func (kp *KeyPair) OpenOwnerShareGrant(r *Recipient) (*OwnerShareGrant, error) {
+	data, err := kp.UnwrapKey(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseOwnerShareGrant(data)
+}
+
+// OpenDualControl decrypts a dual control envelope addressed to kp with the
+// owner's share of its key. Like Open, it does not check the binding.
+func (kp *KeyPair) OpenDualControl(env *Envelope, ownerShare []byte) ([]byte, error) {
+	dataKey, err := kp.DualControlKey(env, ownerShare)
+	if err != nil {
+		return nil, err
+	}
+	return openContent(env, dataKey)
+}
+
+// DualControlKey joins the TEE share of a dual control envelope addressed to
+// kp with the owner's share into the data key, e.g. for OpenStream
+func (kp *KeyPair) DualControlKey(env *Envelope, ownerShare []byte) ([]byte, error) {
+	if env.OwnerShare == nil {
+		return nil, errors.New("envelope is not a dual control envelope")
+	}
+
+	teeShare, err := kp.unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+	if len(teeShare) != dualControlShareSize || len(ownerShare) != dualControlShareSize {
+		return nil, errors.New("invalid dual control key share")
+	}
+
+	dataKey := make([]byte, 0, 2*dualControlShareSize)
+	return append(append(dataKey, teeShare...), ownerShare...), nil
+}
@@ -0,0 +1,144 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"testing"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestDualControlNeedsBothShares(t *testing.T) {
+	tee := generateKeyPairs(t, 1)[0]
+	wallet := solanago.NewWallet()
+	binding := testBinding
+	binding.Owner = wallet.PublicKey()
+	plaintext := []byte("psychiatric assessment")
+
+	ownerKey, err := keys.OwnerRecoveryKey(wallet.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := keys.OwnerRecoveryKeyPair(wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := keys.SealWithOptions(plaintext, binding, []crypto.PublicKey{tee.Public()}, keys.SealOptions{DualControl: ownerKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the TEE alone cannot decrypt
+	if _, err := tee.Open(sealed); !errors.Is(err, keys.ErrDualControlEnvelope) {
+		t.Fatalf("expected ErrDualControlEnvelope, got %v", err)
+	}
+	if _, err := tee.OpenDualControl(env, make([]byte, 32)); err == nil {
+		t.Error("opened with a wrong owner share")
+	}
+	// nor the owner alone
+	if _, err := owner.Open(sealed); !errors.Is(err, keys.ErrDualControlEnvelope) {
+		t.Errorf("expected ErrDualControlEnvelope, got %v", err)
+	}
+
+	share, err := owner.UnwrapOwnerShare(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := tee.OpenDualControl(env, share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestOwnerShareGrant(t *testing.T) {
+	tee, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requester := solanago.NewWallet().PublicKey()
+	now := time.Now()
+
+	grant := &keys.OwnerShareGrant{
+		Owner:     testBinding.Owner,
+		RecordID:  testBinding.RecordID,
+		Requester: requester,
+		Expires:   now.Add(time.Minute),
+		Share:     bytes.Repeat([]byte{7}, 32),
+	}
+	sealed, err := keys.SealOwnerShareGrant(tee.Public(), grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := tee.OpenOwnerShareGrant(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened.Share, grant.Share) || !opened.Expires.Equal(grant.Expires.Truncate(time.Second)) {
+		t.Errorf("opened grant %+v does not match", opened)
+	}
+	if err := opened.Check(testBinding, requester, now); err != nil {
+		t.Errorf("valid grant rejected: %v", err)
+	}
+
+	other := testBinding
+	other.RecordID++
+	if err := opened.Check(other, requester, now); !errors.Is(err, keys.ErrOwnerShareMismatch) {
+		t.Errorf("expected ErrOwnerShareMismatch for another record, got %v", err)
+	}
+	if err := opened.Check(testBinding, solanago.NewWallet().PublicKey(), now); !errors.Is(err, keys.ErrOwnerShareMismatch) {
+		t.Errorf("expected ErrOwnerShareMismatch for another requester, got %v", err)
+	}
+	if err := opened.Check(testBinding, requester, now.Add(time.Hour)); !errors.Is(err, keys.ErrOwnerShareExpired) {
+		t.Errorf("expected ErrOwnerShareExpired, got %v", err)
+	}
+
+	// only the TEE it is sealed to can open it
+	otherTEE := generateKeyPairs(t, 1)[0]
+	if _, err := otherTEE.OpenOwnerShareGrant(sealed); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient, got %v", err)
+	}
+}
+
+func TestDualControlRecovery(t *testing.T) {
+	tees := generateKeyPairs(t, 2)
+	wallet := solanago.NewWallet()
+	plaintext := []byte("hiv test result")
+
+	ownerKey, err := keys.OwnerRecoveryKey(wallet.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := keys.OwnerRecoveryKeyPair(wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := []crypto.PublicKey{tees[0].Public(), tees[1].Public()}
+	if _, err := keys.SealWithOptions(plaintext, testBinding, recipients, keys.SealOptions{DualControl: ownerKey, Threshold: 2}); err == nil {
+		t.Error("dual control combined with a threshold")
+	}
+
+	sealed, err := keys.SealWithOptions(plaintext, testBinding, recipients, keys.SealOptions{DualControl: ownerKey, Recovery: ownerKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := owner.Recover(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
@@ -0,0 +1,619 @@
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EnvelopeVersion is the version of envelopes produced by Seal. Version 3
+// binds the ciphertext to its record, version 2 names its algorithms, and
+// version 1 and the unversioned HybridEncryptedData format, taken as version
+// 0, implicitly use DefaultSuite.
+const EnvelopeVersion = 3
+
+var (
+	// ErrNotARecipient is returned when an envelope has no entry for the key
+	ErrNotARecipient = errors.New("key is not a recipient of this envelope")
+	// ErrThresholdEnvelope is returned by Open for envelopes whose key is split
+	// across recipients; use UnwrapShare and OpenWithShares instead
+	ErrThresholdEnvelope = errors.New("envelope key is split across TEE nodes")
+	// ErrKMSEnvelope is returned by Open for envelopes whose key is held by
+	// the key management service of the TEE nodes; use OpenKMS instead
+	ErrKMSEnvelope = errors.New("envelope key is held by the TEE key management service")
+	// ErrDualControlEnvelope is returned by Open for envelopes whose key is
+	// split between the TEE and the record owner; use OpenDualControl instead
+	ErrDualControlEnvelope = errors.New("envelope key needs the owner's share")
+	// ErrUnsupportedVersion is returned for envelopes of an unknown version
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+)
+
+// Recipient holds the record key wrapped for one TEE node
+type Recipient struct {
+	KeyID string `json:"key_id"` // hex sha256 of the node's DER public key
+	// KEM is set when the node's key type needs another KEM than the
+	// envelope's
+	KEM        string `json:"kem,omitempty"`
+	WrappedKey string `json:"wrapped_key"` // base64, wrapped with the recipient's KEM
+}
+
+// Envelope is an encrypted record whose key is wrapped for every recipient,
+// so any of them can decrypt it
+type Envelope struct {
+	Version int    `json:"version"`
+	KEM     string `json:"kem"`
+	AEAD    string `json:"aead"`
+	KDF     string `json:"kdf"`
+	// Binding is the record the ciphertext is bound to, from version 3
+	Binding *RecordBinding `json:"binding,omitempty"`
+	// Threshold is set when each recipient holds a Shamir share of the key
+	// rather than the key, and this many shares are needed to decrypt
+	Threshold  int         `json:"threshold,omitempty"`
+	Recipients []Recipient `json:"recipients"`
+	// OwnerShare is set for dual control envelopes: the recipients hold the
+	// TEE share of the data key and this is the owner's share, wrapped to
+	// the owner, who releases it per request with an OwnerShareGrant
+	OwnerShare *Recipient `json:"owner_share,omitempty"`
+	// Recovery holds the whole data key for the record owner, or a dedicated
+	// recovery key, to decrypt with Recover if no TEE is left
+	Recovery *Recipient `json:"recovery,omitempty"`
+	// KMSKeyID is set when the data key is not wrapped in the envelope but
+	// held by the key management service of the TEE nodes, so destroying it
+	// there leaves the record unreadable however many copies are pinned
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+	// SegmentSize is set for streamed envelopes, whose ciphertext follows
+	// the envelope in segments of this many plaintext bytes and whose Nonce
+	// is the nonce prefix of the segments, see SealStream
+	SegmentSize int    `json:"segment_size,omitempty"`
+	Ciphertext  string `json:"ciphertext"` // base64
+	Nonce       string `json:"nonce"`      // base64
+
+	// segments holds the ciphertext of streamed envelopes parsed in memory
+	segments []byte
+}
+
+// Suite returns the algorithms the envelope is encrypted with
+func (e *Envelope) Suite() Suite {
+	return Suite{KEM: e.KEM, AEAD: e.AEAD, KDF: e.KDF}
+}
+
+// envelopeDecoders turn every supported envelope version into an Envelope
+var envelopeDecoders = map[int]func(data []byte) (*Envelope, error){
+	0: decodeLegacyEnvelope,
+	1: decodeImplicitSuiteEnvelope,
+	2: decodeEnvelope,
+	3: decodeEnvelope,
+}
+
+// KeyID identifies a public key inside an envelope
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := marshalPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseRegisteredPublicKey parses a node key as registered on chain, i.e. the
+// base64 of its DER encoding. It returns an *rsa.PublicKey or an X25519
+// *ecdh.PublicKey, and ErrKeyNotOnChain for keys registered by commitment.
+func ParseRegisteredPublicKey(registered []byte) (crypto.PublicKey, error) {
+	if strings.HasPrefix(string(registered), registeredCommitmentPrefix) {
+		return nil, ErrKeyNotOnChain
+	}
+
+	der, err := base64.StdEncoding.DecodeString(string(registered))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 public key: %w", err)
+	}
+	return parsePublicKey(der)
+}
+
+// ParsePublishedPublicKey returns the node key for a registered key, taking
+// keys registered by commitment from published, the base64 DER key of the
+// node's attestation bundle, after checking it against the commitment
+func ParsePublishedPublicKey(registered []byte, published string) (crypto.PublicKey, error) {
+	keyID, committed := strings.CutPrefix(string(registered), registeredCommitmentPrefix)
+	if !committed {
+		return ParseRegisteredPublicKey(registered)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(published)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 public key: %w", err)
+	}
+	pub, err := parsePublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	got, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	if got != keyID {
+		return nil, fmt.Errorf("published key %s does not match registered key %s", got, keyID)
+	}
+	return pub, nil
+}
+
+// SealOptions tune SealWithOptions
+type SealOptions struct {
+	// Threshold, when set, gives each recipient a Shamir share of the key
+	// rather than the key, and this many shares are needed to decrypt
+	Threshold int
+	// Recovery, when set, is additionally given the whole data key so that
+	// its holder can decrypt without any TEE, see OwnerRecoveryKey
+	Recovery crypto.PublicKey
+	// DualControl, when set, splits the data key into a TEE share, wrapped
+	// for the recipients, and an owner share wrapped to this key, normally
+	// OwnerRecoveryKey of the record owner, so no TEE can decrypt alone. It
+	// cannot be combined with Threshold.
+	DualControl crypto.PublicKey
+}
+
+// Seal encrypts plaintext, bound to its record, once and wraps its key for
+// each recipient
+func Seal(plaintext []byte, binding RecordBinding, recipients []crypto.PublicKey) ([]byte, error) {
+	return SealWithOptions(plaintext, binding, recipients, SealOptions{})
+}
+
+// SealThreshold encrypts plaintext once and wraps one Shamir share of its key
+// for each recipient, so that any threshold of them together can decrypt but
+// fewer cannot
+func SealThreshold(plaintext []byte, binding RecordBinding, recipients []crypto.PublicKey, threshold int) ([]byte, error) {
+	if threshold == 0 {
+		return nil, errors.New("threshold must be set")
+	}
+	return SealWithOptions(plaintext, binding, recipients, SealOptions{Threshold: threshold})
+}
+
+// SealWithOptions encrypts plaintext, bound to its record, with a fresh data
+// key and wraps the key, or a share of it, for each recipient. The
+// envelope's KEM is that of the first recipient; recipients with another key
+// type name their own.
+func SealWithOptions(plaintext []byte, binding RecordBinding, recipients []crypto.PublicKey, opts SealOptions) ([]byte, error) {
+	env, dataKey, err := newEnvelope(binding, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.sealContent(dataKey, plaintext); err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// newEnvelope creates the envelope of a record without its content: it
+// picks a fresh data key and wraps it, or a share of it, for each recipient
+func newEnvelope(binding RecordBinding, recipients []crypto.PublicKey, opts SealOptions) (*Envelope, []byte, error) {
+	if len(recipients) == 0 {
+		return nil, nil, errors.New("envelope needs at least one recipient")
+	}
+
+	if opts.DualControl != nil && opts.Threshold != 0 {
+		return nil, nil, errors.New("dual control cannot be combined with a threshold")
+	}
+
+	envKEM, err := kemFor(recipients[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	suite := Suite{KEM: envKEM, AEAD: DefaultSuite.AEAD, KDF: DefaultSuite.KDF}
+	dataKeySize := 32
+	if opts.DualControl != nil {
+		// the data key is the TEE share followed by the owner share
+		suite.KDF = KDFHKDFSHA256
+		dataKeySize = 2 * dualControlShareSize
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	env := emptyEnvelope(suite, binding)
+	env.Threshold = opts.Threshold
+
+	secrets := make([][]byte, len(recipients))
+	switch {
+	case opts.Threshold != 0:
+		if secrets, err = SplitSecret(dataKey, len(recipients), opts.Threshold); err != nil {
+			return nil, nil, err
+		}
+	case opts.DualControl != nil:
+		for i := range secrets {
+			secrets[i] = dataKey[:dualControlShareSize]
+		}
+		if env.OwnerShare, err = wrapFor(opts.DualControl, dataKey[dualControlShareSize:], env.KEM); err != nil {
+			return nil, nil, err
+		}
+	default:
+		for i := range secrets {
+			secrets[i] = dataKey
+		}
+	}
+
+	for i, pub := range recipients {
+		recipient, err := wrapFor(pub, secrets[i], env.KEM)
+		if err != nil {
+			return nil, nil, err
+		}
+		env.Recipients = append(env.Recipients, *recipient)
+	}
+
+	if opts.Recovery != nil {
+		if env.Recovery, err = wrapFor(opts.Recovery, dataKey, env.KEM); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return env, dataKey, nil
+}
+
+// emptyEnvelope returns an envelope of suite bound to binding, without
+// recipients or content
+func emptyEnvelope(suite Suite, binding RecordBinding) *Envelope {
+	return &Envelope{
+		Version: EnvelopeVersion,
+		KEM:     suite.KEM,
+		AEAD:    suite.AEAD,
+		KDF:     suite.KDF,
+		Binding: &binding,
+	}
+}
+
+// sealContent encrypts plaintext, bound to the envelope's record, under
+// dataKey into the envelope
+func (e *Envelope) sealContent(dataKey, plaintext []byte) error {
+	aead, err := e.contentCipher(dataKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	e.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, e.Binding.AssociatedData()))
+	e.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	return nil
+}
+
+// contentCipher keys the envelope's AEAD with the content key derived from
+// dataKey
+func (e *Envelope) contentCipher(dataKey []byte) (cipher.AEAD, error) {
+	suite, err := e.Suite().resolve()
+	if err != nil {
+		return nil, err
+	}
+	return suite.contentCipher(dataKey)
+}
+
+// WrapKey wraps secret for pub outside of an envelope, e.g. to hand a key to
+// another TEE node. The recipient names its KEM.
+func WrapKey(pub crypto.PublicKey, secret []byte) (*Recipient, error) {
+	return wrapFor(pub, secret, "")
+}
+
+// UnwrapKey unwraps a secret wrapped to kp, or its previous key, with WrapKey
+func (kp *KeyPair) UnwrapKey(r *Recipient) ([]byte, error) {
+	if err := kp.checkKeyID(r); err != nil {
+		if prev := kp.Previous(); prev != nil && errors.Is(err, ErrNotARecipient) {
+			return prev.UnwrapKey(r)
+		}
+		return nil, err
+	}
+	return kp.unwrapRecipient(r, "")
+}
+
+// wrapFor wraps secret for pub with the KEM matching its key type, named in
+// the recipient when it is not the envelope's
+func wrapFor(pub crypto.PublicKey, secret []byte, envKEM string) (*Recipient, error) {
+	kemName, err := kemFor(pub)
+	if err != nil {
+		return nil, err
+	}
+	kem, err := lookup(kems, "kem", kemName)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := kem.Wrap(pub, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap key for %s: %w", keyID, err)
+	}
+
+	recipient := &Recipient{KeyID: keyID, WrappedKey: base64.StdEncoding.EncodeToString(wrapped)}
+	if kemName != envKEM {
+		recipient.KEM = kemName
+	}
+	return recipient, nil
+}
+
+// ParseEnvelope decodes an envelope of any supported version, including the
+// unversioned HybridEncryptedData format and streamed envelopes, and checks
+// that its algorithms are known
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	if bytes.HasPrefix(data, streamMagic) {
+		r := bytes.NewReader(data[len(streamMagic):])
+		env, err := readStreamHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		env.segments = data[len(data)-r.Len():]
+		return env, nil
+	}
+
+	env, err := parseEnvelopeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.SegmentSize != 0 {
+		return nil, errors.New("segment_size is only valid in a stream")
+	}
+	return env, nil
+}
+
+func parseEnvelopeJSON(data []byte) (*Envelope, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	decode, ok := envelopeDecoders[header.Version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, header.Version)
+	}
+	env, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %d envelope: %w", header.Version, err)
+	}
+
+	if _, err := env.Suite().resolve(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func decodeEnvelope(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if env.KEM == "" || env.AEAD == "" || env.KDF == "" {
+		return nil, errors.New("kem, aead and kdf are required")
+	}
+
+	// only version 3 authenticates the binding
+	if env.Version < 3 {
+		env.Binding = nil
+	} else if env.Binding == nil {
+		return nil, errors.New("binding is required")
+	}
+	if env.OwnerShare != nil && env.Threshold > 0 {
+		return nil, errors.New("owner_share cannot be combined with a threshold")
+	}
+	if (env.KMSKeyID != "") != (env.KEM == KEMKMS) {
+		return nil, fmt.Errorf("kms_key_id requires kem %s and the reverse", KEMKMS)
+	}
+	// any wrapped copy of a KMS key would survive its destruction
+	if env.KMSKeyID != "" && (len(env.Recipients) > 0 || env.Threshold > 0 || env.OwnerShare != nil || env.Recovery != nil) {
+		return nil, errors.New("kms_key_id cannot be combined with wrapped keys")
+	}
+	if env.SegmentSize != 0 && (env.Version < 3 || env.Ciphertext != "") {
+		return nil, errors.New("segment_size requires version 3 and no inline ciphertext")
+	}
+	return &env, nil
+}
+
+func decodeImplicitSuiteEnvelope(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	env.KEM, env.AEAD, env.KDF = DefaultSuite.KEM, DefaultSuite.AEAD, DefaultSuite.KDF
+	env.Binding = nil
+	return &env, nil
+}
+
+// decodeLegacyEnvelope reads a HybridEncryptedData record, whose single
+// recipient is not identified
+func decodeLegacyEnvelope(data []byte) (*Envelope, error) {
+	var enc HybridEncryptedData
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, err
+	}
+	if enc.EncryptedAESKey == "" {
+		return nil, errors.New("missing encrypted_aes_key")
+	}
+
+	return &Envelope{
+		KEM:        DefaultSuite.KEM,
+		AEAD:       DefaultSuite.AEAD,
+		KDF:        DefaultSuite.KDF,
+		Recipients: []Recipient{{WrappedKey: enc.EncryptedAESKey}},
+		Ciphertext: enc.Ciphertext,
+		Nonce:      enc.Nonce,
+	}, nil
+}
+
+// Open decrypts an envelope of any supported version addressed to kp. It
+// does not check the envelope's binding; callers serving a record must call
+// CheckBinding on the parsed envelope first.
+func (kp *KeyPair) Open(data []byte) ([]byte, error) {
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := kp.DataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	return openContent(env, dataKey)
+}
+
+// DataKey unwraps the data key of an envelope addressed to kp, e.g. for
+// OpenStream. It fails like Open for envelopes kp cannot decrypt alone.
+func (kp *KeyPair) DataKey(env *Envelope) ([]byte, error) {
+	if env.KMSKeyID != "" {
+		return nil, ErrKMSEnvelope
+	}
+	if env.Threshold > 0 {
+		return nil, ErrThresholdEnvelope
+	}
+	if env.OwnerShare != nil {
+		return nil, ErrDualControlEnvelope
+	}
+	return kp.unwrap(env)
+}
+
+// UnwrapShare returns the key share wrapped for kp in a threshold envelope
+func (kp *KeyPair) UnwrapShare(env *Envelope) ([]byte, error) {
+	if env.Threshold == 0 {
+		return nil, errors.New("envelope is not a threshold envelope")
+	}
+	return kp.unwrap(env)
+}
+
+// OpenWithShares decrypts a threshold envelope from at least Threshold shares
+func OpenWithShares(env *Envelope, shares [][]byte) ([]byte, error) {
+	dataKey, err := ThresholdKey(env, shares)
+	if err != nil {
+		return nil, err
+	}
+	return openContent(env, dataKey)
+}
+
+// ThresholdKey combines at least Threshold shares into the data key of a
+// threshold envelope
+func ThresholdKey(env *Envelope, shares [][]byte) ([]byte, error) {
+	if env.Threshold == 0 {
+		return nil, errors.New("envelope is not a threshold envelope")
+	}
+	if len(shares) < env.Threshold {
+		return nil, fmt.Errorf("need %d key shares, have %d", env.Threshold, len(shares))
+	}
+	return CombineShares(shares)
+}
+
+// unwrap returns the secret wrapped for kp in env, falling back to kp's
+// previous key for envelopes not yet rewrapped
+func (kp *KeyPair) unwrap(env *Envelope) ([]byte, error) {
+	secret, err := kp.unwrapOwn(env)
+	if prev := kp.Previous(); err != nil && prev != nil {
+		// keep the error of the current key unless the previous one is a
+		// recipient, e.g. of a legacy record
+		if prevSecret, prevErr := prev.unwrap(env); !errors.Is(prevErr, ErrNotARecipient) {
+			return prevSecret, prevErr
+		}
+	}
+	return secret, err
+}
+
+func (kp *KeyPair) unwrapOwn(env *Envelope) ([]byte, error) {
+	keyID, err := KeyID(kp.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range env.Recipients {
+		// legacy records have a single recipient without key id
+		if r.KeyID != keyID && r.KeyID != "" {
+			continue
+		}
+		return kp.unwrapRecipient(&r, env.KEM)
+	}
+
+	return nil, ErrNotARecipient
+}
+
+// unwrapRecipient unwraps the secret of r with its KEM, or envKEM if it does
+// not name one
+func (kp *KeyPair) unwrapRecipient(r *Recipient, envKEM string) ([]byte, error) {
+	kemName := envKEM
+	if r.KEM != "" {
+		kemName = r.KEM
+	}
+	kem, err := lookup(kems, "kem", kemName)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(r.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+	secret, err := kem.Unwrap(kp, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	return secret, nil
+}
+
+// checkKeyID returns ErrNotARecipient unless r is wrapped to kp
+func (kp *KeyPair) checkKeyID(r *Recipient) error {
+	keyID, err := KeyID(kp.Public())
+	if err != nil {
+		return err
+	}
+	if r.KeyID != keyID {
+		return ErrNotARecipient
+	}
+	return nil
+}
+
+// openContent decrypts the envelope contents with its data key
+func openContent(env *Envelope, dataKey []byte) ([]byte, error) {
+	if env.SegmentSize != 0 {
+		plaintext, err := OpenStream(env, dataKey, bytes.NewReader(env.segments))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(plaintext)
+	}
+
+	suite, err := env.Suite().resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+
+	aead, err := suite.contentCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+
+	var aad []byte
+	if env.Binding != nil {
+		aad = env.Binding.AssociatedData()
+	}
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
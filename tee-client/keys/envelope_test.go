@@ -0,0 +1,301 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+var testBinding = keys.RecordBinding{
+	ProgramID: solanago.MustPublicKeyFromBase58("8zjg3UihgxJ3H8AtWfLdGkfBGauVyvJHAQaKW8v1y4Mj"),
+	Owner:     solanago.MustPublicKeyFromBase58("9xQeWvG816bUx9EPjHmaT23yvVM2ZWbrrpZb9PusVFin"),
+	RecordID:  7,
+	MimeType:  "application/pdf",
+}
+
+func generateKeyPairs(t *testing.T, n int) []*keys.KeyPair {
+	var kps []*keys.KeyPair
+	for i := 0; i < n; i++ {
+		kp, err := keys.GenerateKeyPair(2048, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kps = append(kps, kp)
+	}
+	return kps
+}
+
+func TestEnvelopeOpensForEveryRecipient(t *testing.T) {
+	kps := generateKeyPairs(t, 3)
+	plaintext := []byte("blood test results")
+
+	envelope, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{kps[0].PublicKey, kps[1].PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, kp := range kps[:2] {
+		decrypted, err := kp.Open(envelope)
+		if err != nil {
+			t.Fatalf("recipient %d failed to open envelope: %v", i, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("recipient %d got %q", i, decrypted)
+		}
+	}
+
+	if _, err := kps[2].Open(envelope); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient for a non-recipient, got %v", err)
+	}
+}
+
+func TestEnvelopeOpensLegacyFormat(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+	plaintext := []byte("legacy record")
+
+	legacy, err := kp.EncryptFile(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := kp.Open(legacy)
+	if err != nil {
+		t.Fatalf("failed to open legacy record: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+
+	sealed, err := keys.Seal([]byte("record"), testBinding, []crypto.PublicKey{kp.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env keys.Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := []byte(env.Ciphertext)
+	flipped[0] ^= 'A' ^ 'B'
+	env.Ciphertext = string(flipped)
+	tampered, _ := json.Marshal(env)
+	if _, err := kp.Open(tampered); err == nil {
+		t.Error("expected tampered ciphertext to be rejected")
+	}
+
+	env.Version = 99
+	future, _ := json.Marshal(env)
+	if _, err := kp.Open(future); err == nil {
+		t.Error("expected unknown version to be rejected")
+	}
+}
+
+func TestParseRegisteredPublicKey(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+
+	registered, err := kp.ExportPublicKeyBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := keys.ParseRegisteredPublicKey([]byte(registered))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := keys.KeyID(kp.PublicKey)
+	got, _ := keys.KeyID(pub)
+	if got != want {
+		t.Error("key id of the registered key does not match")
+	}
+}
+
+func TestThresholdEnvelopeNeedsThresholdShares(t *testing.T) {
+	kps := generateKeyPairs(t, 3)
+	plaintext := []byte("mri scan")
+
+	sealed, err := keys.SealThreshold(plaintext, testBinding, []crypto.PublicKey{kps[0].PublicKey, kps[1].PublicKey, kps[2].PublicKey}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kps[0].Open(sealed); !errors.Is(err, keys.ErrThresholdEnvelope) {
+		t.Fatalf("expected ErrThresholdEnvelope from Open, got %v", err)
+	}
+
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shares [][]byte
+	for _, kp := range kps {
+		share, err := kp.UnwrapShare(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares = append(shares, share)
+	}
+
+	if _, err := keys.OpenWithShares(env, shares[:1]); err == nil {
+		t.Error("expected a single share to be rejected")
+	}
+
+	decrypted, err := keys.OpenWithShares(env, [][]byte{shares[2], shares[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestEnvelopeOpensImplicitSuiteVersion(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+	plaintext := []byte("version 1 record")
+
+	// version 1 envelopes, as written by older apps, name no algorithms and
+	// have no associated data
+	aesKey := make([]byte, 32)
+	nonce := make([]byte, 12)
+	rand.Read(aesKey)
+	rand.Read(nonce)
+	block, _ := aes.NewCipher(aesKey)
+	aesgcm, _ := cipher.NewGCM(block)
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, kp.PublicKey, aesKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, _ := keys.KeyID(kp.PublicKey)
+
+	v1, _ := json.Marshal(map[string]any{
+		"version":    1,
+		"recipients": []keys.Recipient{{KeyID: keyID, WrappedKey: base64.StdEncoding.EncodeToString(wrapped)}},
+		"ciphertext": base64.StdEncoding.EncodeToString(aesgcm.Seal(nil, nonce, plaintext, nil)),
+		"nonce":      base64.StdEncoding.EncodeToString(nonce),
+	})
+
+	decrypted, err := kp.Open(v1)
+	if err != nil {
+		t.Fatalf("failed to open version 1 envelope: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestEnvelopeRejectsUnknownAlgorithms(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+
+	sealed, err := keys.Seal([]byte("record"), testBinding, []crypto.PublicKey{kp.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(*keys.Envelope)
+	}{
+		{"kem", func(e *keys.Envelope) { e.KEM = "ElGamal" }},
+		{"aead", func(e *keys.Envelope) { e.AEAD = "DES-CBC" }},
+		{"kdf", func(e *keys.Envelope) { e.KDF = "MD5" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var env keys.Envelope
+			if err := json.Unmarshal(sealed, &env); err != nil {
+				t.Fatal(err)
+			}
+			tc.mutate(&env)
+			data, _ := json.Marshal(env)
+
+			_, err := kp.Open(data)
+			if !errors.Is(err, keys.ErrUnsupportedAlgorithm) {
+				t.Fatalf("expected ErrUnsupportedAlgorithm, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.name) {
+				t.Errorf("error %q should name the %s", err, tc.name)
+			}
+		})
+	}
+
+	var env keys.Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.KDF = ""
+	missing, _ := json.Marshal(env)
+	if _, err := kp.Open(missing); err == nil {
+		t.Error("expected version 2 envelope without kdf to be rejected")
+	}
+
+	env.Version = 99
+	future, _ := json.Marshal(env)
+	if _, err := kp.Open(future); !errors.Is(err, keys.ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestEnvelopeBindsRecord(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+
+	sealed, err := keys.Seal([]byte("record"), testBinding, []crypto.PublicKey{kp.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.CheckBinding(testBinding); err != nil {
+		t.Fatalf("expected binding to match: %v", err)
+	}
+
+	other := testBinding
+	other.RecordID++
+	if err := env.CheckBinding(other); !errors.Is(err, keys.ErrBindingMismatch) {
+		t.Errorf("expected ErrBindingMismatch for another record, got %v", err)
+	}
+
+	// re-pointing the blob by editing its binding breaks the AEAD tag
+	var fields map[string]any
+	if err := json.Unmarshal(sealed, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["binding"].(map[string]any)["record_id"] = "8"
+	repointed, _ := json.Marshal(fields)
+	if _, err := kp.Open(repointed); err == nil {
+		t.Error("expected envelope with edited binding to fail to decrypt")
+	}
+
+	// as does dropping the binding by claiming an older version
+	delete(fields, "binding")
+	fields["version"] = 2
+	downgraded, _ := json.Marshal(fields)
+	if _, err := kp.Open(downgraded); err == nil {
+		t.Error("expected envelope downgraded to version 2 to fail to decrypt")
+	}
+
+	fields["version"] = 3
+	unbound, _ := json.Marshal(fields)
+	if _, err := keys.ParseEnvelope(unbound); err == nil {
+		t.Error("expected version 3 envelope without binding to be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/circl/hpke"
+)
+
+// hpkeInfo separates envelope key wrapping from other uses of HPKE
+var hpkeInfo = []byte("healthlock envelope key")
+
+// hpkeKEM wraps keys with single-shot HPKE in base mode (RFC 9180), with
+// HKDF-SHA256 and AES-256-GCM over the given KEM. The wrapped key is the
+// encapsulated key followed by the sealed data key.
+type hpkeKEM struct {
+	name string
+	kem  hpke.KEM
+	// publicKey and privateKey return the raw keys HPKE takes, or false if
+	// the key is of another type
+	publicKey  func(pub crypto.PublicKey) ([]byte, bool)
+	privateKey func(kp *KeyPair) ([]byte, bool)
+}
+
+func (h hpkeKEM) suite() hpke.Suite {
+	return hpke.NewSuite(h.kem, hpke.KDF_HKDF_SHA256, hpke.AEAD_AES256GCM)
+}
+
+func (h hpkeKEM) Wrap(recipient crypto.PublicKey, key []byte) ([]byte, error) {
+	raw, ok := h.publicKey(recipient)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot wrap to a %T", h.name, recipient)
+	}
+
+	pkR, err := h.kem.Scheme().UnmarshalBinaryPublicKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := h.suite().NewSender(pkR, hpkeInfo)
+	if err != nil {
+		return nil, err
+	}
+	enc, sealer, err := sender.Setup(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := sealer.Seal(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(enc, ct...), nil
+}
+
+func (h hpkeKEM) Unwrap(kp *KeyPair, wrapped []byte) ([]byte, error) {
+	raw, ok := h.privateKey(kp)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot unwrap with a %s key pair", h.name, kp.Type())
+	}
+
+	scheme := h.kem.Scheme()
+	encSize := scheme.CiphertextSize()
+	if len(wrapped) < encSize {
+		return nil, errors.New("wrapped key too short")
+	}
+	skR, err := scheme.UnmarshalBinaryPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := h.suite().NewReceiver(skR, hpkeInfo)
+	if err != nil {
+		return nil, err
+	}
+	opener, err := receiver.Setup(wrapped[:encSize])
+	if err != nil {
+		return nil, err
+	}
+	return opener.Open(wrapped[encSize:], nil)
+}
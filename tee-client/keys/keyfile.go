@@ -0,0 +1,79 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// A node key file keeps the private key of a node on its local disk, which
+// lies outside the trust boundary of a confidential VM. It is sealed under a
+// key derived from the TEE sealing key, so only the same TEE image on the same
+// machine can load it:
+//
+//	"HLKEY1" || nonce || AES-GCM(PKCS#8 DER of the private key)
+var keyFileMagic = []byte("HLKEY1")
+
+// keyFileKeyInfo derives the key file key from the TEE sealing key
+var keyFileKeyInfo = []byte("healthlock node key file v1")
+
+// ErrKeyFileNotSealed is returned by OpenKeyFile for key files of earlier
+// versions, which hold the key in plaintext PEM
+var ErrKeyFileNotSealed = errors.New("key file is not sealed")
+
+// SealKeyFile returns the private key of kp sealed under sealingKey, which
+// normally comes from tee.Sealer
+func SealKeyFile(kp *KeyPair, sealingKey []byte) ([]byte, error) {
+	der, err := kp.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyFileCipher(sealingKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, keyFileMagic...), nonce...)
+	return aead.Seal(out, nonce, der, keyFileMagic), nil
+}
+
+// OpenKeyFile returns the key pair sealed by SealKeyFile
+func OpenKeyFile(data, sealingKey []byte) (*KeyPair, error) {
+	sealed, ok := bytes.CutPrefix(data, keyFileMagic)
+	if !ok {
+		return nil, ErrKeyFileNotSealed
+	}
+	aead, err := keyFileCipher(sealingKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed key file too short")
+	}
+	der, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], keyFileMagic)
+	if err != nil {
+		return nil, errors.New("key file is sealed to another TEE image or machine")
+	}
+	return ParsePrivateKey(der)
+}
+
+func keyFileCipher(sealingKey []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sealingKey, nil, keyFileKeyInfo), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,59 @@
+package keys_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestKeyFileIsSealed(t *testing.T) {
+	sealingKey := bytes.Repeat([]byte{1}, 32)
+
+	for _, keyType := range []string{keys.KeyTypeRSA2048, keys.KeyTypeX25519, keys.KeyTypeXWing} {
+		t.Run(keyType, func(t *testing.T) {
+			kp, err := keys.NewKeyPair(keyType, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := keys.SealKeyFile(kp, sealingKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := kp.MarshalPrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sealed, der[len(der)-32:]) {
+				t.Fatal("key file holds the key in the clear")
+			}
+
+			opened, err := keys.OpenKeyFile(sealed, sealingKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := kp.ExportPublicKeyBase64()
+			if got, err := opened.ExportPublicKeyBase64(); err != nil || got != want {
+				t.Errorf("opened another key: %v", err)
+			}
+
+			// another machine or image derives another sealing key
+			if _, err := keys.OpenKeyFile(sealed, bytes.Repeat([]byte{2}, 32)); err == nil {
+				t.Error("key file opened with another sealing key")
+			}
+		})
+	}
+
+	kp, err := keys.NewKeyPair(keys.KeyTypeX25519, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := kp.ExportPrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.OpenKeyFile([]byte(legacy), sealingKey); !errors.Is(err, keys.ErrKeyFileNotSealed) {
+		t.Errorf("expected ErrKeyFileNotSealed, got %v", err)
+	}
+}
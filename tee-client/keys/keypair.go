@@ -0,0 +1,230 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Key types a node can be configured with
+const (
+	KeyTypeRSA2048 = "rsa-2048"
+	KeyTypeX25519  = "x25519"          // wrapped with HPKE, see KEMX25519HPKE
+	KeyTypeXWing   = "x25519-mlkem768" // hybrid post-quantum, see KEMXWingHPKE
+)
+
+// registeredCommitmentPrefix marks a registered key that is too large for
+// TEEState.pubkey; the key id follows and the key itself is published in the
+// attestation bundle
+const registeredCommitmentPrefix = "sha256:"
+
+// ErrKeyNotOnChain is returned by ParseRegisteredPublicKey for keys that are
+// registered by commitment; use ParsePublishedPublicKey with the key from the
+// node's attestation bundle
+var ErrKeyNotOnChain = errors.New("registered key is published in the attestation bundle")
+
+// NewKeyPair generates a key pair of the given type. In debug mode the key is
+// loaded from, or saved to, the working directory.
+func NewKeyPair(keyType string, debug bool) (*KeyPair, error) {
+	switch keyType {
+	case "", KeyTypeRSA2048:
+		return GenerateKeyPair(2048, debug)
+	case KeyTypeX25519:
+		return GenerateX25519KeyPair(debug)
+	case KeyTypeXWing:
+		return GenerateXWingKeyPair(debug)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+// Type returns the key type of kp
+func (kp *KeyPair) Type() string {
+	switch {
+	case kp.XWing != nil:
+		return KeyTypeXWing
+	case kp.X25519 != nil:
+		return KeyTypeX25519
+	default:
+		return KeyTypeRSA2048
+	}
+}
+
+// Public returns the public key of kp: an *rsa.PublicKey, an X25519
+// *ecdh.PublicKey or an *XWingPublicKey
+func (kp *KeyPair) Public() crypto.PublicKey {
+	switch {
+	case kp.XWing != nil:
+		return &XWingPublicKey{key: kp.XWing.Public()}
+	case kp.X25519 != nil:
+		return kp.X25519.PublicKey()
+	default:
+		return kp.PublicKey
+	}
+}
+
+// RegisteredKey returns the key as registered on chain and bound by the
+// attestation nonce. That is the base64 of its DER encoding, except for keys
+// too large for TEEState.pubkey, which register "sha256:" and their KeyID
+// and are published in the attestation bundle instead.
+func (kp *KeyPair) RegisteredKey() ([]byte, error) {
+	if kp.XWing != nil {
+		keyID, err := KeyID(kp.Public())
+		if err != nil {
+			return nil, err
+		}
+		return []byte(registeredCommitmentPrefix + keyID), nil
+	}
+
+	pubKeyBase64, err := kp.ExportPublicKeyBase64()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(pubKeyBase64), nil
+}
+
+// MarshalPrivateKey returns the private key as PKCS#8 DER
+func (kp *KeyPair) MarshalPrivateKey() ([]byte, error) {
+	switch {
+	case kp.XWing != nil:
+		seed, err := kp.XWing.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidXWing}, PrivateKey: seed})
+	case kp.X25519 != nil:
+		return x509.MarshalPKCS8PrivateKey(kp.X25519)
+	default:
+		return x509.MarshalPKCS8PrivateKey(kp.PrivateKey)
+	}
+}
+
+// ParsePrivateKey reads a key pair from PKCS#8 DER, or PKCS#1 DER for RSA
+func ParsePrivateKey(der []byte) (*KeyPair, error) {
+	if rsaKey, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return &KeyPair{PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey}, nil
+	}
+
+	// x509 does not know X-Wing
+	var info pkcs8
+	if rest, err := asn1.Unmarshal(der, &info); err == nil && len(rest) == 0 && info.Algorithm.Algorithm.Equal(oidXWing) {
+		return xwingKeyPair(info.PrivateKey)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &KeyPair{PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case *ecdh.PrivateKey:
+		if key.Curve() != ecdh.X25519() {
+			return nil, errors.New("unsupported ECDH curve")
+		}
+		return &KeyPair{X25519: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// ImportKeyPairPEM reads a key pair written by ExportPrivateKeyPEM
+func ImportKeyPairPEM(pemData string) (*KeyPair, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil || (block.Type != "RSA PRIVATE KEY" && block.Type != "PRIVATE KEY") {
+		return nil, errors.New("invalid PEM private key")
+	}
+	return ParsePrivateKey(block.Bytes)
+}
+
+// SaveKeyPairPEM saves the private key of kp to a file in PEM format
+func SaveKeyPairPEM(filename string, kp *KeyPair) error {
+	privPEM, err := kp.ExportPrivateKeyPEM()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, []byte(privPEM), 0600)
+}
+
+// marshalPublicKey returns the PKIX DER encoding of pub
+func marshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	if pub, ok := pub.(*XWingPublicKey); ok {
+		raw, err := pub.key.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(subjectPublicKeyInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidXWing},
+			PublicKey: asn1.BitString{Bytes: raw, BitLength: 8 * len(raw)},
+		})
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// parsePublicKey reads a PKIX DER public key of a supported type
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	var info subjectPublicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err == nil && len(rest) == 0 && info.Algorithm.Algorithm.Equal(oidXWing) {
+		return ParseXWingPublicKey(info.PublicKey.RightAlign())
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pub, nil
+	case *ecdh.PublicKey:
+		if pub.Curve() == ecdh.X25519() {
+			return pub, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// subjectPublicKeyInfo and pkcs8 are the PKIX and PKCS#8 structures, for key
+// types crypto/x509 does not support
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// generateDebugKeyPair runs generate, but in debug mode first tries to load
+// the key from file and saves the generated one there
+func generateDebugKeyPair(file, name string, debug bool, generate func() (*KeyPair, error)) (*KeyPair, error) {
+	if debug {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			return ImportKeyPairPEM(string(data))
+		}
+
+		fmt.Printf("No existing key found, generating new %s key pair in debug mode...\n", name)
+	}
+
+	kp, err := generate()
+	if err != nil {
+		return nil, err
+	}
+
+	if debug {
+		if err := SaveKeyPairPEM(file, kp); err != nil {
+			return nil, fmt.Errorf("failed to write private key to file: %w", err)
+		}
+	}
+
+	return kp, nil
+}
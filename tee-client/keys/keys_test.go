@@ -12,7 +12,7 @@ import (
 
 func TestEncryptDecryptImage(t *testing.T) {
 	// Generate RSA key pair
-	kp, err := keys.GenerateKeyPair(2048)
+	kp, err := keys.GenerateKeyPair(2048, false)
 	if err != nil {
 		t.Fatalf("Failed to generate key pair: %v", err)
 	}
@@ -44,7 +44,7 @@ func TestEncryptDecryptImage(t *testing.T) {
 }
 
 func TestPEMExportImport(t *testing.T) {
-	kp, err := keys.GenerateKeyPair(2048)
+	kp, err := keys.GenerateKeyPair(2048, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,7 +66,7 @@ func TestPEMExportImport(t *testing.T) {
 }
 
 func TestExportPublicKeyBase64(t *testing.T) {
-	kp, err := keys.GenerateKeyPair(2048)
+	kp, err := keys.GenerateKeyPair(2048, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,6 +85,9 @@ func TestExportPublicKeyBase64(t *testing.T) {
 func TestDecryptNodeEncryptedFile(t *testing.T) {
 	// Load private key from PEM
 	privKeyPEM, err := os.ReadFile("private.pem")
+	if os.IsNotExist(err) {
+		t.Skip("private.pem and encrypted.json from the Node.js encryptor are not present")
+	}
 	if err != nil {
 		t.Fatalf("Failed to read private.pem: %v", err)
 	}
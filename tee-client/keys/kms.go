@@ -0,0 +1,45 @@
+package keys
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+)
+
+// kmsKEM stands in for the KEM of envelopes whose data key is held by the
+// TEE key management service, which wraps nothing
+type kmsKEM struct{}
+
+func (kmsKEM) Wrap(crypto.PublicKey, []byte) ([]byte, error) {
+	return nil, ErrKMSEnvelope
+}
+
+func (kmsKEM) Unwrap(*KeyPair, []byte) ([]byte, error) {
+	return nil, ErrKMSEnvelope
+}
+
+// SealKMS encrypts plaintext, bound to its record, under a data key held by
+// the TEE key management service as keyID. The envelope carries no wrapped
+// copy of the key.
+func SealKMS(plaintext []byte, binding RecordBinding, keyID string, dataKey []byte) ([]byte, error) {
+	if keyID == "" {
+		return nil, errors.New("kms key id is required")
+	}
+
+	env := emptyEnvelope(Suite{KEM: KEMKMS, AEAD: DefaultSuite.AEAD, KDF: KDFHKDFSHA256}, binding)
+	env.KMSKeyID = keyID
+	if err := env.sealContent(dataKey, plaintext); err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// OpenKMS decrypts an envelope sealed with SealKMS with the data key the key
+// management service holds for env.KMSKeyID. Like Open, it does not check
+// the binding.
+func OpenKMS(env *Envelope, dataKey []byte) ([]byte, error) {
+	if env.KMSKeyID == "" {
+		return nil, errors.New("envelope key is not held by the key management service")
+	}
+	return openContent(env, dataKey)
+}
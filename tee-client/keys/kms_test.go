@@ -0,0 +1,55 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestKMSEnvelopeCarriesNoKey(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("psychiatric assessment")
+
+	sealed, err := keys.SealKMS(plaintext, testBinding, "00112233445566778899aabbccddeeff", dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.KEM != keys.KEMKMS || len(env.Recipients) != 0 || env.Recovery != nil {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+
+	if _, err := kp.Open(sealed); !errors.Is(err, keys.ErrKMSEnvelope) {
+		t.Errorf("expected ErrKMSEnvelope, got %v", err)
+	}
+
+	decrypted, err := keys.OpenKMS(env, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+
+	// a wrapped copy of the key next to the key id would defeat shredding
+	var raw map[string]interface{}
+	if err := json.Unmarshal(sealed, &raw); err != nil {
+		t.Fatal(err)
+	}
+	raw["recipients"] = []map[string]string{{"key_id": "x", "wrapped_key": "eA=="}}
+	tampered, _ := json.Marshal(raw)
+	if _, err := keys.ParseEnvelope(tampered); err == nil {
+		t.Error("accepted a kms envelope with recipients")
+	}
+}
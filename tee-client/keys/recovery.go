@@ -0,0 +1,62 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+
+	"filippo.io/edwards25519"
+	solanago "github.com/gagliardetto/solana-go"
+)
+
+// ErrNoRecoveryKey is returned by Recover for envelopes sealed without a
+// recovery entry
+var ErrNoRecoveryKey = errors.New("envelope has no recovery entry")
+
+// OwnerRecoveryKey converts an owner's Solana address, an Ed25519 public key,
+// to the X25519 key its wallet can derive the private half of, see
+// OwnerRecoveryKeyPair. Sealing with it as SealOptions.Recovery lets the owner
+// decrypt the record without any TEE.
+func OwnerRecoveryKey(owner solanago.PublicKey) (*ecdh.PublicKey, error) {
+	point, err := new(edwards25519.Point).SetBytes(owner[:])
+	if err != nil {
+		return nil, fmt.Errorf("owner %s is not an Ed25519 key: %w", owner, err)
+	}
+	return ecdh.X25519().NewPublicKey(point.BytesMontgomery())
+}
+
+// OwnerRecoveryKeyPair derives the X25519 key pair of OwnerRecoveryKey from
+// the owner's wallet, as in RFC 8032 the scalar is the first half of the
+// SHA-512 of the Ed25519 seed
+func OwnerRecoveryKeyPair(wallet solanago.PrivateKey) (*KeyPair, error) {
+	if len(wallet) != 64 {
+		return nil, fmt.Errorf("invalid wallet key length %d", len(wallet))
+	}
+	digest := sha512.Sum512(wallet[:32])
+	priv, err := ecdh.X25519().NewPrivateKey(digest[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{X25519: priv}, nil
+}
+
+// Recover decrypts an envelope through its recovery entry, which holds the
+// whole data key even for threshold envelopes
+func (kp *KeyPair) Recover(data []byte) ([]byte, error) {
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.Recovery == nil {
+		return nil, ErrNoRecoveryKey
+	}
+
+	recovery := *env
+	recovery.Recipients = []Recipient{*env.Recovery}
+	dataKey, err := kp.unwrap(&recovery)
+	if err != nil {
+		return nil, err
+	}
+	return openContent(env, dataKey)
+}
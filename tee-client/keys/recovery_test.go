@@ -0,0 +1,84 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestOwnerRecoveryKeyMatchesWallet(t *testing.T) {
+	wallet := solanago.NewWallet()
+
+	pub, err := keys.OwnerRecoveryKey(wallet.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp, err := keys.OwnerRecoveryKeyPair(wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := keys.KeyID(pub)
+	got, _ := keys.KeyID(kp.Public())
+	if got != want {
+		t.Error("key derived from the wallet does not match the one derived from its address")
+	}
+}
+
+func TestRecoverWithoutTEE(t *testing.T) {
+	tees := generateKeyPairs(t, 3)
+	wallet := solanago.NewWallet()
+	binding := testBinding
+	binding.Owner = wallet.PublicKey()
+	plaintext := []byte("vaccination record")
+
+	recovery, err := keys.OwnerRecoveryKey(wallet.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := keys.OwnerRecoveryKeyPair(wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := []crypto.PublicKey{tees[0].Public(), tees[1].Public(), tees[2].Public()}
+	for _, opts := range []keys.SealOptions{
+		{Recovery: recovery},
+		// the owner holds the whole key even when the TEEs hold shares
+		{Recovery: recovery, Threshold: 2},
+	} {
+		sealed, err := keys.SealWithOptions(plaintext, binding, recipients, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := owner.Recover(sealed)
+		if err != nil {
+			t.Fatalf("threshold %d: %v", opts.Threshold, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("threshold %d: got %q", opts.Threshold, decrypted)
+		}
+
+		// another wallet cannot use the escape hatch
+		other, err := keys.OwnerRecoveryKeyPair(solanago.NewWallet().PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Recover(sealed); !errors.Is(err, keys.ErrNotARecipient) {
+			t.Errorf("threshold %d: expected ErrNotARecipient, got %v", opts.Threshold, err)
+		}
+	}
+
+	sealed, err := keys.Seal(plaintext, binding, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.Recover(sealed); !errors.Is(err, keys.ErrNoRecoveryKey) {
+		t.Errorf("expected ErrNoRecoveryKey, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	solanago "github.com/gagliardetto/solana-go"
+)
+
+// ResponseKeySigner asks for a decrypted record to be sealed to the X25519
+// form of the requester's Solana key, see RequesterKeyPair
+const ResponseKeySigner = "signer"
+
+// ParseResponseKey returns the key a record decrypted for signer is sealed
+// to: for ResponseKeySigner the X25519 form of signer, otherwise the
+// base64 X25519 public key given, normally an ephemeral one
+func ParseResponseKey(responseKey string, signer solanago.PublicKey) (*ecdh.PublicKey, error) {
+	if responseKey == ResponseKeySigner {
+		return OwnerRecoveryKey(signer)
+	}
+	raw, err := base64.StdEncoding.DecodeString(responseKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response key: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// SealResponse writes plaintext to w as a streamed envelope bound to the
+// record it was decrypted from, with key as its only recipient, so that
+// proxies between the TEE and the requester see ciphertext only
+func SealResponse(w io.Writer, plaintext io.Reader, binding RecordBinding, key *ecdh.PublicKey) error {
+	return SealStream(w, plaintext, binding, []crypto.PublicKey{key}, SealOptions{})
+}
+
+// RequesterKeyPair derives the key pair that opens responses sealed for
+// ResponseKeySigner from the requester's wallet, the same derivation as
+// OwnerRecoveryKeyPair
+func RequesterKeyPair(wallet solanago.PrivateKey) (*KeyPair, error) {
+	return OwnerRecoveryKeyPair(wallet)
+}
+
+// ResponseKey returns the X25519 public key of kp as the responseKey of a
+// decrypt request
+func (kp *KeyPair) ResponseKey() (string, error) {
+	if kp.X25519 == nil {
+		return "", errors.New("responses are sealed to X25519 keys only")
+	}
+	return base64.StdEncoding.EncodeToString(kp.X25519.PublicKey().Bytes()), nil
+}
+
+// OpenResponse decrypts a response sealed to kp by SealResponse as it is
+// read, and returns the record it was decrypted from. As with OpenStream, a
+// read error means the response was tampered with or cut off.
+func (kp *KeyPair) OpenResponse(r io.Reader) (*RecordBinding, io.Reader, error) {
+	env, content, err := ReadEnvelope(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.Binding == nil {
+		return nil, nil, errors.New("response is not bound to a record")
+	}
+	dataKey, err := kp.DataKey(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := OpenStream(env, dataKey, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env.Binding, plaintext, nil
+}
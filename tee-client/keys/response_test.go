@@ -0,0 +1,206 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+const responseVectorsPath = "testdata/response_vectors.json"
+
+// responseVector is one entry of testdata/response_vectors.json, a response
+// the TS client must open too
+type responseVector struct {
+	Name string `json:"name"`
+	// WalletSeed is the hex Ed25519 seed of a requester that asked for
+	// ResponseKeySigner, PrivateKey the hex X25519 key of one that sent an
+	// ephemeral key
+	WalletSeed string             `json:"wallet_seed,omitempty"`
+	PrivateKey string             `json:"private_key,omitempty"`
+	Binding    keys.RecordBinding `json:"binding"`
+	Size       int                `json:"size"`
+	SHA256     string             `json:"sha256"`   // hex, of the plaintext
+	Response   string             `json:"response"` // base64
+}
+
+func (v responseVector) keyPair(t *testing.T) *keys.KeyPair {
+	if v.WalletSeed != "" {
+		seed, _ := hex.DecodeString(v.WalletSeed)
+		kp, err := keys.RequesterKeyPair(solanago.PrivateKey(ed25519.NewKeyFromSeed(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kp
+	}
+	raw, _ := hex.DecodeString(v.PrivateKey)
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &keys.KeyPair{X25519: priv}
+}
+
+func TestResponseToSignerOpensWithWallet(t *testing.T) {
+	wallet := solanago.NewWallet()
+	plaintext := make([]byte, keys.StreamSegmentSize+10)
+	rand.Read(plaintext)
+
+	key, err := keys.ParseResponseKey(keys.ResponseKeySigner, wallet.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response bytes.Buffer
+	if err := keys.SealResponse(&response, bytes.NewReader(plaintext), testBinding, key); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(response.Bytes(), plaintext[:32]) {
+		t.Fatal("response holds plaintext")
+	}
+
+	kp, err := keys.RequesterKeyPair(wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binding, opened, err := kp.OpenResponse(bytes.NewReader(response.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(opened)
+	if err != nil || !bytes.Equal(got, plaintext) || *binding != testBinding {
+		t.Fatalf("opened %d bytes of %+v: %v", len(got), binding, err)
+	}
+
+	// nobody else opens it
+	other, err := keys.RequesterKeyPair(solanago.NewWallet().PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := other.OpenResponse(bytes.NewReader(response.Bytes())); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient, got %v", err)
+	}
+}
+
+func TestResponseToEphemeralKey(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseKey, err := kp.ResponseKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.ParseResponseKey(responseKey, solanago.NewWallet().PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response bytes.Buffer
+	if err := keys.SealResponse(&response, bytes.NewReader([]byte("lab results")), testBinding, key); err != nil {
+		t.Fatal(err)
+	}
+	_, opened, err := kp.OpenResponse(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(opened); err != nil || string(got) != "lab results" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	for _, invalid := range []string{"", "not base64", base64.StdEncoding.EncodeToString(make([]byte, 31))} {
+		if _, err := keys.ParseResponseKey(invalid, solanago.NewWallet().PublicKey()); err == nil {
+			t.Errorf("accepted response key %q", invalid)
+		}
+	}
+}
+
+func TestResponseVectors(t *testing.T) {
+	if *updateVectors {
+		writeResponseVectors(t)
+	}
+
+	data, err := os.ReadFile(responseVectorsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []responseVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			response, _ := base64.StdEncoding.DecodeString(v.Response)
+			binding, opened, err := v.keyPair(t).OpenResponse(bytes.NewReader(response))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plaintext, err := io.ReadAll(opened)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(plaintext)
+			if len(plaintext) != v.Size || hex.EncodeToString(sum[:]) != v.SHA256 || *binding != v.Binding {
+				t.Errorf("opened %d bytes of %+v", len(plaintext), binding)
+			}
+		})
+	}
+}
+
+func writeResponseVectors(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	wallet := solanago.PrivateKey(ed25519.NewKeyFromSeed(seed))
+	ephemeral, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeralKey, _ := ephemeral.ResponseKey()
+
+	vectors := []responseVector{
+		{Name: "sealed to the signer", WalletSeed: hex.EncodeToString(seed), Size: 11},
+		{Name: "sealed to an ephemeral key", PrivateKey: hex.EncodeToString(ephemeral.X25519.Bytes()), Size: keys.StreamSegmentSize + 5},
+	}
+	for i, v := range vectors {
+		responseKey := keys.ResponseKeySigner
+		if v.PrivateKey != "" {
+			responseKey = ephemeralKey
+		}
+		key, err := keys.ParseResponseKey(responseKey, wallet.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext := make([]byte, v.Size)
+		rand.Read(plaintext)
+		sum := sha256.Sum256(plaintext)
+		var response bytes.Buffer
+		if err := keys.SealResponse(&response, bytes.NewReader(plaintext), testBinding, key); err != nil {
+			t.Fatal(err)
+		}
+		vectors[i].Binding = testBinding
+		vectors[i].SHA256 = hex.EncodeToString(sum[:])
+		vectors[i].Response = base64.StdEncoding.EncodeToString(response.Bytes())
+	}
+
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(responseVectorsPath, append(data, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package keys
+
+import "errors"
+
+// ErrRewrapped is returned by Rewrap for envelopes already addressed to the
+// current key
+var ErrRewrapped = errors.New("envelope is already addressed to the current key")
+
+// SetPrevious keeps prev, the key kp replaced, in decrypt-only mode: kp falls
+// back to it for envelopes and keys not yet wrapped to kp, but only kp is
+// registered and wrapped to. A nil prev ends the rotation.
+func (kp *KeyPair) SetPrevious(prev *KeyPair) {
+	kp.previous.Store(prev)
+}
+
+// Previous returns the key set with SetPrevious, if any
+func (kp *KeyPair) Previous() *KeyPair {
+	return kp.previous.Load()
+}
+
+// Rewrap re-addresses the entry of kp's previous key in an envelope to kp,
+// leaving the ciphertext, the binding and the entries of other nodes as they
+// are, so only the blob changes and not the record. It returns ErrRewrapped
+// if kp already has an entry, ErrNotARecipient if the previous key has none,
+// which for legacy records without key ids means it cannot unwrap theirs,
+// and ErrKMSEnvelope for envelopes without wrapped keys.
+func (kp *KeyPair) Rewrap(data []byte) ([]byte, error) {
+	prev := kp.Previous()
+	if prev == nil {
+		return nil, errors.New("no previous key to rewrap from")
+	}
+
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.KMSKeyID != "" {
+		return nil, ErrKMSEnvelope
+	}
+
+	keyID, err := KeyID(kp.Public())
+	if err != nil {
+		return nil, err
+	}
+	prevKeyID, err := KeyID(prev.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	index, legacy := -1, false
+	for i, r := range env.Recipients {
+		switch r.KeyID {
+		case keyID:
+			return nil, ErrRewrapped
+		case prevKeyID:
+			index, legacy = i, false
+		case "":
+			// legacy records have a single recipient without key id, which
+			// may be any node's
+			if index < 0 {
+				index, legacy = i, true
+			}
+		}
+	}
+	if index < 0 {
+		return nil, ErrNotARecipient
+	}
+
+	// a key, or a threshold or dual control share, it is rewrapped as is
+	secret, err := prev.unwrapRecipient(&env.Recipients[index], env.KEM)
+	if err != nil && legacy {
+		return nil, ErrNotARecipient
+	}
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := wrapFor(kp.Public(), secret, env.KEM)
+	if err != nil {
+		return nil, err
+	}
+	env.Recipients[index] = *recipient
+
+	// the unversioned format cannot name the recipient; version 1 holds the
+	// same content with key ids
+	if env.Version == 0 {
+		env.Version = 1
+	}
+	return env.encode()
+}
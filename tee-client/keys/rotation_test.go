@@ -0,0 +1,132 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestRotatedKeyOpensRewrappedRecords(t *testing.T) {
+	kps := generateKeyPairs(t, 2)
+	old, other := kps[0], kps[1]
+	plaintext := []byte("mri report")
+
+	sealed, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{old.PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotating may change the key type too
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Open(sealed); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Fatalf("expected ErrNotARecipient before rotation, got %v", err)
+	}
+
+	kp.SetPrevious(old)
+	// the previous key keeps decrypting records not yet rewrapped
+	if decrypted, err := kp.Open(sealed); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("previous key did not decrypt: %q, %v", decrypted, err)
+	}
+
+	rewrapped, err := kp.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Rewrap(rewrapped); !errors.Is(err, keys.ErrRewrapped) {
+		t.Errorf("expected ErrRewrapped, got %v", err)
+	}
+
+	before, _ := keys.ParseEnvelope(sealed)
+	after, err := keys.ParseEnvelope(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Ciphertext != before.Ciphertext || *after.Binding != *before.Binding {
+		t.Error("rewrapping changed the content")
+	}
+	if after.Recipients[1] != before.Recipients[1] {
+		t.Error("rewrapping changed another node's entry")
+	}
+
+	// once the rotation ends only the new key opens the record
+	kp.SetPrevious(nil)
+	if decrypted, err := kp.Open(rewrapped); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("rotated key did not decrypt: %q, %v", decrypted, err)
+	}
+	if decrypted, err := other.Open(rewrapped); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("other node no longer decrypts: %q, %v", decrypted, err)
+	}
+	if _, err := kp.Open(sealed); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient after rotation, got %v", err)
+	}
+}
+
+func TestRewrapLegacyAndThresholdRecords(t *testing.T) {
+	kps := generateKeyPairs(t, 4)
+	old, kp := kps[0], kps[1]
+	kp.SetPrevious(old)
+	plaintext := []byte("discharge letter")
+
+	legacy, err := old.EncryptFile(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := kp.Rewrap(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp.SetPrevious(nil)
+	if decrypted, err := kp.Open(rewrapped); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("rewrapped legacy record: %q, %v", decrypted, err)
+	}
+	kp.SetPrevious(old)
+
+	// the share is moved as is, so the threshold still holds
+	sealed, err := keys.SealThreshold(plaintext, testBinding, []crypto.PublicKey{old.PublicKey, kps[2].PublicKey, kps[3].PublicKey}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped, err = kp.Rewrap(sealed); err != nil {
+		t.Fatal(err)
+	}
+	env, err := keys.ParseEnvelope(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp.SetPrevious(nil)
+	var shares [][]byte
+	for _, holder := range []*keys.KeyPair{kp, kps[3]} {
+		share, err := holder.UnwrapShare(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares = append(shares, share)
+	}
+	if decrypted, err := keys.OpenWithShares(env, shares); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("rewrapped threshold record: %q, %v", decrypted, err)
+	}
+
+	// records of other nodes are left alone
+	kp.SetPrevious(old)
+	foreign, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{kps[2].PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Rewrap(foreign); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient, got %v", err)
+	}
+	// legacy records do not name their node, so the key tells
+	foreignLegacy, err := kps[2].EncryptFile(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Rewrap(foreignLegacy); !errors.Is(err, keys.ErrNotARecipient) {
+		t.Errorf("expected ErrNotARecipient for a legacy record of another node, got %v", err)
+	}
+}
@@ -1,8 +1,10 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -14,13 +16,24 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
+
+	"github.com/cloudflare/circl/kem"
 )
 
 const keyFile = "rsa_private.pem"
 
+// KeyPair is a node's encryption key. PrivateKey and PublicKey are set for
+// RSA keys, X25519 or XWing for keys used with HPKE; see Type.
 type KeyPair struct {
 	PrivateKey *rsa.PrivateKey
 	PublicKey  *rsa.PublicKey
+	X25519     *ecdh.PrivateKey
+	XWing      kem.PrivateKey
+
+	// previous is the key this one replaced, kept to decrypt until records
+	// are re-wrapped; see SetPrevious
+	previous atomic.Pointer[KeyPair]
 }
 
 // GenerateKeyPair creates a new RSA key pair
@@ -169,7 +182,7 @@ func (kp *KeyPair) DecryptFile(data []byte) ([]byte, error) {
 
 // ExportPublicKeyPEM returns the public key in PEM format
 func (kp *KeyPair) ExportPublicKeyPEM() (string, error) {
-	pubASN1, err := x509.MarshalPKIXPublicKey(kp.PublicKey)
+	pubASN1, err := marshalPublicKey(kp.Public())
 	if err != nil {
 		return "", err
 	}
@@ -180,14 +193,23 @@ func (kp *KeyPair) ExportPublicKeyPEM() (string, error) {
 	return string(pem.EncodeToMemory(pemBlock)), nil
 }
 
-// ExportPrivateKeyPEM returns the private key in PEM format
-func (kp *KeyPair) ExportPrivateKeyPEM() string {
-	privASN1 := x509.MarshalPKCS1PrivateKey(kp.PrivateKey)
-	pemBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privASN1,
+// ExportPrivateKeyPEM returns the private key in PEM format, PKCS#1 for RSA
+// keys and PKCS#8 otherwise
+func (kp *KeyPair) ExportPrivateKeyPEM() (string, error) {
+	if kp.PrivateKey != nil {
+		privASN1 := x509.MarshalPKCS1PrivateKey(kp.PrivateKey)
+		pemBlock := &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: privASN1,
+		}
+		return string(pem.EncodeToMemory(pemBlock)), nil
 	}
-	return string(pem.EncodeToMemory(pemBlock))
+
+	privASN1, err := kp.MarshalPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privASN1})), nil
 }
 
 // ImportPublicKeyPEM imports a PEM-formatted public key
@@ -212,13 +234,28 @@ func ImportPublicKeyPEM(pemData string) (*rsa.PublicKey, error) {
 
 // ExportPublicKeyBase64 returns the public key as base64 DER
 func (kp *KeyPair) ExportPublicKeyBase64() (string, error) {
-	pubASN1, err := x509.MarshalPKIXPublicKey(kp.PublicKey)
+	pubASN1, err := marshalPublicKey(kp.Public())
 	if err != nil {
 		return "", err
 	}
 	return base64.StdEncoding.EncodeToString(pubASN1), nil
 }
 
+// Sign signs msg with RSA-PSS over its SHA-256 hash
+func (kp *KeyPair) Sign(msg []byte) ([]byte, error) {
+	if kp.PrivateKey == nil {
+		return nil, errors.New("signing needs an RSA key")
+	}
+	hash := sha256.Sum256(msg)
+	return rsa.SignPSS(rand.Reader, kp.PrivateKey, crypto.SHA256, hash[:], nil)
+}
+
+// VerifySignature checks a signature made by Sign
+func VerifySignature(pub *rsa.PublicKey, msg, sig []byte) error {
+	hash := sha256.Sum256(msg)
+	return rsa.VerifyPSS(pub, crypto.SHA256, hash[:], sig, nil)
+}
+
 func (kp *KeyPair) DecryptBase64Bytes(ciphertext []byte) ([]byte, error) {
 	return rsa.DecryptPKCS1v15(rand.Reader, kp.PrivateKey, ciphertext)
 }
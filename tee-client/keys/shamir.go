@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// MaxShares is the largest n supported, as share x coordinates are one byte
+const MaxShares = 255
+
+// SplitSecret splits secret into n shares, any k of which recover it. Each
+// share is its x coordinate followed by one y byte per secret byte.
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n {
+		return nil, fmt.Errorf("threshold must be between 2 and %d", n)
+	}
+	if n > MaxShares {
+		return nil, fmt.Errorf("at most %d shares are supported", MaxShares)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	// one random polynomial of degree k-1 per secret byte, with the secret
+	// byte as constant term
+	coefficients := make([]byte, k)
+	for j, s := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		coefficients[0] = s
+
+		for _, share := range shares {
+			share[j+1] = evaluate(coefficients, share[0])
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares recovers the secret from at least k shares produced by
+// SplitSecret. With fewer than k shares, or shares of different secrets, the
+// result is garbage; callers must authenticate it, e.g. by decrypting with it.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("share is too short")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, errors.New("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("invalid or duplicate share x coordinate %d", share[0])
+		}
+		seen[share[0]] = true
+		xs[i] = share[0]
+	}
+
+	secret := make([]byte, size-1)
+	ys := make([]byte, len(shares))
+	for j := range secret {
+		for i, share := range shares {
+			ys[i] = share[j+1]
+		}
+		secret[j] = interpolateAtZero(xs, ys)
+	}
+
+	return secret, nil
+}
+
+// evaluate computes the polynomial at x with Horner's rule
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+// interpolateAtZero returns the Lagrange interpolation of the points at x = 0
+func interpolateAtZero(xs, ys []byte) byte {
+	var result byte
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			// x_j / (x_j - x_i); subtraction is xor in GF(2^8)
+			basis = gfMul(basis, gfDiv(xs[j], xs[j]^xs[i]))
+		}
+		result ^= gfMul(ys[i], basis)
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, in constant time
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7) & 0x1b
+		a = a<<1 ^ carry
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns a^254 = a^-1; the inverse of 0 is taken to be 0
+func gfInv(a byte) byte {
+	result := byte(1)
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = gfMul(result, a)
+		}
+		a = gfMul(a, a)
+	}
+	return result
+}
+
+func gfDiv(a, b byte) byte {
+	return gfMul(a, gfInv(b))
+}
@@ -0,0 +1,60 @@
+package keys_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestShamirAnyThresholdSubsetRecovers(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	shares, err := keys.SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				got, err := keys.CombineShares([][]byte{shares[a], shares[b], shares[c]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("shares %d,%d,%d recovered %x", a, b, c, got)
+				}
+			}
+		}
+	}
+
+	got, err := keys.CombineShares(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Error("two shares of a 3-of-5 split recovered the secret")
+	}
+}
+
+func TestShamirRejectsInvalidInput(t *testing.T) {
+	secret := []byte("secret")
+
+	for _, tc := range []struct{ n, k int }{{3, 1}, {3, 4}, {256, 2}} {
+		if _, err := keys.SplitSecret(secret, tc.n, tc.k); err == nil {
+			t.Errorf("expected %d-of-%d split to be rejected", tc.k, tc.n)
+		}
+	}
+
+	shares, err := keys.SplitSecret(secret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Error("expected duplicate shares to be rejected")
+	}
+	if _, err := keys.CombineShares([][]byte{shares[0], shares[1][:3]}); err == nil {
+		t.Error("expected shares of different lengths to be rejected")
+	}
+}
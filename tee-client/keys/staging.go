@@ -0,0 +1,300 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Staged files are what a node keeps on its local disk before a record is
+// sealed, e.g. partial uploads. The disk lies outside the trust boundary of a
+// confidential VM, so they are encrypted under a key derived from the TEE
+// sealing key and the file id. A staged file is a sequence of records
+//
+//	length u32 || nonce || AEAD(record, index u64 || last flag u8)
+//
+// of at most StagingRecordSize bytes of plaintext each. Files can be appended
+// to across requests; the last record, possibly empty, marks the end, so a
+// file cannot be cut off unnoticed.
+const (
+	// StagingRecordSize is the largest plaintext of a staged file record
+	StagingRecordSize = 64 << 10
+
+	stagingNonceSize = 12
+	maxStagingFrame  = stagingNonceSize + StagingRecordSize + 16
+)
+
+// stagingKeyInfo derives the staging key from the TEE sealing key
+var stagingKeyInfo = []byte("healthlock upload staging v1")
+
+// ErrTruncatedStaging is returned when a staged file ends before its last
+// record
+var ErrTruncatedStaging = errors.New("staged file ends before its last record")
+
+// StagingKey encrypts the files a node stages on its local disk
+type StagingKey struct {
+	key []byte
+}
+
+// NewStagingKey derives the staging key from sealingKey, which normally
+// comes from tee.Sealer, so staged files stay readable across restarts of
+// the same TEE image on the same machine only
+func NewStagingKey(sealingKey []byte) (*StagingKey, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sealingKey, nil, stagingKeyInfo), key); err != nil {
+		return nil, err
+	}
+	return &StagingKey{key: key}, nil
+}
+
+// fileCipher returns the cipher of the staged file fileID
+func (k *StagingKey) fileCipher(fileID string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k.key, nil, []byte(fileID)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts a small staged file, such as the state of an upload, whole
+func (k *StagingKey) Seal(fileID string, plaintext []byte) ([]byte, error) {
+	aead, err := k.fileCipher(fileID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a file sealed with Seal
+func (k *StagingKey) Open(fileID string, sealed []byte) ([]byte, error) {
+	aead, err := k.fileCipher(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed staged file too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// NewWriter returns a writer encrypting into records of the staged file
+// fileID, the first of which is record number next; a file written across
+// requests continues with the Records of the previous writer
+func (k *StagingKey) NewWriter(w io.Writer, fileID string, next uint64) (*StagingWriter, error) {
+	aead, err := k.fileCipher(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &StagingWriter{
+		aead:    aead,
+		w:       w,
+		records: next,
+		buf:     make([]byte, 0, StagingRecordSize),
+	}, nil
+}
+
+// NewReader returns the plaintext of the staged file fileID read from r,
+// which must end with its last record. Like OpenStream, it releases each
+// record only once it is authenticated.
+func (k *StagingKey) NewReader(r io.Reader, fileID string) (io.Reader, error) {
+	aead, err := k.fileCipher(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &stagingReader{aead: aead, in: bufio.NewReader(r)}, nil
+}
+
+// StagingWriter encrypts what is written to it into the records of a staged
+// file. Writes are buffered up to a full record; Flush writes what is
+// buffered as a shorter record and Close writes the last record. Like
+// bufio.Writer, after a failed write it returns the error on every call.
+type StagingWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	buf     []byte
+	records uint64
+	written int64
+	size    int64
+	closed  bool
+	err     error
+}
+
+func (s *StagingWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, errors.New("write to closed staged file")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == StagingRecordSize {
+			if err := s.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):StagingRecordSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Flush writes the buffered plaintext, if any, as a record
+func (s *StagingWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed || len(s.buf) == 0 {
+		return nil
+	}
+	return s.seal(false)
+}
+
+// Close writes the buffered plaintext as the last record of the file
+func (s *StagingWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return nil
+	}
+	if err := s.seal(true); err != nil {
+		return err
+	}
+	s.closed = true
+	return nil
+}
+
+// Records returns the number of records written, counting those of earlier
+// writers of the file
+func (s *StagingWriter) Records() uint64 {
+	return s.records
+}
+
+// Written returns the plaintext bytes in the records this writer wrote
+func (s *StagingWriter) Written() int64 {
+	return s.written
+}
+
+// Size returns the bytes this writer wrote to the file. A failed write may
+// leave a partial record after them, which the next writer must truncate.
+func (s *StagingWriter) Size() int64 {
+	return s.size
+}
+
+func (s *StagingWriter) seal(last bool) error {
+	nonce := make([]byte, stagingNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4, 4+stagingNonceSize+len(s.buf)+s.aead.Overhead())
+	frame = append(frame, nonce...)
+	frame = s.aead.Seal(frame, nonce, s.buf, stagingAAD(s.records, last))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	if _, err := s.w.Write(frame); err != nil {
+		s.err = err
+		return err
+	}
+
+	s.records++
+	s.written += int64(len(s.buf))
+	s.size += int64(len(frame))
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func stagingAAD(index uint64, last bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, index)
+	if last {
+		aad[8] = 1
+	}
+	return aad
+}
+
+// stagingReader decrypts the records of a staged file as they are read
+type stagingReader struct {
+	aead  cipher.AEAD
+	in    *bufio.Reader
+	index uint64
+	plain []byte
+	done  bool
+	err   error
+}
+
+func (s *stagingReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *stagingReader) next() error {
+	var size uint32
+	if err := binary.Read(s.in, binary.BigEndian, &size); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedStaging
+		}
+		return err
+	}
+	if size < stagingNonceSize || size > maxStagingFrame {
+		return fmt.Errorf("invalid staged record size %d", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(s.in, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedStaging
+		}
+		return err
+	}
+	nonce, sealed := frame[:stagingNonceSize], frame[stagingNonceSize:]
+
+	// the flag is authenticated, so try the common case first
+	last := false
+	plain, err := s.aead.Open(sealed[:0:0], nonce, sealed, stagingAAD(s.index, false))
+	if err != nil {
+		last = true
+		if plain, err = s.aead.Open(sealed[:0:0], nonce, sealed, stagingAAD(s.index, true)); err != nil {
+			return fmt.Errorf("staged record %d failed authentication: %w", s.index, err)
+		}
+	}
+
+	if last {
+		if _, err := s.in.Peek(1); err == nil {
+			return errors.New("staged file continues after its last record")
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+		s.done = true
+	}
+	s.index++
+	s.plain = plain
+	return nil
+}
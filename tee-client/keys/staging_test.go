@@ -0,0 +1,115 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func newStagingKey(t *testing.T, seed byte) *keys.StagingKey {
+	key, err := keys.NewStagingKey(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func readStaged(key *keys.StagingKey, fileID string, data []byte) ([]byte, error) {
+	r, err := key.NewReader(bytes.NewReader(data), fileID)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStagedFileAppendsAcrossWriters(t *testing.T) {
+	key := newStagingKey(t, 1)
+	plaintext := make([]byte, 2*keys.StagingRecordSize+100)
+	rand.Read(plaintext)
+
+	// three requests of an upload, the last one closing the file
+	var file bytes.Buffer
+	next := uint64(0)
+	for i, chunk := range [][]byte{plaintext[:1000], plaintext[1000 : keys.StagingRecordSize+5000], plaintext[keys.StagingRecordSize+5000:]} {
+		w, err := key.NewWriter(&file, "upload-1", next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			err = w.Close()
+		} else {
+			err = w.Flush()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Written() != int64(len(chunk)) {
+			t.Fatalf("request %d: wrote %d of %d bytes", i, w.Written(), len(chunk))
+		}
+		next = w.Records()
+	}
+	if bytes.Contains(file.Bytes(), plaintext[:64]) {
+		t.Fatal("staged file holds plaintext")
+	}
+
+	decrypted, err := readStaged(key, "upload-1", file.Bytes())
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("read back %d bytes: %v", len(decrypted), err)
+	}
+
+	// the key is bound to the file and to the sealing key
+	if _, err := readStaged(key, "upload-2", file.Bytes()); err == nil {
+		t.Error("file read under another id")
+	}
+	if _, err := readStaged(newStagingKey(t, 2), "upload-1", file.Bytes()); err == nil {
+		t.Error("file read under another sealing key")
+	}
+}
+
+func TestStagedFileDetectsTruncation(t *testing.T) {
+	key := newStagingKey(t, 1)
+	var file bytes.Buffer
+	w, err := key.NewWriter(&file, "upload", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, keys.StagingRecordSize+10))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	unfinished := append([]byte{}, file.Bytes()...)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readStaged(key, "upload", unfinished); !errors.Is(err, keys.ErrTruncatedStaging) {
+		t.Errorf("expected ErrTruncatedStaging for a file without last record, got %v", err)
+	}
+	if _, err := readStaged(key, "upload", file.Bytes()[:file.Len()-3]); !errors.Is(err, keys.ErrTruncatedStaging) {
+		t.Errorf("expected ErrTruncatedStaging for a cut record, got %v", err)
+	}
+	if _, err := readStaged(key, "upload", append(file.Bytes(), unfinished...)); err == nil {
+		t.Error("accepted records after the last one")
+	}
+}
+
+func TestStagingSealBindsFileID(t *testing.T) {
+	key := newStagingKey(t, 1)
+	sealed, err := key.Seal("upload.info", []byte(`{"offset":10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := key.Open("upload.info", sealed); err != nil || string(opened) != `{"offset":10}` {
+		t.Fatalf("got %q, %v", opened, err)
+	}
+	if _, err := key.Open("other.info", sealed); err == nil {
+		t.Error("opened under another file id")
+	}
+}
@@ -0,0 +1,457 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Streamed envelopes keep their ciphertext out of the JSON, so records of
+// hundreds of MB are sealed and opened in constant memory. The blob is
+//
+//	"HLSTREAM" || header length u32 || envelope JSON || segments
+//
+// Following the STREAM construction of Hoang, Reyhanitabar, Rogaway and
+// Vizár, segment i seals SegmentSize bytes of plaintext, the last one fewer
+// or none, under the nonce prefix || i u32 || last flag u8 and the record
+// binding, so segments cannot be reordered, dropped or cut off unnoticed.
+const (
+	// StreamSegmentSize is the plaintext size of the segments SealStream
+	// writes
+	StreamSegmentSize = 64 << 10
+
+	streamPrefixSize    = 7
+	maxStreamHeaderSize = 1 << 20
+	maxSegmentSize      = 16 << 20
+)
+
+var streamMagic = []byte("HLSTREAM")
+
+// ErrTruncatedStream is returned when a stream ends before its last segment
+var ErrTruncatedStream = errors.New("stream ends before its last segment")
+
+// SealStream is SealWithOptions for plaintext read from r, writing a streamed
+// envelope to w one segment at a time
+func SealStream(w io.Writer, plaintext io.Reader, binding RecordBinding, recipients []crypto.PublicKey, opts SealOptions) error {
+	env, dataKey, err := newEnvelope(binding, recipients, opts)
+	if err != nil {
+		return err
+	}
+	return env.writeStream(w, dataKey, plaintext)
+}
+
+// ReadEnvelope reads the envelope at the start of r. For streamed envelopes
+// it reads the header only and returns the reader of the segments, to pass
+// to OpenStream; other envelopes are read whole and come without one.
+func ReadEnvelope(r io.Reader) (*Envelope, io.Reader, error) {
+	in := bufio.NewReader(r)
+	if magic, err := in.Peek(len(streamMagic)); err == nil && bytes.Equal(magic, streamMagic) {
+		if _, err := in.Discard(len(streamMagic)); err != nil {
+			return nil, nil, err
+		}
+		env, err := readStreamHeader(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return env, in, nil
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env, nil, nil
+}
+
+// ReadEnvelopeAt is ReadEnvelope for the size bytes of r, returning the
+// segments of streamed envelopes as a section of r to pass to OpenStreamAt
+func ReadEnvelopeAt(r io.ReaderAt, size int64) (*Envelope, *io.SectionReader, error) {
+	prefix := make([]byte, len(streamMagic)+4)
+	if n, _ := r.ReadAt(prefix, 0); n < len(prefix) || !bytes.Equal(prefix[:len(streamMagic)], streamMagic) {
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, nil, err
+		}
+		env, err := ParseEnvelope(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return env, nil, nil
+	}
+
+	env, err := readStreamHeader(io.NewSectionReader(r, int64(len(streamMagic)), size-int64(len(streamMagic))))
+	if err != nil {
+		return nil, nil, err
+	}
+	start := int64(len(prefix)) + int64(binary.BigEndian.Uint32(prefix[len(streamMagic):]))
+	return env, io.NewSectionReader(r, start, size-start), nil
+}
+
+// OpenStream returns the plaintext of env, given its data key from DataKey,
+// DualControlKey, ThresholdKey or the key management service. Streamed
+// envelopes are decrypted from content as it is read, releasing each segment
+// only once it is authenticated, so a read error means the record was
+// tampered with or cut off. Other envelopes are decrypted in memory. Like
+// Open, it does not check the binding.
+func OpenStream(env *Envelope, dataKey []byte, content io.Reader) (io.Reader, error) {
+	if env.SegmentSize == 0 {
+		plaintext, err := openContent(env, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(plaintext), nil
+	}
+	if content == nil {
+		return nil, errors.New("streamed envelope without content")
+	}
+
+	aead, err := env.contentCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	if len(prefix) != streamPrefixSize || aead.NonceSize() != streamPrefixSize+5 {
+		return nil, fmt.Errorf("invalid nonce prefix length %d", len(prefix))
+	}
+
+	return &streamReader{
+		aead:   aead,
+		in:     bufio.NewReader(content),
+		prefix: prefix,
+		aad:    env.Binding.AssociatedData(),
+		buf:    make([]byte, env.SegmentSize+aead.Overhead()),
+	}, nil
+}
+
+// OpenStreamAt is OpenStream for content read at any offset, so the
+// plaintext can be read from any position without decrypting what comes
+// before it. The first segment is decrypted right away, so a wrong key or a
+// tampered start is reported here rather than on the first read. Reading
+// fails at a segment that does not authenticate, and at the end of a stream
+// cut off between segments.
+func OpenStreamAt(env *Envelope, dataKey []byte, content *io.SectionReader) (io.ReadSeeker, error) {
+	if env.SegmentSize == 0 {
+		plaintext, err := openContent(env, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(plaintext), nil
+	}
+	if content == nil {
+		return nil, errors.New("streamed envelope without content")
+	}
+
+	aead, err := env.contentCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	if len(prefix) != streamPrefixSize || aead.NonceSize() != streamPrefixSize+5 {
+		return nil, fmt.Errorf("invalid nonce prefix length %d", len(prefix))
+	}
+
+	// every segment but the last is full, and even the last one holds a tag
+	sealedSize := int64(env.SegmentSize + aead.Overhead())
+	count := (content.Size() + sealedSize - 1) / sealedSize
+	if count == 0 || content.Size()-(count-1)*sealedSize < int64(aead.Overhead()) {
+		return nil, ErrTruncatedStream
+	}
+	if count-1 > math.MaxUint32 {
+		return nil, errors.New("stream has too many segments")
+	}
+
+	s := &streamSeeker{
+		aead:    aead,
+		in:      content,
+		prefix:  prefix,
+		aad:     env.Binding.AssociatedData(),
+		segment: int64(env.SegmentSize),
+		count:   count,
+		size:    content.Size() - count*int64(aead.Overhead()),
+		buf:     make([]byte, sealedSize),
+		loaded:  -1,
+	}
+	if err := s.load(0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeStream seals plaintext into segments under dataKey and writes the
+// envelope and its segments to w
+func (e *Envelope) writeStream(w io.Writer, dataKey []byte, plaintext io.Reader) error {
+	aead, err := e.contentCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	if aead.NonceSize() != streamPrefixSize+5 {
+		return fmt.Errorf("aead %q cannot be streamed", e.AEAD)
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	e.SegmentSize = StreamSegmentSize
+	e.Nonce = base64.StdEncoding.EncodeToString(prefix)
+
+	header, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := writeStreamHeader(w, header); err != nil {
+		return err
+	}
+
+	aad := e.Binding.AssociatedData()
+	in := bufio.NewReader(plaintext)
+	segment := make([]byte, e.SegmentSize)
+	sealed := make([]byte, 0, e.SegmentSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(in, segment)
+		last := false
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return err
+		default:
+			// a full segment is the last one if nothing follows
+			if _, err := in.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if !last && counter == math.MaxUint32 {
+			return errors.New("plaintext too long to stream")
+		}
+
+		sealed = aead.Seal(sealed[:0], streamNonce(prefix, counter, last), segment[:n], aad)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func writeStreamHeader(w io.Writer, header []byte) error {
+	prefix := make([]byte, len(streamMagic)+4)
+	copy(prefix, streamMagic)
+	binary.BigEndian.PutUint32(prefix[len(streamMagic):], uint32(len(header)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// readStreamHeader reads the envelope of a stream after its magic
+func readStreamHeader(r io.Reader) (*Envelope, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, fmt.Errorf("invalid stream header: %w", err)
+	}
+	if size > maxStreamHeaderSize {
+		return nil, fmt.Errorf("stream header of %d bytes is too large", size)
+	}
+	header := make([]byte, size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("invalid stream header: %w", err)
+	}
+
+	env, err := parseEnvelopeJSON(header)
+	if err != nil {
+		return nil, err
+	}
+	if env.Version < 3 || env.SegmentSize <= 0 || env.SegmentSize > maxSegmentSize {
+		return nil, errors.New("stream header needs version 3 and a valid segment_size")
+	}
+	return env, nil
+}
+
+// encode serializes e as it was parsed: as JSON, or as a stream with its
+// segments
+func (e *Envelope) encode() ([]byte, error) {
+	header, err := json.Marshal(e)
+	if err != nil || e.SegmentSize == 0 {
+		return header, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeStreamHeader(&buf, header); err != nil {
+		return nil, err
+	}
+	buf.Write(e.segments)
+	return buf.Bytes(), nil
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, streamPrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	if last {
+		nonce[streamPrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// streamReader decrypts segments as they are read
+type streamReader struct {
+	aead    cipher.AEAD
+	in      *bufio.Reader
+	prefix  []byte
+	aad     []byte
+	counter uint32
+	buf     []byte // one sealed segment
+	plain   []byte // decrypted and not yet read
+	done    bool
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.in, s.buf)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		// even an empty plaintext ends with a sealed last segment
+		return ErrTruncatedStream
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := s.in.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := s.aead.Open(s.buf[:0], streamNonce(s.prefix, s.counter, last), s.buf[:n], s.aad)
+	if err != nil {
+		return fmt.Errorf("segment %d failed authentication: %w", s.counter, err)
+	}
+
+	if last {
+		s.done = true
+	} else if s.counter++; s.counter == 0 {
+		return errors.New("stream has too many segments")
+	}
+	s.plain = plain
+	return nil
+}
+
+// streamSeeker decrypts the segments a read falls in, keeping the last one
+type streamSeeker struct {
+	aead    cipher.AEAD
+	in      io.ReaderAt
+	prefix  []byte
+	aad     []byte
+	segment int64 // plaintext size of full segments
+	count   int64
+	size    int64 // plaintext size
+	pos     int64
+	buf     []byte // one sealed segment
+	plain   []byte // plaintext of the loaded segment
+	loaded  int64
+}
+
+// Size returns the size of the plaintext
+func (s *streamSeeker) Size() int64 {
+	return s.size
+}
+
+func (s *streamSeeker) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	index := s.pos / s.segment
+	if err := s.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, s.plain[s.pos-index*s.segment:])
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *streamSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *streamSeeker) load(index int64) error {
+	if index == s.loaded {
+		return nil
+	}
+	s.loaded = -1
+
+	offset := index * int64(len(s.buf))
+	sealed := s.buf
+	last := index == s.count-1
+	if last {
+		sealed = s.buf[:s.size-index*s.segment+int64(s.aead.Overhead())]
+	}
+	if n, err := s.in.ReadAt(sealed, offset); n < len(sealed) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := s.aead.Open(s.buf[:0], streamNonce(s.prefix, uint32(index), last), sealed, s.aad)
+	if err != nil {
+		return fmt.Errorf("segment %d failed authentication: %w", index, err)
+	}
+	s.plain = plain
+	s.loaded = index
+	return nil
+}
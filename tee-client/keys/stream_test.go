@@ -0,0 +1,274 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func sealStream(t *testing.T, plaintext []byte, recipients []crypto.PublicKey) []byte {
+	var buf bytes.Buffer
+	if err := keys.SealStream(&buf, bytes.NewReader(plaintext), testBinding, recipients, keys.SealOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openStream(kp *keys.KeyPair, data []byte) ([]byte, error) {
+	env, content, err := keys.ReadEnvelope(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := kp.DataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := keys.OpenStream(env, dataKey, content)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(plaintext)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := keys.StreamSegmentSize
+	for _, size := range []int{0, 1, seg - 1, seg, seg + 1, 3*seg + 17} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		sealed := sealStream(t, plaintext, []crypto.PublicKey{kp.Public()})
+
+		decrypted, err := openStream(kp, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("%d bytes: plaintext mismatch", size)
+		}
+
+		// the in-memory API reads streams too
+		decrypted, err = kp.Open(sealed)
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("%d bytes: Open failed: %v", size, err)
+		}
+	}
+}
+
+func TestStreamDetectsTampering(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := keys.StreamSegmentSize
+	plaintext := make([]byte, 3*seg)
+	rand.Read(plaintext)
+	sealed := sealStream(t, plaintext, []crypto.PublicKey{kp.Public()})
+
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.SegmentSize != seg {
+		t.Fatalf("segment size %d", env.SegmentSize)
+	}
+	sealedSeg := seg + 16
+	headerSize := len(sealed) - 3*sealedSeg
+	header := sealed[:headerSize]
+
+	segment := func(i int) []byte {
+		return sealed[headerSize+i*sealedSeg : headerSize+(i+1)*sealedSeg]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, parts...), nil)
+	}
+
+	cases := map[string][]byte{
+		"last segment dropped": join(segment(0), segment(1)),
+		"segments swapped":     join(segment(1), segment(0), segment(2)),
+		"cut mid segment":      sealed[:len(sealed)-100],
+		"only the header":      header,
+		"trailing data":        append(append([]byte{}, sealed...), 0),
+		"flipped bit":          join(segment(0), append([]byte{segment(1)[0] ^ 1}, segment(1)[1:]...), segment(2)),
+	}
+	for name, data := range cases {
+		decrypted, err := openStream(kp, data)
+		if err == nil {
+			t.Errorf("%s: opened %d bytes", name, len(decrypted))
+		}
+	}
+
+	if _, err := openStream(kp, header); !errors.Is(err, keys.ErrTruncatedStream) {
+		t.Errorf("expected ErrTruncatedStream, got %v", err)
+	}
+}
+
+func TestStreamReleasesOnlyAuthenticatedSegments(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := keys.StreamSegmentSize
+	plaintext := make([]byte, 2*seg+5)
+	rand.Read(plaintext)
+	sealed := sealStream(t, plaintext, []crypto.PublicKey{kp.Public()})
+	sealed[len(sealed)-1] ^= 1
+
+	env, content, err := keys.ReadEnvelope(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := kp.DataKey(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := keys.OpenStream(env, dataKey, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(reader)
+	if err == nil {
+		t.Fatal("tampered last segment was accepted")
+	}
+	if !bytes.Equal(got, plaintext[:2*seg]) {
+		t.Errorf("released %d bytes, want the %d of the intact segments", len(got), 2*seg)
+	}
+}
+
+func TestReadEnvelopeReadsInlineEnvelopes(t *testing.T) {
+	kp := generateKeyPairs(t, 1)[0]
+	plaintext := []byte("lab results")
+	sealed, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{kp.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := openStream(kp, sealed)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("got %q, %v", decrypted, err)
+	}
+}
+
+func TestRewrapKeepsStreamSegments(t *testing.T) {
+	kps := generateKeyPairs(t, 2)
+	old, kp := kps[0], kps[1]
+	plaintext := make([]byte, keys.StreamSegmentSize+3)
+	rand.Read(plaintext)
+	sealed := sealStream(t, plaintext, []crypto.PublicKey{old.PublicKey})
+
+	kp.SetPrevious(old)
+	rewrapped, err := kp.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp.SetPrevious(nil)
+
+	decrypted, err := openStream(kp, rewrapped)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("rewrapped stream: %v", err)
+	}
+}
+
+func openStreamAt(kp *keys.KeyPair, data []byte) (io.ReadSeeker, error) {
+	env, content, err := keys.ReadEnvelopeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := kp.DataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	return keys.OpenStreamAt(env, dataKey, content)
+}
+
+func TestStreamReadsAtAnyOffset(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := keys.StreamSegmentSize
+	plaintext := make([]byte, 3*seg+17)
+	rand.Read(plaintext)
+	sealed := sealStream(t, plaintext, []crypto.PublicKey{kp.Public()})
+
+	reader, err := openStreamAt(kp, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := reader.Seek(0, io.SeekEnd); err != nil || size != int64(len(plaintext)) {
+		t.Fatalf("size %d, %v", size, err)
+	}
+	for _, offset := range []int{2*seg + 3, 0, seg - 1, 3 * seg, len(plaintext) - 1} {
+		if _, err := reader.Seek(int64(offset), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 100)
+		n, err := io.ReadFull(reader, got)
+		if want := plaintext[offset:min(offset+100, len(plaintext))]; !bytes.Equal(got[:n], want) {
+			t.Fatalf("at %d: read %d bytes, %v", offset, n, err)
+		}
+	}
+
+	// inline envelopes read the same way
+	inline, err := keys.Seal([]byte("lab results"), testBinding, []crypto.PublicKey{kp.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err = openStreamAt(kp, inline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(reader); err != nil || string(got) != "lab results" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestStreamAtDetectsTampering(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := keys.StreamSegmentSize
+	plaintext := make([]byte, 3*seg)
+	rand.Read(plaintext)
+	sealed := sealStream(t, plaintext, []crypto.PublicKey{kp.Public()})
+	sealedSeg := seg + 16
+	header := sealed[:len(sealed)-3*sealedSeg]
+
+	firstFlipped := append([]byte{}, sealed...)
+	firstFlipped[len(header)] ^= 1
+	if _, err := openStreamAt(kp, firstFlipped); err == nil {
+		t.Error("opened a stream with a tampered first segment")
+	}
+	if _, err := openStreamAt(kp, header); !errors.Is(err, keys.ErrTruncatedStream) {
+		t.Errorf("expected ErrTruncatedStream, got %v", err)
+	}
+
+	for name, data := range map[string][]byte{
+		"last segment dropped": sealed[:len(sealed)-sealedSeg],
+		"cut mid segment":      sealed[:len(sealed)-100],
+		"trailing data":        append(append([]byte{}, sealed...), 0),
+	} {
+		reader, err := openStreamAt(kp, data)
+		if err != nil {
+			continue
+		}
+		// the end is read first, as viewers seeking in a file do
+		if _, err := reader.Seek(-1, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Errorf("%s: read the end of a damaged stream", name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+
+	"github.com/cloudflare/circl/hpke"
+)
+
+const x25519KeyFile = "x25519_private.pem"
+
+// x25519HPKE wraps keys with DHKEM(X25519, HKDF-SHA256)
+var x25519HPKE = hpkeKEM{
+	name: KEMX25519HPKE,
+	kem:  hpke.KEM_X25519_HKDF_SHA256,
+	publicKey: func(pub crypto.PublicKey) ([]byte, bool) {
+		key, ok := pub.(*ecdh.PublicKey)
+		if !ok || key.Curve() != ecdh.X25519() {
+			return nil, false
+		}
+		return key.Bytes(), true
+	},
+	privateKey: func(kp *KeyPair) ([]byte, bool) {
+		if kp.X25519 == nil {
+			return nil, false
+		}
+		return kp.X25519.Bytes(), true
+	},
+}
+
+// GenerateX25519KeyPair creates a new X25519 key pair
+func GenerateX25519KeyPair(debug bool) (*KeyPair, error) {
+	return generateDebugKeyPair(x25519KeyFile, "X25519", debug, func() (*KeyPair, error) {
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyPair{X25519: priv}, nil
+	})
+}
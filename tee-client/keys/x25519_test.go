@@ -0,0 +1,171 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestX25519EnvelopeRoundTrip(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("mri scan")
+
+	sealed, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{kp.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.KEM != keys.KEMX25519HPKE {
+		t.Errorf("expected kem %s, got %s", keys.KEMX25519HPKE, env.KEM)
+	}
+
+	decrypted, err := kp.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestEnvelopeMixesKeyTypes(t *testing.T) {
+	rsaKP := generateKeyPairs(t, 1)[0]
+	x25519KP, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("mri scan")
+
+	sealed, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{rsaKP.Public(), x25519KP.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env keys.Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.KEM != keys.KEMRSAOAEPSHA256 || env.Recipients[0].KEM != "" || env.Recipients[1].KEM != keys.KEMX25519HPKE {
+		t.Errorf("unexpected kems %s, %q, %q", env.KEM, env.Recipients[0].KEM, env.Recipients[1].KEM)
+	}
+
+	for _, kp := range []*keys.KeyPair{rsaKP, x25519KP} {
+		decrypted, err := kp.Open(sealed)
+		if err != nil {
+			t.Fatalf("%s recipient failed to open envelope: %v", kp.Type(), err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%s recipient got %q", kp.Type(), decrypted)
+		}
+	}
+}
+
+func TestX25519RegisteredKey(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := kp.ExportPublicKeyBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TEEState.pubkey holds at most 512 bytes; an RSA-2048 key takes 392
+	if len(registered) > 64 {
+		t.Errorf("registered key is %d bytes", len(registered))
+	}
+
+	pub, err := keys.ParseRegisteredPublicKey([]byte(registered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := keys.KeyID(kp.Public())
+	got, _ := keys.KeyID(pub)
+	if got != want {
+		t.Error("key id of the registered key does not match")
+	}
+}
+
+func TestImportKeyPairPEM(t *testing.T) {
+	x25519KP, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xwingKP, err := keys.GenerateXWingKeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kp := range []*keys.KeyPair{generateKeyPairs(t, 1)[0], x25519KP, xwingKP} {
+		pemData, err := kp.ExportPrivateKeyPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := keys.ImportKeyPairPEM(pemData)
+		if err != nil {
+			t.Fatalf("failed to import %s key: %v", kp.Type(), err)
+		}
+
+		want, _ := keys.KeyID(kp.Public())
+		got, _ := keys.KeyID(imported.Public())
+		if imported.Type() != kp.Type() || got != want {
+			t.Errorf("imported %s key does not match", kp.Type())
+		}
+	}
+}
+
+func benchmarkOpen(b *testing.B, kp *keys.KeyPair) {
+	sealed, err := keys.Seal([]byte("record"), testBinding, []crypto.PublicKey{kp.Public()})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := kp.Open(sealed); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpenRSAOAEP(b *testing.B) {
+	kp, err := keys.GenerateKeyPair(2048, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkOpen(b, kp)
+}
+
+func BenchmarkOpenX25519HPKE(b *testing.B) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkOpen(b, kp)
+}
+
+func BenchmarkGenerateRSA2048(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := keys.GenerateKeyPair(2048, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateX25519(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := keys.GenerateX25519KeyPair(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package keys
+
+import (
+	"crypto"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/cloudflare/circl/hpke"
+	"github.com/cloudflare/circl/kem"
+)
+
+const xwingKeyFile = "xwing_private.pem"
+
+// oidXWing identifies X-Wing keys, per draft-connolly-cfrg-xwing-kem
+var oidXWing = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 62253, 25722}
+
+// XWingPublicKey is the public key of an X-Wing key pair, which combines
+// X25519 with ML-KEM-768 so that a wrapped key stays confidential unless both
+// are broken. At 1216 bytes it does not fit TEEState.pubkey, see
+// KeyPair.RegisteredKey.
+type XWingPublicKey struct {
+	key kem.PublicKey
+}
+
+// ParseXWingPublicKey reads a raw X-Wing public key
+func ParseXWingPublicKey(raw []byte) (*XWingPublicKey, error) {
+	key, err := hpke.KEM_XWING.Scheme().UnmarshalBinaryPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Wing public key: %w", err)
+	}
+	return &XWingPublicKey{key: key}, nil
+}
+
+// xwingHPKE wraps keys with the X-Wing KEM
+var xwingHPKE = hpkeKEM{
+	name: KEMXWingHPKE,
+	kem:  hpke.KEM_XWING,
+	publicKey: func(pub crypto.PublicKey) ([]byte, bool) {
+		key, ok := pub.(*XWingPublicKey)
+		if !ok {
+			return nil, false
+		}
+		raw, err := key.key.MarshalBinary()
+		return raw, err == nil
+	},
+	privateKey: func(kp *KeyPair) ([]byte, bool) {
+		if kp.XWing == nil {
+			return nil, false
+		}
+		raw, err := kp.XWing.MarshalBinary()
+		return raw, err == nil
+	},
+}
+
+// GenerateXWingKeyPair creates a new X-Wing key pair
+func GenerateXWingKeyPair(debug bool) (*KeyPair, error) {
+	return generateDebugKeyPair(xwingKeyFile, "X-Wing", debug, func() (*KeyPair, error) {
+		_, priv, err := hpke.KEM_XWING.Scheme().GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		return &KeyPair{XWing: priv}, nil
+	})
+}
+
+// xwingKeyPair rebuilds a key pair from its 32 byte seed
+func xwingKeyPair(seed []byte) (*KeyPair, error) {
+	priv, err := hpke.KEM_XWING.Scheme().UnmarshalBinaryPrivateKey(seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Wing private key: %w", err)
+	}
+	return &KeyPair{XWing: priv}, nil
+}
@@ -0,0 +1,95 @@
+package keys_test
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+func TestXWingEnvelopeRoundTrip(t *testing.T) {
+	kp, err := keys.GenerateXWingKeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKP := generateKeyPairs(t, 1)[0]
+	plaintext := []byte("genome sequence")
+
+	sealed, err := keys.Seal(plaintext, testBinding, []crypto.PublicKey{kp.Public(), rsaKP.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := keys.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.KEM != keys.KEMXWingHPKE {
+		t.Errorf("expected kem %s, got %s", keys.KEMXWingHPKE, env.KEM)
+	}
+
+	for _, kp := range []*keys.KeyPair{kp, rsaKP} {
+		decrypted, err := kp.Open(sealed)
+		if err != nil {
+			t.Fatalf("%s recipient failed to open envelope: %v", kp.Type(), err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%s recipient got %q", kp.Type(), decrypted)
+		}
+	}
+}
+
+func TestXWingKeyIsRegisteredByCommitment(t *testing.T) {
+	kp, err := keys.GenerateXWingKeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := kp.RegisteredKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) > 512 {
+		t.Errorf("registered key is %d bytes, more than TEEState.pubkey holds", len(registered))
+	}
+	if _, err := keys.ParseRegisteredPublicKey(registered); !errors.Is(err, keys.ErrKeyNotOnChain) {
+		t.Errorf("expected ErrKeyNotOnChain, got %v", err)
+	}
+
+	published, err := kp.ExportPublicKeyBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := keys.ParsePublishedPublicKey(registered, published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := keys.KeyID(kp.Public())
+	got, _ := keys.KeyID(pub)
+	if got != want {
+		t.Error("key id of the published key does not match")
+	}
+
+	// a bundle carrying another key does not match the commitment
+	other, err := keys.GenerateXWingKeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	substituted, err := other.ExportPublicKeyBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.ParsePublishedPublicKey(registered, substituted); err == nil {
+		t.Error("expected a substituted key to be rejected")
+	}
+}
+
+func BenchmarkOpenXWingHPKE(b *testing.B) {
+	kp, err := keys.GenerateXWingKeyPair(false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkOpen(b, kp)
+}
@@ -0,0 +1,591 @@
+// Package kms is the key management service of the TEE nodes. It keeps
+// per-record data keys in sealed local storage, so records sealed with
+// keys.SealKMS carry only a key id and destroying the key makes every pinned
+// copy unreadable. Keys, and the owners' orders to destroy them, are
+// replicated to peer nodes over RA-TLS, wrapped to each peer's registered key
+// and signed with the sender's wallet.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+// Paths where nodes serve the handlers of Node
+const (
+	ReplicatePath = "/v1/kms/replicate"
+	SyncPath      = "/v1/kms/sync"
+	DestroyPath   = "/v1/kms/destroy"
+)
+
+const (
+	// maxBundleSize bounds a replication bundle; an entry takes about 1KB
+	maxBundleSize = 64 << 20
+	// maxRequestSize bounds destroy and sync requests, which hold a key id,
+	// an address and a signature
+	maxRequestSize = 4 << 10
+
+	// maxPendingDeletions bounds the destroy requests kept for keys this node
+	// does not hold, which anyone with a wallet can send
+	maxPendingDeletions = 1024
+	// pendingDeletionTTL is how long such a request waits for its key to
+	// arrive from a peer
+	pendingDeletionTTL = time.Hour
+)
+
+var (
+	// ErrNotOwner is returned when a destroy request is not signed by the
+	// owner of the record the key belongs to
+	ErrNotOwner = errors.New("key belongs to another owner")
+	// ErrNotReplicated is returned by Generate when no peer took the new key
+	ErrNotReplicated = errors.New("kms key was not replicated to any peer")
+	// ErrTooManyDeletions is returned when too many destroy requests for keys
+	// this node does not hold are waiting
+	ErrTooManyDeletions = errors.New("too many pending deletions")
+)
+
+// NodeKey returns the registered encryption key of the node with wallet
+// address signer, if it is an active, recently attested node
+type NodeKey func(ctx context.Context, signer solanago.PublicKey) (crypto.PublicKey, error)
+
+// Bundle carries data keys wrapped to one node, and the destroy requests the
+// sender knows of, signed with the sender's wallet
+type Bundle struct {
+	Sender    solanago.PublicKey `json:"sender"`
+	Recipient solanago.PublicKey `json:"recipient"`
+	Entries   []WrappedEntry     `json:"entries"`
+	Deletions []DestroyRequest   `json:"deletions"`
+	Signature solanago.Signature `json:"signature"`
+}
+
+// WrappedEntry is an Entry whose key is wrapped to the bundle's recipient
+type WrappedEntry struct {
+	KeyID   string             `json:"key_id"`
+	Binding keys.RecordBinding `json:"binding"`
+	Created int64              `json:"created"`
+	Key     *keys.Recipient    `json:"key"`
+}
+
+// SyncRequest asks a peer for all its keys and deletions
+type SyncRequest struct {
+	Requester solanago.PublicKey `json:"requester"`
+}
+
+// DestroyResponse holds the receipts of this node and of the peers that the
+// request was propagated to, and the peers that could not be reached; those
+// learn of the deletion on their next sync
+type DestroyResponse struct {
+	Receipts []*Receipt `json:"receipts"`
+	Pending  []string   `json:"pending,omitempty"`
+}
+
+// Node is one TEE node's key management service
+type Node struct {
+	Store   *Store
+	KeyPair *keys.KeyPair
+	Address solanago.PublicKey
+	// Sign signs with the node wallet, see solana.Client.Sign
+	Sign    func(message []byte) (solanago.Signature, error)
+	NodeKey NodeKey
+	// Client must verify peers with ratls.ClientConfig
+	Client *http.Client
+	Peers  []string
+
+	// addresses are the wallet addresses of peers, learned from the signed
+	// bundles of their sync
+	mu        sync.Mutex
+	addresses map[string]solanago.PublicKey
+	// pending holds destroy requests for keys this node does not hold, by key
+	// id and owner, in case the key is still on its way from a peer. Unlike
+	// tombstones they are neither stored nor synced to peers.
+	pending map[string]*Tombstone
+}
+
+// Generate creates the data key of a record and pushes it to all peers, so
+// the record stays readable if this node goes away. At least one peer must
+// take it, or the key is discarded and ErrNotReplicated returned; peers that
+// cannot be reached catch up on their next Sync.
+func (n *Node) Generate(ctx context.Context, binding keys.RecordBinding) (*Entry, error) {
+	e, err := n.Store.Generate(binding)
+	if err != nil {
+		return nil, err
+	}
+
+	replicated := 0
+	for _, peer := range n.Peers {
+		if err := n.push(ctx, peer, []*Entry{e}); err != nil {
+			fmt.Printf("⚠️  Failed to replicate kms key %s to %s: %v\n", e.KeyID, peer, err)
+			continue
+		}
+		replicated++
+	}
+	if replicated == 0 {
+		if err := n.Store.discard(e.KeyID); err != nil {
+			fmt.Printf("⚠️  Failed to discard kms key %s: %v\n", e.KeyID, err)
+		}
+		return nil, ErrNotReplicated
+	}
+	return e, nil
+}
+
+// Destroy carries out an owner's destroy request and returns this node's
+// receipt. Only keys this node holds leave a tombstone. Requests for other
+// keys are kept for a while, up to a bound, and carried out should the key
+// arrive from a peer in the meantime.
+func (n *Node) Destroy(req DestroyRequest) (*Receipt, error) {
+	if err := req.Verify(); err != nil {
+		return nil, err
+	}
+	req.Propagate = false
+
+	if t, err := n.Store.Tombstone(req.KeyID); err == nil {
+		if !t.Request.Owner.Equals(req.Owner) {
+			return nil, ErrNotOwner
+		}
+		return t.Receipt, nil
+	}
+	if t := n.pendingDeletion(req.KeyID, req.Owner); t != nil {
+		return t.Receipt, nil
+	}
+
+	e, err := n.Store.Get(req.KeyID)
+	held := err == nil
+	switch {
+	case held:
+		if !e.Binding.Owner.Equals(req.Owner) {
+			return nil, ErrNotOwner
+		}
+	case errors.Is(err, ErrKeyDestroyed):
+		// destroyed since the tombstone was looked up
+		t, err := n.Store.Tombstone(req.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		if !t.Request.Owner.Equals(req.Owner) {
+			return nil, ErrNotOwner
+		}
+		return t.Receipt, nil
+	case !errors.Is(err, ErrUnknownKey):
+		return nil, err
+	}
+
+	receipt := &Receipt{
+		KeyID:       req.KeyID,
+		Owner:       req.Owner,
+		DestroyedAt: time.Now().Unix(),
+		Node:        n.Address,
+	}
+	if receipt.Signature, err = n.Sign(receipt.message()); err != nil {
+		return nil, err
+	}
+
+	t := &Tombstone{Request: req, Receipt: receipt}
+	if !held {
+		if err := n.addPendingDeletion(t); err != nil {
+			return nil, err
+		}
+		return receipt, nil
+	}
+	if err := n.Store.Destroy(t); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// pendingDeletion returns the waiting destroy request of owner for keyID
+func (n *Node) pendingDeletion(keyID string, owner solanago.PublicKey) *Tombstone {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expirePendingDeletions(time.Now())
+	return n.pending[pendingKey(keyID, owner)]
+}
+
+// addPendingDeletion keeps t until its key arrives or it expires
+func (n *Node) addPendingDeletion(t *Tombstone) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expirePendingDeletions(time.Now())
+	if len(n.pending) >= maxPendingDeletions {
+		return ErrTooManyDeletions
+	}
+	if n.pending == nil {
+		n.pending = make(map[string]*Tombstone)
+	}
+	n.pending[pendingKey(t.Request.KeyID, t.Request.Owner)] = t
+	return nil
+}
+
+// takePendingDeletion removes and returns the waiting destroy request of the
+// owner of e, if any
+func (n *Node) takePendingDeletion(e *Entry) *Tombstone {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expirePendingDeletions(time.Now())
+	key := pendingKey(e.KeyID, e.Binding.Owner)
+	t := n.pending[key]
+	delete(n.pending, key)
+	return t
+}
+
+// expirePendingDeletions drops requests older than pendingDeletionTTL; n.mu
+// must be held
+func (n *Node) expirePendingDeletions(now time.Time) {
+	for key, t := range n.pending {
+		if now.Sub(time.Unix(t.Receipt.DestroyedAt, 0)) > pendingDeletionTTL {
+			delete(n.pending, key)
+		}
+	}
+}
+
+func pendingKey(keyID string, owner solanago.PublicKey) string {
+	return keyID + ":" + owner.String()
+}
+
+// Sync pulls the keys and deletions of every peer, e.g. after a restart or
+// to restore a node whose storage was lost
+func (n *Node) Sync(ctx context.Context) error {
+	var errs []error
+	for _, peer := range n.Peers {
+		if err := n.syncPeer(ctx, peer); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncPeer pulls the keys and deletions of one peer and remembers its address
+func (n *Node) syncPeer(ctx context.Context, peer string) error {
+	body, err := json.Marshal(SyncRequest{Requester: n.Address})
+	if err != nil {
+		return err
+	}
+
+	var bundle Bundle
+	if err := n.post(ctx, endpoint(peer, SyncPath), body, &bundle); err != nil {
+		return err
+	}
+	if err := n.verify(ctx, &bundle); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	if n.addresses == nil {
+		n.addresses = make(map[string]solanago.PublicKey)
+	}
+	n.addresses[peer] = bundle.Sender
+	n.mu.Unlock()
+
+	return n.apply(ctx, &bundle)
+}
+
+// DestroyHandler serves the owner's destroy requests, and their propagation
+// by peers
+func (n *Node) DestroyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req DestroyRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := req.Verify(); err != nil {
+			writeError(w, "Invalid destroy request: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		receipt, err := n.Destroy(req)
+		if errors.Is(err, ErrNotOwner) {
+			writeError(w, "Not authorized to destroy this key", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrTooManyDeletions) {
+			writeError(w, "Too many deletions of unknown keys, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			fmt.Printf("❌ Failed to destroy kms key %s: %v\n", req.KeyID, err)
+			writeError(w, "Failed to destroy key", http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("🗑️  Destroyed kms key %s of %s\n", req.KeyID, req.Owner)
+
+		resp := DestroyResponse{Receipts: []*Receipt{receipt}}
+		if req.Propagate {
+			for _, peer := range n.Peers {
+				receipt, err := n.forwardDestroy(r.Context(), peer, req)
+				if err != nil {
+					fmt.Printf("⚠️  Failed to propagate deletion of %s to %s: %v\n", req.KeyID, peer, err)
+					resp.Pending = append(resp.Pending, peer)
+					continue
+				}
+				resp.Receipts = append(resp.Receipts, receipt)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// ReplicateHandler stores keys pushed by peers
+func (n *Node) ReplicateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var bundle Bundle
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&bundle); err != nil {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := n.apply(r.Context(), &bundle); err != nil {
+			fmt.Printf("❌ Refusing kms keys from %s: %v\n", bundle.Sender, err)
+			writeError(w, "Invalid replication bundle", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SyncHandler hands all keys, wrapped to the requester's registered key, and
+// all deletions to a peer
+func (n *Node) SyncHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SyncRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		entries, tombstones, err := n.Store.List()
+		if err != nil {
+			fmt.Printf("❌ Failed to list kms keys: %v\n", err)
+			writeError(w, "Failed to list keys", http.StatusInternalServerError)
+			return
+		}
+
+		bundle, err := n.bundle(r.Context(), req.Requester, entries, tombstones)
+		if err != nil {
+			fmt.Printf("❌ Refusing kms sync to %s: %v\n", req.Requester, err)
+			writeError(w, "Requester is not an active, attested TEE node", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(bundle)
+	}
+}
+
+// push sends entries to one peer. A peer not synced with yet is synced with
+// first, which tells its address.
+func (n *Node) push(ctx context.Context, peer string, entries []*Entry) error {
+	n.mu.Lock()
+	address, ok := n.addresses[peer]
+	n.mu.Unlock()
+	if !ok {
+		if err := n.syncPeer(ctx, peer); err != nil {
+			return err
+		}
+		n.mu.Lock()
+		address = n.addresses[peer]
+		n.mu.Unlock()
+	}
+
+	bundle, err := n.bundle(ctx, address, entries, nil)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, endpoint(peer, ReplicatePath), body, nil)
+}
+
+// bundle wraps entries to recipient's registered key and signs them together
+// with the deletions of tombstones
+func (n *Node) bundle(ctx context.Context, recipient solanago.PublicKey, entries []*Entry, tombstones []*Tombstone) (*Bundle, error) {
+	pub, err := n.NodeKey(ctx, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Sender: n.Address, Recipient: recipient}
+	for _, e := range entries {
+		wrapped, err := keys.WrapKey(pub, e.Key)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Entries = append(bundle.Entries, WrappedEntry{KeyID: e.KeyID, Binding: e.Binding, Created: e.Created, Key: wrapped})
+	}
+	for _, t := range tombstones {
+		bundle.Deletions = append(bundle.Deletions, t.Request)
+	}
+
+	digest, err := bundle.digest()
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Signature, err = n.Sign(digest); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// verify checks that a bundle is for this node and signed by an active,
+// attested node
+func (n *Node) verify(ctx context.Context, bundle *Bundle) error {
+	if !bundle.Recipient.Equals(n.Address) {
+		return fmt.Errorf("bundle is for %s", bundle.Recipient)
+	}
+	if _, err := n.NodeKey(ctx, bundle.Sender); err != nil {
+		return err
+	}
+	digest, err := bundle.digest()
+	if err != nil {
+		return err
+	}
+	if !bundle.Signature.Verify(bundle.Sender, digest) {
+		return errors.New("bundle signature verification failed")
+	}
+	return nil
+}
+
+// apply stores the entries of a bundle and carries out its deletions. Every
+// deletion is checked against the owner's signature, not the sender's word.
+func (n *Node) apply(ctx context.Context, bundle *Bundle) error {
+	if err := n.verify(ctx, bundle); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, req := range bundle.Deletions {
+		if _, err := n.Destroy(req); err != nil && !errors.Is(err, ErrNotOwner) {
+			errs = append(errs, fmt.Errorf("deletion of %s: %w", req.KeyID, err))
+		}
+	}
+	for _, w := range bundle.Entries {
+		key, err := n.KeyPair.UnwrapKey(w.Key)
+		if err == nil {
+			e := &Entry{KeyID: w.KeyID, Key: key, Binding: w.Binding, Created: w.Created}
+			if err = n.Store.Put(e); err == nil {
+				// the owner asked to destroy it before it got here
+				if t := n.takePendingDeletion(e); t != nil {
+					err = n.Store.Destroy(t)
+				}
+			}
+		}
+		if err != nil && !errors.Is(err, ErrKeyDestroyed) {
+			errs = append(errs, fmt.Errorf("key %s: %w", w.KeyID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forwardDestroy propagates an owner's destroy request to one peer and checks
+// the receipt it returns
+func (n *Node) forwardDestroy(ctx context.Context, peer string, req DestroyRequest) (*Receipt, error) {
+	req.Propagate = false
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp DestroyResponse
+	if err := n.post(ctx, endpoint(peer, DestroyPath), body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Receipts) != 1 {
+		return nil, fmt.Errorf("expected one receipt, got %d", len(resp.Receipts))
+	}
+	receipt := resp.Receipts[0]
+	if receipt.KeyID != req.KeyID || !receipt.Owner.Equals(req.Owner) {
+		return nil, errors.New("receipt is for another key")
+	}
+	if err := receipt.Verify(); err != nil {
+		return nil, err
+	}
+	if _, err := n.NodeKey(ctx, receipt.Node); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// post sends a JSON body to a peer and decodes the JSON response into out,
+// if not nil
+func (n *Node) post(ctx context.Context, url string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// digest is what the sender of a bundle signs
+func (b *Bundle) digest() ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = solanago.Signature{}
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return []byte("kms-bundle:" + hex.EncodeToString(sum[:])), nil
+}
+
+func endpoint(peer, path string) string {
+	return strings.TrimSuffix(peer, "/") + path
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,356 @@
+package kms_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/kms"
+	"github.com/vitwit/healthlock/tee-client/ratls/ratlstest"
+)
+
+var sealingKey = bytes.Repeat([]byte{1}, 32)
+
+// registry stands in for the registered keys of active nodes on chain
+type registry struct {
+	mu   sync.Mutex
+	keys map[solanago.PublicKey]crypto.PublicKey
+}
+
+func (r *registry) nodeKey(_ context.Context, signer solanago.PublicKey) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pub, ok := r.keys[signer]
+	if !ok {
+		return nil, errors.New("not an active TEE node")
+	}
+	return pub, nil
+}
+
+// newCluster starts n in-process nodes, each peering with all others over
+// RA-TLS
+func newCluster(t *testing.T, n int) ([]*kms.Node, *registry) {
+	reg := &registry{keys: make(map[solanago.PublicKey]crypto.PublicKey)}
+	client := ratlstest.Client()
+
+	var nodes []*kms.Node
+	var urls []string
+	for i := 0; i < n; i++ {
+		kp, err := keys.GenerateX25519KeyPair(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := kms.OpenStore(t.TempDir(), sealingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallet := solanago.NewWallet()
+		reg.keys[wallet.PublicKey()] = kp.Public()
+
+		node := &kms.Node{
+			Store:   store,
+			KeyPair: kp,
+			Address: wallet.PublicKey(),
+			Sign:    wallet.PrivateKey.Sign,
+			NodeKey: reg.nodeKey,
+			Client:  client,
+		}
+		nodes = append(nodes, node)
+		urls = append(urls, serve(t, node))
+	}
+
+	for i, node := range nodes {
+		for j, url := range urls {
+			if j != i {
+				node.Peers = append(node.Peers, url)
+			}
+		}
+	}
+	return nodes, reg
+}
+
+func serve(t *testing.T, node *kms.Node) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc(kms.ReplicatePath, node.ReplicateHandler())
+	mux.HandleFunc(kms.SyncPath, node.SyncHandler())
+	mux.HandleFunc(kms.DestroyPath, node.DestroyHandler())
+	return ratlstest.Serve(t, mux)
+}
+
+func destroyRequest(t *testing.T, owner solanago.PrivateKey, keyID string) kms.DestroyRequest {
+	sig, err := owner.Sign(kms.DestroyMessage(keyID, owner.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kms.DestroyRequest{KeyID: keyID, Owner: owner.PublicKey(), Signature: sig}
+}
+
+func TestStoreSealsEntries(t *testing.T) {
+	dir := t.TempDir()
+	store, err := kms.OpenStore(dir, sealingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := store.Generate(keys.RecordBinding{RecordID: 7, MimeType: "application/pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := os.ReadFile(filepath.Join(dir, e.KeyID+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, e.Key) || bytes.Contains(sealed, []byte("application/pdf")) {
+		t.Error("entry is stored in the clear")
+	}
+
+	got, err := store.Get(e.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Key, e.Key) || got.Binding != e.Binding {
+		t.Errorf("got %+v", got)
+	}
+
+	// another machine or image derives another sealing key
+	other, err := kms.OpenStore(dir, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get(e.KeyID); err == nil {
+		t.Error("entry unsealed with another sealing key")
+	}
+
+	if _, err := store.Get("../" + e.KeyID); !errors.Is(err, kms.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestDestroyShredsKeyAndSignsReceipt(t *testing.T) {
+	nodes, _ := newCluster(t, 2)
+	node := nodes[0]
+	owner := solanago.NewWallet().PrivateKey
+
+	e, err := node.Generate(context.Background(), keys.RecordBinding{Owner: owner.PublicKey(), RecordID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone else cannot destroy the owner's key
+	if _, err := node.Destroy(destroyRequest(t, solanago.NewWallet().PrivateKey, e.KeyID)); !errors.Is(err, kms.ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+	forged := destroyRequest(t, solanago.NewWallet().PrivateKey, e.KeyID)
+	forged.Owner = owner.PublicKey()
+	if _, err := node.Destroy(forged); err == nil {
+		t.Fatal("destroyed with a forged signature")
+	}
+
+	receipt, err := node.Destroy(destroyRequest(t, owner, e.KeyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receipt.Verify(); err != nil {
+		t.Error(err)
+	}
+	if !receipt.Node.Equals(node.Address) || receipt.KeyID != e.KeyID {
+		t.Errorf("unexpected receipt %+v", receipt)
+	}
+
+	if _, err := node.Store.Get(e.KeyID); !errors.Is(err, kms.ErrKeyDestroyed) {
+		t.Errorf("expected ErrKeyDestroyed, got %v", err)
+	}
+	// a replicated copy cannot bring it back
+	if err := node.Store.Put(e); !errors.Is(err, kms.ErrKeyDestroyed) {
+		t.Errorf("expected ErrKeyDestroyed, got %v", err)
+	}
+
+	// destroying again returns the same receipt
+	again, err := node.Destroy(destroyRequest(t, owner, e.KeyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Signature != receipt.Signature {
+		t.Error("second destroy issued another receipt")
+	}
+}
+
+func TestKeysReplicateAndDeletionsPropagate(t *testing.T) {
+	nodes, _ := newCluster(t, 3)
+	owner := solanago.NewWallet().PrivateKey
+
+	e, err := nodes[0].Generate(context.Background(), keys.RecordBinding{Owner: owner.PublicKey(), RecordID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, node := range nodes[1:] {
+		got, err := node.Store.Get(e.KeyID)
+		if err != nil {
+			t.Fatalf("peer %d: %v", i+1, err)
+		}
+		if !bytes.Equal(got.Key, e.Key) {
+			t.Errorf("peer %d holds another key", i+1)
+		}
+	}
+
+	req := destroyRequest(t, owner, e.KeyID)
+	req.Propagate = true
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(serveHTTP(t, nodes[1]), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var destroyed kms.DestroyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&destroyed); err != nil {
+		t.Fatal(err)
+	}
+	if len(destroyed.Receipts) != 3 || len(destroyed.Pending) != 0 {
+		t.Fatalf("got %d receipts, pending %v", len(destroyed.Receipts), destroyed.Pending)
+	}
+
+	for i, node := range nodes {
+		if _, err := node.Store.Get(e.KeyID); !errors.Is(err, kms.ErrKeyDestroyed) {
+			t.Errorf("node %d: expected ErrKeyDestroyed, got %v", i, err)
+		}
+	}
+}
+
+func TestSyncRestoresLostStore(t *testing.T) {
+	nodes, _ := newCluster(t, 2)
+	owner := solanago.NewWallet().PrivateKey
+
+	kept, err := nodes[0].Generate(context.Background(), keys.RecordBinding{Owner: owner.PublicKey(), RecordID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := nodes[0].Generate(context.Background(), keys.RecordBinding{Owner: owner.PublicKey(), RecordID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes[0].Destroy(destroyRequest(t, owner, gone.KeyID)); err != nil {
+		t.Fatal(err)
+	}
+
+	// node 1 lost its storage
+	store, err := kms.OpenStore(t.TempDir(), sealingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[1].Store = store
+	if err := nodes[1].Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(kept.KeyID); err != nil {
+		t.Errorf("key was not restored: %v", err)
+	}
+	if _, err := store.Get(gone.KeyID); !errors.Is(err, kms.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestReplicationRefusesUnregisteredNode(t *testing.T) {
+	nodes, reg := newCluster(t, 2)
+
+	reg.mu.Lock()
+	delete(reg.keys, nodes[0].Address)
+	reg.mu.Unlock()
+
+	// no record may be sealed with a key only one node holds
+	if _, err := nodes[0].Generate(context.Background(), keys.RecordBinding{RecordID: 1}); !errors.Is(err, kms.ErrNotReplicated) {
+		t.Fatalf("expected ErrNotReplicated, got %v", err)
+	}
+	for i, node := range nodes {
+		if entries, _, err := node.Store.List(); err != nil || len(entries) != 0 {
+			t.Errorf("node %d holds %d keys: %v", i, len(entries), err)
+		}
+	}
+	if err := nodes[0].Sync(context.Background()); err == nil {
+		t.Error("unregistered node synced keys")
+	}
+}
+
+func TestDeletionWaitsForItsKey(t *testing.T) {
+	nodes, _ := newCluster(t, 3)
+	owner := solanago.NewWallet().PrivateKey
+
+	// node 2 is not reached when the key is created
+	peers := nodes[0].Peers
+	nodes[0].Peers = peers[:1]
+	e, err := nodes[0].Generate(context.Background(), keys.RecordBinding{Owner: owner.PublicKey(), RecordID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].Peers = peers
+
+	if _, err := nodes[2].Destroy(destroyRequest(t, owner, e.KeyID)); err != nil {
+		t.Fatal(err)
+	}
+	// the request is no tombstone, so it is not synced to the others
+	if _, tombstones, err := nodes[2].Store.List(); err != nil || len(tombstones) != 0 {
+		t.Fatalf("got %d tombstones: %v", len(tombstones), err)
+	}
+
+	if err := nodes[2].Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes[2].Store.Get(e.KeyID); !errors.Is(err, kms.ErrKeyDestroyed) {
+		t.Errorf("expected ErrKeyDestroyed, got %v", err)
+	}
+}
+
+func TestDeletionsOfUnknownKeysAreBounded(t *testing.T) {
+	nodes, _ := newCluster(t, 1)
+	stranger := solanago.NewWallet().PrivateKey
+
+	var err error
+	for i := 0; err == nil; i++ {
+		if i > 1<<12 {
+			t.Fatal("deletions of unknown keys are unbounded")
+		}
+		_, err = nodes[0].Destroy(destroyRequest(t, stranger, randomKeyID(t)))
+	}
+	if !errors.Is(err, kms.ErrTooManyDeletions) {
+		t.Fatalf("expected ErrTooManyDeletions, got %v", err)
+	}
+	if _, tombstones, err := nodes[0].Store.List(); err != nil || len(tombstones) != 0 {
+		t.Errorf("got %d tombstones: %v", len(tombstones), err)
+	}
+}
+
+func randomKeyID(t *testing.T) string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// serveHTTP serves the destroy handler of node without TLS, as clients reach
+// it
+func serveHTTP(t *testing.T, node *kms.Node) string {
+	server := &http.Server{Handler: node.DestroyHandler(), ErrorLog: log.New(io.Discard, "", 0)}
+	ln, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + ln.Addr().String()
+}
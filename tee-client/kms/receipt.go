@@ -0,0 +1,64 @@
+package kms
+
+import (
+	"errors"
+	"fmt"
+
+	solanago "github.com/gagliardetto/solana-go"
+)
+
+// DestroyRequest is the record owner's order to destroy the key of one of
+// their records. It is signed with the owner's wallet over
+//
+//	"kms-destroy:<key_id>:<owner>"
+//
+// and forwarded as is to peer nodes, which check it themselves.
+type DestroyRequest struct {
+	KeyID     string             `json:"keyId"`
+	Owner     solanago.PublicKey `json:"owner"`
+	Signature solanago.Signature `json:"signature"`
+	// Propagate asks the node to forward the request to its peers; forwarded
+	// requests have it unset
+	Propagate bool `json:"propagate,omitempty"`
+}
+
+// DestroyMessage is what the owner signs to destroy keyID
+func DestroyMessage(keyID string, owner solanago.PublicKey) []byte {
+	return []byte(fmt.Sprintf("kms-destroy:%s:%s", keyID, owner))
+}
+
+// Verify checks the owner's signature
+func (r *DestroyRequest) Verify() error {
+	if !validKeyID(r.KeyID) {
+		return errors.New("invalid key id")
+	}
+	if !r.Signature.Verify(r.Owner, DestroyMessage(r.KeyID, r.Owner)) {
+		return errors.New("owner signature verification failed")
+	}
+	return nil
+}
+
+// Receipt is a TEE node's signed statement that it destroyed a key. It is
+// signed with the node's wallet, whose address is registered on chain, over
+//
+//	"kms-deletion:<key_id>:<owner>:<destroyed_at>:<node>"
+type Receipt struct {
+	KeyID       string             `json:"key_id"`
+	Owner       solanago.PublicKey `json:"owner"`
+	DestroyedAt int64              `json:"destroyed_at"` // unix seconds
+	Node        solanago.PublicKey `json:"node"`
+	Signature   solanago.Signature `json:"signature"`
+}
+
+func (r *Receipt) message() []byte {
+	return []byte(fmt.Sprintf("kms-deletion:%s:%s:%d:%s", r.KeyID, r.Owner, r.DestroyedAt, r.Node))
+}
+
+// Verify checks the receipt's signature. Whether Node is a registered TEE
+// node is for the caller to check on chain.
+func (r *Receipt) Verify() error {
+	if !r.Signature.Verify(r.Node, r.message()) {
+		return errors.New("receipt signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/internal/fsutil"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	keyFileExt       = ".key"
+	tombstoneFileExt = ".deleted"
+	dataKeySize      = 32
+	keyIDSize        = 16
+)
+
+// storeKeyInfo derives the key entries are sealed with from the TEE sealing
+// key, so that key can seal other state too
+var storeKeyInfo = []byte("healthlock kms store v1")
+
+var (
+	// ErrUnknownKey is returned for key ids the store never held
+	ErrUnknownKey = errors.New("unknown kms key")
+	// ErrKeyDestroyed is returned for keys that have been destroyed
+	ErrKeyDestroyed = errors.New("kms key has been destroyed")
+)
+
+// Entry is the data key of one record
+type Entry struct {
+	KeyID   string             `json:"key_id"`
+	Key     []byte             `json:"key"`
+	Binding keys.RecordBinding `json:"binding"`
+	Created int64              `json:"created"` // unix seconds
+}
+
+// Tombstone is what remains of a destroyed key: the owner's request and this
+// node's receipt. It keeps the key from coming back through replication.
+type Tombstone struct {
+	Request DestroyRequest `json:"request"`
+	Receipt *Receipt       `json:"receipt"`
+}
+
+// Store keeps data keys in a local directory, each sealed under a key only
+// this TEE image on this machine can derive. Destroying a key overwrites and
+// removes its file and leaves a tombstone.
+type Store struct {
+	dir  string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// OpenStore opens, or creates, the store in dir. sealingKey normally comes
+// from tee.Sealer.
+func OpenStore(dir string, sealingKey []byte) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create kms directory: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sealingKey, nil, storeKeyInfo), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir, aead: aead}, nil
+}
+
+// Generate creates a data key for the record of binding
+func (s *Store) Generate(binding keys.RecordBinding) (*Entry, error) {
+	id := make([]byte, keyIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		KeyID:   hex.EncodeToString(id),
+		Key:     make([]byte, dataKeySize),
+		Binding: binding,
+		Created: time.Now().Unix(),
+	}
+	if _, err := rand.Read(e.Key); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Get returns the entry of keyID, or ErrKeyDestroyed or ErrUnknownKey
+func (s *Store) Get(keyID string) (*Entry, error) {
+	if !validKeyID(keyID) {
+		return nil, ErrUnknownKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(keyID)
+}
+
+// Put stores an entry replicated from a peer. It keeps an entry it already
+// holds and refuses keys destroyed for the same owner.
+func (s *Store) Put(e *Entry) error {
+	if !validKeyID(e.KeyID) || len(e.Key) != dataKeySize {
+		return errors.New("invalid kms entry")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.read(e.KeyID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrKeyDestroyed):
+		t, err := s.tombstone(e.KeyID)
+		if err != nil {
+			return err
+		}
+		// a tombstone for someone else's key does not count
+		if t.Request.Owner.Equals(e.Binding.Owner) {
+			return ErrKeyDestroyed
+		}
+	case !errors.Is(err, ErrUnknownKey):
+		return err
+	}
+	return s.write(e)
+}
+
+// Destroy shreds the key of t.Request.KeyID, if held, and records t. Keys are
+// gone for good once every node holding them has destroyed them.
+func (s *Store) Destroy(t *Tombstone) error {
+	keyID := t.Request.KeyID
+	if !validKeyID(keyID) {
+		return ErrUnknownKey
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the tombstone goes first, so a crash cannot bring the key back
+	if err := fsutil.WriteFileSync(s.path(keyID, tombstoneFileExt), data); err != nil {
+		return err
+	}
+	return fsutil.Shred(s.path(keyID, keyFileExt))
+}
+
+// discard shreds the key of keyID without leaving a tombstone, for keys no
+// record was sealed with
+func (s *Store) discard(keyID string) error {
+	if !validKeyID(keyID) {
+		return ErrUnknownKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fsutil.Shred(s.path(keyID, keyFileExt))
+}
+
+// Tombstone returns the tombstone of keyID, or ErrUnknownKey if it was never
+// destroyed here
+func (s *Store) Tombstone(keyID string) (*Tombstone, error) {
+	if !validKeyID(keyID) {
+		return nil, ErrUnknownKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tombstone(keyID)
+}
+
+// List returns all held entries and all tombstones
+func (s *Store) List() ([]*Entry, []*Tombstone, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*Entry
+	var tombstones []*Tombstone
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, keyFileExt):
+			e, err := s.read(strings.TrimSuffix(name, keyFileExt))
+			if errors.Is(err, ErrKeyDestroyed) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			entries = append(entries, e)
+		case strings.HasSuffix(name, tombstoneFileExt):
+			t, err := s.tombstone(strings.TrimSuffix(name, tombstoneFileExt))
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			tombstones = append(tombstones, t)
+		}
+	}
+	return entries, tombstones, nil
+}
+
+func (s *Store) path(keyID, ext string) string {
+	return filepath.Join(s.dir, keyID+ext)
+}
+
+func (s *Store) read(keyID string) (*Entry, error) {
+	if _, err := os.Stat(s.path(keyID, tombstoneFileExt)); err == nil {
+		// finish a destruction interrupted before the key was shredded
+		if err := fsutil.Shred(s.path(keyID, keyFileExt)); err != nil {
+			return nil, err
+		}
+		return nil, ErrKeyDestroyed
+	}
+
+	sealed, err := os.ReadFile(s.path(keyID, keyFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnknownKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed kms entry too short")
+	}
+	data, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal kms entry: %w", err)
+	}
+
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (s *Store) write(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, data, []byte(e.KeyID))
+
+	// a restored key replaces the tombstone of a key destroyed for another owner
+	if err := os.Remove(s.path(e.KeyID, tombstoneFileExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return fsutil.WriteFileSync(s.path(e.KeyID, keyFileExt), sealed)
+}
+
+func (s *Store) tombstone(keyID string) (*Tombstone, error) {
+	data, err := os.ReadFile(s.path(keyID, tombstoneFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnknownKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t Tombstone
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func validKeyID(keyID string) bool {
+	id, err := hex.DecodeString(keyID)
+	return err == nil && len(id) == keyIDSize && hex.EncodeToString(id) == keyID
+}
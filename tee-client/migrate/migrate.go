@@ -0,0 +1,302 @@
+// Package migrate moves a node's key to a replacement node without it ever
+// leaving an enclave in the clear. The new node attests to a fresh X25519 key,
+// the old node checks that attestation against its measurement policy and
+// returns its key encrypted to that ephemeral key, and both sides log the
+// transfer.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Path is where old nodes serve Handler
+const Path = "/v1/migrate"
+
+// maxResponseSize bounds a migration response; a wrapped 4096 bit key is
+// a few KB
+const maxResponseSize = 64 << 10
+
+// maxRequestSize bounds a migration request; an attestation report and its
+// certificates are a few KB
+const maxRequestSize = 64 << 10
+
+var hkdfInfo = []byte("healthlock key migration v1")
+
+// Request is sent by the new node. Its report carries the hash of
+// EphemeralKey as nonce, so only the attested enclave can unwrap the reply.
+type Request struct {
+	EphemeralKey []byte `json:"ephemeral_key"` // X25519 public key
+	Report       []byte `json:"report"`
+	CertTable    []byte `json:"cert_table,omitempty"`
+}
+
+// Response carries the old node's key, encrypted under a key agreed
+// between both ephemeral keys
+type Response struct {
+	KeyID        string `json:"key_id"`
+	EphemeralKey []byte `json:"ephemeral_key"` // X25519 public key of the old node
+	Nonce        []byte `json:"nonce"`
+	WrappedKey   []byte `json:"wrapped_key"` // AES-GCM sealed PKCS#8 DER
+}
+
+// Event records one side of a migration
+type Event struct {
+	Direction   string    `json:"direction"` // "sent" on the old node, "received" on the new one
+	KeyID       string    `json:"key_id"`
+	Measurement string    `json:"measurement"` // of the new node
+	ReportHash  string    `json:"report_hash"` // of the new node's report
+	Time        time.Time `json:"time"`
+}
+
+// Logger is called once a migration completes on either side
+type Logger func(Event)
+
+// NewRequest generates an ephemeral key and attests to it
+func NewRequest(attestor tee.Attestor) (*Request, *ecdh.PrivateKey, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ephemeral := priv.PublicKey().Bytes()
+	evidence, err := attestor.GenerateAttestationReport(keyNonce(ephemeral))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to attest migration key: %w", err)
+	}
+
+	return &Request{EphemeralKey: ephemeral, Report: evidence.Report, CertTable: evidence.CertTable}, priv, nil
+}
+
+// Wrap verifies the new node's attestation against the policy of attestor
+// and encrypts kp to the ephemeral key in its report
+func Wrap(kp *keys.KeyPair, attestor tee.Attestor, req *Request) (*Response, *Event, error) {
+	evidence := &tee.Evidence{Report: req.Report, CertTable: req.CertTable}
+	if err := attestor.VerifyAttestationReport(evidence, keyNonce(req.EphemeralKey)); err != nil {
+		return nil, nil, fmt.Errorf("attestation verification failed: %w", err)
+	}
+
+	event, err := newEvent("sent", kp, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(req.EphemeralKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp := &Response{KeyID: event.KeyID, EphemeralKey: priv.PublicKey().Bytes()}
+	aead, err := newAEAD(priv, peer, req.EphemeralKey, resp.EphemeralKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, resp.Nonce); err != nil {
+		return nil, nil, err
+	}
+	der, err := kp.MarshalPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.WrappedKey = aead.Seal(nil, resp.Nonce, der, []byte(resp.KeyID))
+
+	return resp, event, nil
+}
+
+// Unwrap recovers the key from the old node's response
+func Unwrap(priv *ecdh.PrivateKey, req *Request, resp *Response) (*keys.KeyPair, *Event, error) {
+	peer, err := ecdh.X25519().NewPublicKey(resp.EphemeralKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	aead, err := newAEAD(priv, peer, req.EphemeralKey, resp.EphemeralKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Nonce) != aead.NonceSize() {
+		return nil, nil, errors.New("invalid nonce size")
+	}
+
+	der, err := aead.Open(nil, resp.Nonce, resp.WrappedKey, []byte(resp.KeyID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	kp, err := keys.ParsePrivateKey(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid migrated key: %w", err)
+	}
+
+	event, err := newEvent("received", kp, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if event.KeyID != resp.KeyID {
+		return nil, nil, fmt.Errorf("migrated key %s does not match announced key %s", event.KeyID, resp.KeyID)
+	}
+
+	return kp, event, nil
+}
+
+// Handler serves kp to new nodes whose attestation passes the policy of
+// attestor
+func Handler(kp *keys.KeyPair, attestor tee.Attestor, logger Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, "Request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		resp, event, err := Wrap(kp, attestor, &req)
+		if err != nil {
+			fmt.Printf("❌ Refusing key migration: %v\n", err)
+			writeError(w, "Attestation rejected", http.StatusForbidden)
+			return
+		}
+		logger(*event)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Fetch runs the new node's side of a migration against the old node at
+// url. client must verify the old node with ratls.ClientConfig.
+func Fetch(ctx context.Context, client *http.Client, url string, attestor tee.Attestor, logger Logger) (*keys.KeyPair, error) {
+	req, priv, err := NewRequest(attestor)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(url, "/")+Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("old node refused migration: status %d: %s", httpResp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var resp Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("invalid migration response: %w", err)
+	}
+
+	kp, event, err := Unwrap(priv, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	logger(*event)
+
+	return kp, nil
+}
+
+// PrintEvent is the default Logger, writing one JSON line to stdout
+func PrintEvent(event Event) {
+	line, _ := json.Marshal(event)
+	fmt.Printf("🔑 Key migration: %s\n", line)
+}
+
+func newEvent(direction string, kp *keys.KeyPair, req *Request) (*Event, error) {
+	keyID, err := keys.KeyID(kp.Public())
+	if err != nil {
+		return nil, err
+	}
+	commitment, err := tee.NewAttestationCommitment(req.Report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Direction:   direction,
+		KeyID:       keyID,
+		Measurement: hex.EncodeToString(commitment.Measurement[:]),
+		ReportHash:  hex.EncodeToString(commitment.ReportHash[:]),
+		Time:        time.Now().UTC(),
+	}, nil
+}
+
+// newAEAD derives the wrapping key from the X25519 shared secret, salted with
+// both ephemeral keys
+func newAEAD(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, newNodeKey, oldNodeKey []byte) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(append([]byte{}, newNodeKey...), oldNodeKey...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, hkdfInfo), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyNonce is the report_data nonce for a migration key
+func keyNonce(ephemeralKey []byte) string {
+	hash := sha256.Sum256(append([]byte("MIGRATE:"), ephemeralKey...))
+	return hex.EncodeToString(hash[:])
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
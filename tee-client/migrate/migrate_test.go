@@ -0,0 +1,199 @@
+//go:build mock
+// +build mock
+
+package migrate_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/migrate"
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+var (
+	trustedMeasurement = strings.Repeat("ab", 48)
+	otherMeasurement   = strings.Repeat("cd", 48)
+)
+
+func newMockAttestor(t *testing.T, measurement string) tee.Attestor {
+	cfg := &config.Config{Attestation: config.AttestationConfig{
+		Policy: config.AttestationPolicyConfig{Measurements: []string{measurement}},
+	}}
+	attestor, err := tee.NewAttestor(types.NewContext().WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attestor
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []migrate.Event
+}
+
+func (l *eventLog) log(e migrate.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// newOldNode serves kp for migration over RA-TLS
+func newOldNode(t *testing.T, kp *keys.KeyPair, attestor tee.Attestor, logger migrate.Logger) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", ratls.ServerConfig(ratls.NewCertificateProvider(attestor, time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(migrate.Path, migrate.Handler(kp, attestor, logger))
+	server := &http.Server{Handler: mux, ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+
+	return "https://" + ln.Addr().String()
+}
+
+func ratlsClient(attestor tee.Attestor) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(attestor.VerifyAttestationReport)}}
+}
+
+func TestMigrateEndToEnd(t *testing.T) {
+	kp, err := keys.GenerateKeyPair(2048, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldAttestor := newMockAttestor(t, trustedMeasurement)
+	newAttestor := newMockAttestor(t, trustedMeasurement)
+
+	var oldLog, newLog eventLog
+	url := newOldNode(t, kp, oldAttestor, oldLog.log)
+
+	migrated, err := migrate.Fetch(context.Background(), ratlsClient(newAttestor), url, newAttestor, newLog.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated.PrivateKey.Equal(kp.PrivateKey) {
+		t.Fatal("migrated key differs from the old node's key")
+	}
+
+	// a record sealed to the old node opens on the new one
+	sealed, err := kp.EncryptFile([]byte("discharge summary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrated.Open(sealed); err != nil {
+		t.Fatalf("new node failed to open record: %v", err)
+	}
+
+	if len(oldLog.events) != 1 || len(newLog.events) != 1 {
+		t.Fatalf("expected one event on each side, got %d and %d", len(oldLog.events), len(newLog.events))
+	}
+	sent, received := oldLog.events[0], newLog.events[0]
+	if sent.Direction != "sent" || received.Direction != "received" {
+		t.Errorf("unexpected directions %q and %q", sent.Direction, received.Direction)
+	}
+	if sent.KeyID != received.KeyID || sent.ReportHash != received.ReportHash {
+		t.Error("both sides should log the same key and report")
+	}
+	if sent.Measurement != trustedMeasurement {
+		t.Errorf("logged measurement %s", sent.Measurement)
+	}
+}
+
+func TestMigrateX25519Key(t *testing.T) {
+	kp, err := keys.GenerateX25519KeyPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestor := newMockAttestor(t, trustedMeasurement)
+	var events eventLog
+	url := newOldNode(t, kp, attestor, events.log)
+
+	migrated, err := migrate.Fetch(context.Background(), ratlsClient(attestor), url, attestor, events.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.X25519 == nil || !migrated.X25519.Equal(kp.X25519) {
+		t.Fatal("migrated key differs from the old node's key")
+	}
+}
+
+func TestMigrateRejectsUntrustedMeasurement(t *testing.T) {
+	kp, err := keys.GenerateKeyPair(2048, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldAttestor := newMockAttestor(t, trustedMeasurement)
+	// the new node runs an image the old node's policy does not accept
+	newAttestor := newMockAttestor(t, otherMeasurement)
+
+	var oldLog, newLog eventLog
+	url := newOldNode(t, kp, oldAttestor, oldLog.log)
+
+	// the new node trusts the old node's image, but not the other way round
+	client := ratlsClient(oldAttestor)
+	if _, err := migrate.Fetch(context.Background(), client, url, newAttestor, newLog.log); err == nil {
+		t.Fatal("expected migration to an untrusted image to be refused")
+	}
+	if len(oldLog.events) != 0 || len(newLog.events) != 0 {
+		t.Error("a refused migration must not be logged as done")
+	}
+}
+
+func TestUnwrapRequiresEphemeralKey(t *testing.T) {
+	kp, err := keys.GenerateKeyPair(2048, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestor := newMockAttestor(t, trustedMeasurement)
+
+	req, _, err := migrate.NewRequest(attestor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := migrate.Wrap(kp, attestor, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone replaying the report without the matching private key
+	_, other, err := migrate.NewRequest(attestor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := migrate.Unwrap(other, req, resp); err == nil {
+		t.Error("expected unwrap with another ephemeral key to fail")
+	}
+
+	// a report bound to one key must not vouch for another
+	forged := *req
+	forged.EphemeralKey = other.PublicKey().Bytes()
+	if _, _, err := migrate.Wrap(kp, attestor, &forged); err == nil {
+		t.Error("expected report for another ephemeral key to be rejected")
+	}
+}
+
+func TestHandlerBoundsRequest(t *testing.T) {
+	body := `{"report":"` + strings.Repeat("A", 128<<10) + `"}`
+	w := httptest.NewRecorder()
+	migrate.Handler(nil, nil, nil)(w, httptest.NewRequest(http.MethodPost, migrate.Path, strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+}
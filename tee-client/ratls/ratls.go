@@ -0,0 +1,203 @@
+// Package ratls serves TLS with a certificate whose key is generated inside the
+// enclave and bound to an attestation report carried in an X.509 extension,
+// so the TLS session terminates inside the TEE and clients can check that
+// during the handshake.
+package ratls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+// OIDAttestation identifies the certificate extension holding the evidence
+var OIDAttestation = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 59196, 1, 1}
+
+// DefaultValidity is how long an attested certificate is served before a new
+// key and report are generated
+const DefaultValidity = 24 * time.Hour
+
+// VerifyFunc checks evidence against the nonce derived from the certificate
+// key; tee.Attestor.VerifyAttestationReport satisfies it
+type VerifyFunc func(evidence *tee.Evidence, expectedNonce string) error
+
+type attestationExtension struct {
+	Report    []byte
+	CertTable []byte
+}
+
+// CertificateProvider issues self-signed RA-TLS certificates and renews them
+// before they expire
+type CertificateProvider struct {
+	attestor tee.Attestor
+	validity time.Duration
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func NewCertificateProvider(attestor tee.Attestor, validity time.Duration) *CertificateProvider {
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	return &CertificateProvider{attestor: attestor, validity: validity}
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (p *CertificateProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// renew once half of the validity has passed
+	if p.cert != nil && time.Now().Before(p.cert.Leaf.NotAfter.Add(-p.validity/2)) {
+		return p.cert, nil
+	}
+
+	cert, err := NewCertificate(p.attestor, p.validity)
+	if err != nil {
+		return nil, err
+	}
+	p.cert = cert
+	return cert, nil
+}
+
+// NewCertificate generates a fresh ECDSA key, attests to its hash and returns a
+// self-signed certificate carrying the evidence
+func NewCertificate(attestor tee.Attestor, validity time.Duration) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	evidence, err := attestor.GenerateAttestationReport(keyNonce(spki))
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest TLS key: %w", err)
+	}
+
+	ext, err := asn1.Marshal(attestationExtension{Report: evidence.Report, CertTable: evidence.CertTable})
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: "healthlock-tee"},
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(validity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{{Id: OIDAttestation, Value: ext}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// VerifyCertificate checks that cert is self-signed, currently valid and
+// carries evidence bound to its own public key
+func VerifyCertificate(cert *x509.Certificate, verify VerifyFunc) error {
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return fmt.Errorf("certificate is not self-signed: %w", err)
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("certificate is expired or not yet valid")
+	}
+
+	var raw []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDAttestation) {
+			raw = ext.Value
+			break
+		}
+	}
+	if raw == nil {
+		return errors.New("certificate has no attestation extension")
+	}
+
+	var ext attestationExtension
+	rest, err := asn1.Unmarshal(raw, &ext)
+	if err != nil {
+		return fmt.Errorf("invalid attestation extension: %w", err)
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing data in attestation extension")
+	}
+
+	evidence := &tee.Evidence{Report: ext.Report, CertTable: ext.CertTable}
+	if err := verify(evidence, keyNonce(cert.RawSubjectPublicKeyInfo)); err != nil {
+		return fmt.Errorf("attestation verification failed: %w", err)
+	}
+	return nil
+}
+
+// ClientConfig returns a TLS config that accepts a server only if its
+// certificate passes VerifyCertificate. The usual PKI checks are replaced by
+// the attestation check, as the certificate is self-signed by design.
+func ClientConfig(verify VerifyFunc) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			return VerifyCertificate(cert, verify)
+		},
+	}
+}
+
+// ServerConfig returns a TLS config serving certificates from p
+func ServerConfig(p *CertificateProvider) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: p.GetCertificate,
+	}
+}
+
+// keyNonce is the report_data nonce for a certificate key
+func keyNonce(spki []byte) string {
+	hash := sha256.Sum256(append([]byte("RATLS:"), spki...))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,115 @@
+package ratls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/ratls/ratlstest"
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+func newServer(t *testing.T) string {
+	return ratlstest.Serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello from the enclave"))
+	}))
+}
+
+func get(url string, verify ratls.VerifyFunc) (string, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(verify)}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestRATLSHandshake(t *testing.T) {
+	server := newServer(t)
+
+	body, err := get(server, ratlstest.EchoAttestor{}.VerifyAttestationReport)
+	if err != nil {
+		t.Fatalf("request over RA-TLS failed: %v", err)
+	}
+	if body != "hello from the enclave" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestRATLSRejectsFailedAttestation(t *testing.T) {
+	server := newServer(t)
+
+	_, err := get(server, func(*tee.Evidence, string) error {
+		return errors.New("untrusted measurement")
+	})
+	if err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+}
+
+func TestRATLSRejectsPlainCertificate(t *testing.T) {
+	// httptest's default certificate has no attestation extension
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := get(server.URL, ratlstest.EchoAttestor{}.VerifyAttestationReport); err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+}
+
+func TestVerifyCertificateBindsKey(t *testing.T) {
+	cert, err := ratls.NewCertificate(ratlstest.EchoAttestor{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ratls.VerifyCertificate(cert.Leaf, ratlstest.EchoAttestor{}.VerifyAttestationReport); err != nil {
+		t.Fatalf("expected certificate to verify: %v", err)
+	}
+
+	// evidence copied into a certificate for another key must not verify
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: ratls.OIDAttestation, Value: extensionValue(t, cert.Leaf)}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ratls.VerifyCertificate(forged, ratlstest.EchoAttestor{}.VerifyAttestationReport); err == nil {
+		t.Error("expected evidence to be bound to the certificate key")
+	}
+}
+
+func extensionValue(t *testing.T, cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ratls.OIDAttestation) {
+			return ext.Value
+		}
+	}
+	t.Fatal("certificate has no attestation extension")
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package ratlstest provides a fake attestor and an RA-TLS server for tests
+// of packages that talk to each other over RA-TLS.
+package ratlstest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/ratls"
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+// EchoAttestor "attests" by putting the nonce in the report verbatim
+type EchoAttestor struct{}
+
+func (EchoAttestor) GenerateAttestationReport(nonce string) (*tee.Evidence, error) {
+	report, err := hex.DecodeString(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &tee.Evidence{Report: report}, nil
+}
+
+func (EchoAttestor) VerifyAttestationReport(evidence *tee.Evidence, expectedNonce string) error {
+	nonce, err := hex.DecodeString(expectedNonce)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(evidence.Report, nonce) {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
+
+// Client returns an HTTP client that accepts servers attested by EchoAttestor
+func Client() *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: ratls.ClientConfig(EchoAttestor{}.VerifyAttestationReport)}}
+}
+
+// Serve serves handler over RA-TLS with a certificate attested by
+// EchoAttestor until the test ends, and returns its https URL
+func Serve(t testing.TB, handler http.Handler) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", ratls.ServerConfig(ratls.NewCertificateProvider(EchoAttestor{}, time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+
+	return "https://" + ln.Addr().String()
+}
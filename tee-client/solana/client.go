@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -45,10 +46,12 @@ func (c *Client) RPC() *rpc.Client {
 	return c.rpcClient
 }
 
+// walletKeyFile holds the node wallet of the last start
+const walletKeyFile = "private-key.key"
+
 func (c *Client) CreateWallet(debug bool) error {
 	fmt.Println("================== Generating Wallet ===================")
 
-	const keyFile = "private-key.key"
 	var (
 		account *solana.Wallet
 		err     error
@@ -56,23 +59,23 @@ func (c *Client) CreateWallet(debug bool) error {
 
 	if debug {
 		// Try to load existing wallet
-		keyData, readErr := os.ReadFile(keyFile)
+		keyData, readErr := os.ReadFile(walletKeyFile)
 		if readErr == nil {
 			account, err = solana.WalletFromPrivateKeyBase58(string(keyData))
 			if err != nil {
 				return fmt.Errorf("failed to load wallet from private key: %w", err)
 			}
-			fmt.Println("Loaded wallet from", keyFile)
+			fmt.Println("Loaded wallet from", walletKeyFile)
 		} else {
 			fmt.Println("No existing key found or unreadable, generating new wallet...")
 			account = solana.NewWallet()
-			if err := savePrivateKey(account, keyFile); err != nil {
+			if err := savePrivateKey(account, walletKeyFile); err != nil {
 				return err
 			}
 		}
 	} else {
 		account = solana.NewWallet()
-		if err := savePrivateKey(account, keyFile); err != nil {
+		if err := savePrivateKey(account, walletKeyFile); err != nil {
 			return err
 		}
 		fmt.Println("New wallet generated and key saved.")
@@ -87,6 +90,30 @@ func (c *Client) CreateWallet(debug bool) error {
 	return nil
 }
 
+// LoadWallet loads the wallet saved by the last start, for commands that act
+// on behalf of an already registered node
+func (c *Client) LoadWallet() error {
+	keyData, err := os.ReadFile(walletKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read node wallet: %w", err)
+	}
+	account, err := solana.WalletFromPrivateKeyBase58(string(keyData))
+	if err != nil {
+		return fmt.Errorf("failed to load wallet from private key: %w", err)
+	}
+
+	c.wallet = account.PrivateKey
+	c.pubKey = account.PublicKey()
+	return nil
+}
+
+// UseWallet makes the client sign with wallet, such as that of the
+// governance authority, instead of a node wallet
+func (c *Client) UseWallet(wallet solana.PrivateKey) {
+	c.wallet = wallet
+	c.pubKey = wallet.PublicKey()
+}
+
 // savePrivateKey writes the wallet's private key to the specified file with secure permissions
 func savePrivateKey(account *solana.Wallet, path string) error {
 	err := os.WriteFile(path, []byte(account.PrivateKey.String()), 0600)
@@ -104,6 +131,43 @@ func (c *Client) GetPubKeyString() string {
 	return c.pubKey.String()
 }
 
+func (c *Client) GetProgramID() solana.PublicKey {
+	return c.programKey
+}
+
+// Sign signs message with the node wallet, whose address identifies the node
+// on chain
+func (c *Client) Sign(message []byte) (solana.Signature, error) {
+	return c.wallet.Sign(message)
+}
+
+// NetworkState identifies the cluster and a recent point on it
+type NetworkState struct {
+	GenesisHash solana.Hash
+	Slot        uint64
+	Blockhash   solana.Hash
+}
+
+// GetNetworkState returns the genesis hash and latest blockhash, used to bind
+// attestation nonces to this cluster and to a recent slot
+func (c *Client) GetNetworkState(ctx types.Context) (*NetworkState, error) {
+	genesis, err := c.rpcClient.GetGenesisHash(ctx.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get genesis hash: %w", err)
+	}
+
+	recent, err := c.rpcClient.GetLatestBlockhash(ctx.Context(), rpc.CommitmentFinalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent blockhash: %w", err)
+	}
+
+	return &NetworkState{
+		GenesisHash: genesis,
+		Slot:        recent.Context.Slot,
+		Blockhash:   recent.Value.Blockhash,
+	}, nil
+}
+
 // CheckProgramExists checks if the program exists on the network
 func (c *Client) CheckProgramExists(ctx *types.Context) error {
 	accountInfo, err := c.rpcClient.GetAccountInfo(ctx.Context(), c.programKey)
@@ -130,14 +194,12 @@ func (c *Client) findProgramAddress(ctx types.Context, seeds [][]byte) (solana.P
 	return solana.FindProgramAddress(seeds, programKey)
 }
 
-// RegisterTEENode registers a new TEE node with the given public key and attestation
-func (c *Client) RegisterTEENode(ctx types.Context, pubkey, attestation []byte) (*solana.Signature, error) {
-	statePDA, _, err := c.findProgramAddress(ctx, [][]byte{
-		[]byte("state"),
-		c.wallet.PublicKey().Bytes(),
-	})
+// RegisterTEENode registers a new TEE node with the given public key and a
+// commitment to its attestation report, published at attestationURI
+func (c *Client) RegisterTEENode(ctx types.Context, pubkey []byte, reportHash [32]byte, measurement [48]byte, attestationURI string) (*solana.Signature, error) {
+	statePDA, err := c.teeStatePDA(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive state PDA: %w", err)
+		return nil, err
 	}
 
 	instructionData := make([]byte, 0)
@@ -151,10 +213,13 @@ func (c *Client) RegisterTEENode(ctx types.Context, pubkey, attestation []byte)
 	instructionData = append(instructionData, pubkeyLen...)
 	instructionData = append(instructionData, pubkey...)
 
-	attestationLen := make([]byte, 4)
-	binary.LittleEndian.PutUint32(attestationLen, uint32(len(attestation)))
-	instructionData = append(instructionData, attestationLen...)
-	instructionData = append(instructionData, attestation...)
+	instructionData = append(instructionData, reportHash[:]...)
+	instructionData = append(instructionData, measurement[:]...)
+
+	uriLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(uriLen, uint32(len(attestationURI)))
+	instructionData = append(instructionData, uriLen...)
+	instructionData = append(instructionData, []byte(attestationURI)...)
 
 	accounts := []*solana.AccountMeta{
 		{PublicKey: statePDA, IsSigner: false, IsWritable: true},
@@ -171,6 +236,134 @@ func (c *Client) RegisterTEENode(ctx types.Context, pubkey, attestation []byte)
 	return c.sendTransaction(ctx, []*solana.GenericInstruction{instruction})
 }
 
+// UpdateTEEAttestation replaces the key and attestation of an already
+// registered TEE node and refreshes its last_attested_at
+func (c *Client) UpdateTEEAttestation(ctx types.Context, pubkey []byte, reportHash [32]byte, measurement [48]byte, attestationURI string) (*solana.Signature, error) {
+	statePDA, err := c.teeStatePDA(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	instructionData := make([]byte, 0)
+
+	discriminator := calculateDiscriminator("global:update_tee_attestation")
+
+	instructionData = append(instructionData, discriminator...)
+
+	pubkeyLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pubkeyLen, uint32(len(pubkey)))
+	instructionData = append(instructionData, pubkeyLen...)
+	instructionData = append(instructionData, pubkey...)
+
+	instructionData = append(instructionData, reportHash[:]...)
+	instructionData = append(instructionData, measurement[:]...)
+
+	uriLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(uriLen, uint32(len(attestationURI)))
+	instructionData = append(instructionData, uriLen...)
+	instructionData = append(instructionData, []byte(attestationURI)...)
+
+	accounts := []*solana.AccountMeta{
+		{PublicKey: statePDA, IsSigner: false, IsWritable: true},
+		{PublicKey: c.wallet.PublicKey(), IsSigner: true, IsWritable: false},
+	}
+
+	instruction := &solana.GenericInstruction{
+		ProgID:        c.programKey,
+		AccountValues: accounts,
+		DataBytes:     instructionData,
+	}
+
+	return c.sendTransaction(ctx, []*solana.GenericInstruction{instruction})
+}
+
+// DeactivateTEENode retires this node
+func (c *Client) DeactivateTEENode(ctx types.Context) (*solana.Signature, error) {
+	return c.setTEEStatus(ctx, "global:deactivate_tee", c.wallet.PublicKey(), false)
+}
+
+// RevokeTEENode marks this node as compromised
+func (c *Client) RevokeTEENode(ctx types.Context) (*solana.Signature, error) {
+	return c.setTEEStatus(ctx, "global:revoke_tee", c.wallet.PublicKey(), false)
+}
+
+// GovernanceDeactivateTEENode retires the node with wallet address node. The
+// client must sign with the wallet of the governance authority, see
+// UseWallet.
+func (c *Client) GovernanceDeactivateTEENode(ctx types.Context, node solana.PublicKey) (*solana.Signature, error) {
+	return c.setTEEStatus(ctx, "global:deactivate_tee", node, true)
+}
+
+// GovernanceRevokeTEENode marks the node with wallet address node as
+// compromised, signed like GovernanceDeactivateTEENode
+func (c *Client) GovernanceRevokeTEENode(ctx types.Context, node solana.PublicKey) (*solana.Signature, error) {
+	return c.setTEEStatus(ctx, "global:revoke_tee", node, true)
+}
+
+// setTEEStatus changes the status of node, signed by the node itself or, with
+// governance, by the governance authority
+func (c *Client) setTEEStatus(ctx types.Context, instructionName string, node solana.PublicKey, governance bool) (*solana.Signature, error) {
+	statePDA, err := c.teeStatePDAOf(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	// a node signing for itself omits the optional governance account, which
+	// anchor expects as the program id
+	governanceAccount := c.programKey
+	if governance {
+		if governanceAccount, _, err = c.findProgramAddress(ctx, [][]byte{[]byte("governance")}); err != nil {
+			return nil, fmt.Errorf("failed to derive governance PDA: %w", err)
+		}
+	}
+
+	accounts := []*solana.AccountMeta{
+		{PublicKey: statePDA, IsSigner: false, IsWritable: true},
+		{PublicKey: governanceAccount, IsSigner: false, IsWritable: false},
+		{PublicKey: c.wallet.PublicKey(), IsSigner: true, IsWritable: false},
+	}
+
+	instruction := &solana.GenericInstruction{
+		ProgID:        c.programKey,
+		AccountValues: accounts,
+		DataBytes:     calculateDiscriminator(instructionName),
+	}
+
+	return c.sendTransaction(ctx, []*solana.GenericInstruction{instruction})
+}
+
+// IsTEENodeRegistered reports whether the state account of this node exists
+func (c *Client) IsTEENodeRegistered(ctx types.Context) (bool, error) {
+	statePDA, err := c.teeStatePDA(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = c.rpcClient.GetAccountInfo(ctx.Context(), statePDA)
+	if errors.Is(err, rpc.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch TEE state: %w", err)
+	}
+	return true, nil
+}
+
+func (c *Client) teeStatePDA(ctx types.Context) (solana.PublicKey, error) {
+	return c.teeStatePDAOf(ctx, c.wallet.PublicKey())
+}
+
+func (c *Client) teeStatePDAOf(ctx types.Context, signer solana.PublicKey) (solana.PublicKey, error) {
+	statePDA, _, err := c.findProgramAddress(ctx, [][]byte{
+		[]byte("state"),
+		signer.Bytes(),
+	})
+	if err != nil {
+		return solana.PublicKey{}, fmt.Errorf("failed to derive state PDA: %w", err)
+	}
+	return statePDA, nil
+}
+
 // sendTransaction sends a transaction to the network
 func (c *Client) sendTransaction(ctx types.Context, instructions []*solana.GenericInstruction) (*solana.Signature, error) {
 	recent, err := c.rpcClient.GetLatestBlockhash(ctx.Context(), rpc.CommitmentFinalized)
@@ -1,11 +1,13 @@
 package solana
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"log"
 
 	bin "github.com/gagliardetto/binary"
 	solanago "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/vitwit/healthlock/tee-client/types"
 )
 
@@ -29,6 +31,31 @@ func (c *Client) ReadHealthRecord(ctx types.Context, owner solanago.PublicKey, r
 	return record, nil
 }
 
+// ListHealthRecords reads every health record of the program
+func (c *Client) ListHealthRecords(ctx types.Context) ([]*HealthRecord, error) {
+	discriminator := sha256.Sum256([]byte("account:HealthRecord"))
+
+	accounts, err := c.rpcClient.GetProgramAccountsWithOpts(ctx.Context(), c.programKey, &rpc.GetProgramAccountsOpts{
+		Filters: []rpc.RPCFilter{
+			{Memcmp: &rpc.RPCFilterMemcmp{Offset: 0, Bytes: solanago.Base58(discriminator[:ANCHOR_DISCRIMINATOR_SIZE])}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list health records: %w", err)
+	}
+
+	var records []*HealthRecord
+	for _, account := range accounts {
+		record, err := decodeHealthRecord(account.Account.Data.GetBinary()[ANCHOR_DISCRIMINATOR_SIZE:])
+		if err != nil {
+			fmt.Printf("⚠️  Skipping undecodable health record %s: %v\n", account.Pubkey, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
 func decodeHealthRecord(data []byte) (*HealthRecord, error) {
 	var record HealthRecord
 	borshDec := bin.NewBorshDecoder(data)
@@ -0,0 +1,103 @@
+package solana
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+// MaxAttestationAge is how recently a node must have attested to be sent
+// records; the frontend uses the same bound
+const MaxAttestationAge = 24 * time.Hour
+
+func (s TEEStatus) String() string {
+	switch s {
+	case TEEStatusActive:
+		return "active"
+	case TEEStatusDeactivated:
+		return "deactivated"
+	case TEEStatusRevoked:
+		return "revoked"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// Usable tells whether records may be encrypted to the node: it must be
+// active and attested within maxAge
+func (s *TEEState) Usable(maxAge time.Duration, now time.Time) bool {
+	return s.IsInitialized && s.Status == TEEStatusActive &&
+		now.Sub(time.Unix(s.LastAttestedAt, 0)) <= maxAge
+}
+
+// GetTEEStatus reads the status of this node from its state account
+func (c *Client) GetTEEStatus(ctx types.Context) (TEEStatus, error) {
+	state, err := c.GetTEEState(ctx, c.wallet.PublicKey())
+	if err != nil {
+		return 0, err
+	}
+	return state.Status, nil
+}
+
+// GetTEEState reads the state account of the node registered by signer
+func (c *Client) GetTEEState(ctx types.Context, signer solanago.PublicKey) (*TEEState, error) {
+	statePDA, err := c.teeStatePDAOf(ctx, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	accountInfo, err := c.rpcClient.GetAccountInfo(ctx.Context(), statePDA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch TEE state: %w", err)
+	}
+
+	state, err := decodeTEEState(accountInfo.Value.Data.GetBinary()[ANCHOR_DISCRIMINATOR_SIZE:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode TEE state: %w", err)
+	}
+	return state, nil
+}
+
+// GetUsableTEENodes lists the registered nodes that are active and were
+// attested within maxAge, i.e. the nodes records should be encrypted to
+func (c *Client) GetUsableTEENodes(ctx types.Context, maxAge time.Duration) ([]*TEEState, error) {
+	discriminator := sha256.Sum256([]byte("account:TEEState"))
+
+	accounts, err := c.rpcClient.GetProgramAccountsWithOpts(ctx.Context(), c.programKey, &rpc.GetProgramAccountsOpts{
+		Filters: []rpc.RPCFilter{
+			{Memcmp: &rpc.RPCFilterMemcmp{Offset: 0, Bytes: solanago.Base58(discriminator[:ANCHOR_DISCRIMINATOR_SIZE])}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list TEE nodes: %w", err)
+	}
+
+	now := time.Now()
+	var nodes []*TEEState
+	for _, account := range accounts {
+		state, err := decodeTEEState(account.Account.Data.GetBinary()[ANCHOR_DISCRIMINATOR_SIZE:])
+		if err != nil {
+			fmt.Printf("⚠️  Skipping undecodable TEE state %s: %v\n", account.Pubkey, err)
+			continue
+		}
+		if state.Usable(maxAge, now) {
+			nodes = append(nodes, state)
+		}
+	}
+	return nodes, nil
+}
+
+func decodeTEEState(data []byte) (*TEEState, error) {
+	var state TEEState
+	borshDec := bin.NewBorshDecoder(data)
+	if err := borshDec.Decode(&state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
@@ -12,7 +12,7 @@ type AccessPermission struct {
 type HealthRecord struct {
 	Owner       solanago.PublicKey `borsh:"owner"`
 	RecordID    uint64             `borsh:"record_id"`
-	Checksum    string             `borsh:"checksum"`
+	Checksum    string             `borsh:"checksum"` // encrypted_data on chain: the IPFS CID of the envelope
 	CreatedAt   int64              `borsh:"created_at"`
 	AccessList  []AccessPermission `borsh:"access_list"`
 	MimeType    string             `borsh:"mime_type"`
@@ -20,3 +20,23 @@ type HealthRecord struct {
 	Description string             `borsh:"description"`
 	Title       string             `borsh:"title"`
 }
+
+// TEEStatus mirrors the TEEStatus enum of the program
+type TEEStatus uint8
+
+const (
+	TEEStatusActive TEEStatus = iota
+	TEEStatusDeactivated
+	TEEStatusRevoked
+)
+
+type TEEState struct {
+	Signer          solanago.PublicKey `borsh:"signer"`
+	Pubkey          []byte             `borsh:"pubkey"`
+	AttestationHash [32]byte           `borsh:"attestation_hash"`
+	Measurement     [48]byte           `borsh:"measurement"`
+	AttestationURI  string             `borsh:"attestation_uri"`
+	IsInitialized   bool               `borsh:"is_initialized"`
+	LastAttestedAt  int64              `borsh:"last_attested_at"`
+	Status          TEEStatus          `borsh:"status"`
+}
@@ -0,0 +1,359 @@
+// Package staging keeps uploaded files on the node's local disk until the
+// owner seals them into a record. The disk lies outside the trust boundary of
+// a confidential VM, so files are encrypted with a keys.StagingKey, readable
+// only by this TEE image on this machine, and shredded once retention ends.
+package staging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/internal/fsutil"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+const (
+	tempPrefix = ".upload-"
+	fileIDSize = 16
+)
+
+// magic starts every staged file, followed by its random file id and the
+// records of keys.StagingKey
+var magic = []byte("HLSTAGE1")
+
+// ErrUnknownFile is returned for files the store does not hold
+var ErrUnknownFile = errors.New("unknown staged file")
+
+// File is one staged upload, named after the sha256 of its content
+type File struct {
+	Owner    solanago.PublicKey
+	Checksum string
+	Path     string
+	Modified time.Time
+}
+
+// Store keeps staged files in dir/<owner>/<checksum>
+type Store struct {
+	dir string
+	key *keys.StagingKey
+}
+
+// OpenStore opens, or creates, the store in dir. Directories are made private
+// to this user, and files staged in plaintext by earlier versions are
+// encrypted in place.
+func OpenStore(dir string, key *keys.StagingKey) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	s := &Store{dir: dir, key: key}
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	owners, err := s.owners()
+	if err != nil {
+		return nil, err
+	}
+	for _, owner := range owners {
+		if err := os.Chmod(s.ownerDir(owner), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := s.encryptLegacy(f); err != nil {
+			return nil, fmt.Errorf("failed to encrypt staged file %s: %w", f.Path, err)
+		}
+	}
+	return s, nil
+}
+
+// Put stages content for owner as it is read, so memory use does not grow
+// with the file. It tells whether the file was staged already, in which case
+// it is kept, and its retention starts over.
+func (s *Store) Put(owner solanago.PublicKey, content io.Reader) (*File, bool, error) {
+	dir := s.ownerDir(owner)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, false, err
+	}
+
+	tmp, checksum, err := s.write(owner, content)
+	if err != nil {
+		return nil, false, err
+	}
+	// a no-op once the file is renamed
+	defer os.Remove(tmp)
+
+	path := filepath.Join(dir, checksum)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			return nil, false, err
+		}
+		return &File{Owner: owner, Checksum: checksum, Path: path, Modified: now}, true, nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, false, err
+	}
+	return &File{Owner: owner, Checksum: checksum, Path: path, Modified: time.Now()}, false, nil
+}
+
+// Open returns the content of the file owner staged with checksum. Reading
+// fails at the end if the content does not match the checksum.
+func (s *Store) Open(owner solanago.PublicKey, checksum string) (io.ReadCloser, error) {
+	if !validChecksum(checksum) {
+		return nil, ErrUnknownFile
+	}
+	f, err := os.Open(filepath.Join(s.ownerDir(owner), checksum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnknownFile
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(magic)+fileIDSize)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		f.Close()
+		return nil, errors.New("staged file is not encrypted")
+	}
+	content, err := s.key.NewReader(f, fileID(owner, header[len(magic):]))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &checkedReader{r: content, f: f, hash: sha256.New(), checksum: checksum}, nil
+}
+
+// Remove shreds the file owner staged with checksum
+func (s *Store) Remove(owner solanago.PublicKey, checksum string) error {
+	if !validChecksum(checksum) {
+		return ErrUnknownFile
+	}
+	return fsutil.Shred(filepath.Join(s.ownerDir(owner), checksum))
+}
+
+// List returns all staged files
+func (s *Store) List() ([]*File, error) {
+	owners, err := s.owners()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, owner := range owners {
+		entries, err := os.ReadDir(s.ownerDir(owner))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !validChecksum(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, &File{
+				Owner:    owner,
+				Checksum: entry.Name(),
+				Path:     filepath.Join(s.ownerDir(owner), entry.Name()),
+				Modified: info.ModTime(),
+			})
+		}
+	}
+	return files, nil
+}
+
+// Sweep shreds the files staged before cutoff, and temporary files left by
+// interrupted uploads, and returns how many files it removed
+func (s *Store) Sweep(cutoff time.Time) (int, error) {
+	files, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var errs []error
+	for _, f := range files {
+		if !f.Modified.Before(cutoff) {
+			continue
+		}
+		if err := fsutil.Shred(f.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	owners, err := s.owners()
+	if err != nil {
+		return removed, err
+	}
+	for _, owner := range owners {
+		temps, err := filepath.Glob(filepath.Join(s.ownerDir(owner), tempPrefix+"*"))
+		if err != nil {
+			return removed, err
+		}
+		for _, path := range temps {
+			info, err := os.Stat(path)
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			if err := fsutil.Shred(path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			removed++
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// write encrypts content into a temporary file in owner's directory and
+// returns its path and the checksum of content
+func (s *Store) write(owner solanago.PublicKey, content io.Reader) (string, string, error) {
+	id := make([]byte, fileIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	tmp, err := os.CreateTemp(s.ownerDir(owner), tempPrefix+"*")
+	if err != nil {
+		return "", "", err
+	}
+	checksum, err := s.encrypt(tmp, owner, id, content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), checksum, nil
+}
+
+func (s *Store) encrypt(f *os.File, owner solanago.PublicKey, id []byte, content io.Reader) (string, error) {
+	if _, err := f.Write(append(append([]byte{}, magic...), id...)); err != nil {
+		return "", err
+	}
+	sw, err := s.key.NewWriter(f, fileID(owner, id), 0)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(sw, hash), content); err != nil {
+		return "", err
+	}
+	if err := sw.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// encryptLegacy replaces a file staged in plaintext with its encryption, then
+// overwrites the plaintext
+func (s *Store) encryptLegacy(file *File) error {
+	f, err := os.OpenFile(file.Path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, header); err == nil && bytes.Equal(header, magic) {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp, _, err := s.write(file.Owner, f)
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, file.Modified, file.Modified); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, file.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// f still holds the plaintext, which no name points to anymore
+	return fsutil.Overwrite(f)
+}
+
+// owners returns the owners that have a directory in the store
+func (s *Store) owners() ([]solanago.PublicKey, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var owners []solanago.PublicKey
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		owner, err := solanago.PublicKeyFromBase58(entry.Name())
+		if err != nil || owner.String() != entry.Name() {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+func (s *Store) ownerDir(owner solanago.PublicKey) string {
+	return filepath.Join(s.dir, owner.String())
+}
+
+// fileID binds the key of a staged file to its owner
+func fileID(owner solanago.PublicKey, id []byte) string {
+	return "upload/" + owner.String() + "/" + hex.EncodeToString(id)
+}
+
+func validChecksum(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == sha256.Size && hex.EncodeToString(b) == name
+}
+
+// checkedReader compares the checksum of what it read with the expected one
+// at the end
+type checkedReader struct {
+	r        io.Reader
+	f        *os.File
+	hash     hash.Hash
+	checksum string
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && hex.EncodeToString(c.hash.Sum(nil)) != c.checksum {
+		return n, errors.New("staged file does not match its checksum")
+	}
+	return n, err
+}
+
+func (c *checkedReader) Close() error {
+	return c.f.Close()
+}
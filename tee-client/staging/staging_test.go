@@ -0,0 +1,179 @@
+package staging_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/staging"
+)
+
+func openStore(t *testing.T, dir string) *staging.Store {
+	key, err := keys.NewStagingKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := staging.OpenStore(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func read(t *testing.T, store *staging.Store, owner solanago.PublicKey, checksum string) ([]byte, error) {
+	r, err := store.Open(owner, checksum)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestStoreEncryptsFiles(t *testing.T) {
+	dir := t.TempDir()
+	store := openStore(t, dir)
+	owner := solanago.NewWallet().PublicKey()
+
+	content := make([]byte, keys.StagingRecordSize+500)
+	rand.Read(content)
+	copy(content, "%PDF-1.7 lab results")
+
+	f, existed, err := store.Put(owner, bytes.NewReader(content))
+	if err != nil || existed {
+		t.Fatalf("put: %v, existed %v", err, existed)
+	}
+	sum := sha256.Sum256(content)
+	if f.Checksum != hex.EncodeToString(sum[:]) || f.Path != filepath.Join(dir, owner.String(), f.Checksum) {
+		t.Fatalf("unexpected file %+v", f)
+	}
+
+	raw, err := os.ReadFile(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("lab results")) {
+		t.Fatal("staged file holds plaintext")
+	}
+	for path, mode := range map[string]os.FileMode{dir: 0700, filepath.Dir(f.Path): 0700, f.Path: 0600} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != mode {
+			t.Errorf("%s: mode %v, want %v", path, info.Mode().Perm(), mode)
+		}
+	}
+
+	if got, err := read(t, store, owner, f.Checksum); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("read back %d bytes: %v", len(got), err)
+	}
+	if _, existed, err := store.Put(owner, bytes.NewReader(content)); err != nil || !existed {
+		t.Errorf("second put: %v, existed %v", err, existed)
+	}
+
+	// a file moved to another owner or name does not read
+	other := solanago.NewWallet().PublicKey()
+	os.MkdirAll(filepath.Join(dir, other.String()), 0700)
+	os.WriteFile(filepath.Join(dir, other.String(), f.Checksum), raw, 0600)
+	if _, err := read(t, store, other, f.Checksum); err == nil {
+		t.Error("read a file moved to another owner")
+	}
+	g, _, err := store.Put(owner, bytes.NewReader([]byte("another file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(g.Path, raw, 0600)
+	if _, err := read(t, store, owner, g.Checksum); err == nil {
+		t.Error("read a file under another checksum")
+	}
+}
+
+func TestOpenStoreEncryptsLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	owner := solanago.NewWallet().PublicKey()
+	content := []byte("plaintext from an earlier version")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	path := filepath.Join(dir, owner.String(), checksum)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, content, 0644)
+	staged := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, staged, staged)
+
+	store := openStore(t, dir)
+
+	raw, err := os.ReadFile(path)
+	if err != nil || bytes.Contains(raw, content) {
+		t.Fatalf("legacy file not encrypted: %v", err)
+	}
+	if info, _ := os.Stat(filepath.Dir(path)); info.Mode().Perm() != 0700 {
+		t.Errorf("directory mode %v", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(staged) {
+		t.Errorf("retention restarted at %v", info.ModTime())
+	}
+	if got, err := read(t, store, owner, checksum); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestRemoveShredsFile(t *testing.T) {
+	store := openStore(t, t.TempDir())
+	owner := solanago.NewWallet().PublicKey()
+
+	f, _, err := store.Put(owner, bytes.NewReader([]byte("sealed already")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove(owner, f.Checksum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+		t.Errorf("%s was kept", f.Path)
+	}
+	if _, err := read(t, store, owner, f.Checksum); !errors.Is(err, staging.ErrUnknownFile) {
+		t.Errorf("opened removed file: %v", err)
+	}
+	if err := store.Remove(owner, "../../etc/passwd"); !errors.Is(err, staging.ErrUnknownFile) {
+		t.Errorf("removed outside the store: %v", err)
+	}
+}
+
+func TestSweepKeepsRecentFiles(t *testing.T) {
+	dir := t.TempDir()
+	store := openStore(t, dir)
+	owner := solanago.NewWallet().PublicKey()
+
+	put := func(content string, age time.Duration) *staging.File {
+		f, _, err := store.Put(owner, bytes.NewReader([]byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now().Add(-age)
+		os.Chtimes(f.Path, at, at)
+		return f
+	}
+	abandoned := put("never used", 48*time.Hour)
+	recent := put("just uploaded", time.Minute)
+	temp := filepath.Join(dir, owner.String(), ".upload-123")
+	os.WriteFile(temp, []byte("interrupted"), 0600)
+	os.Chtimes(temp, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+
+	removed, err := store.Sweep(time.Now().Add(-24 * time.Hour))
+	if err != nil || removed != 2 {
+		t.Fatalf("removed %d: %v", removed, err)
+	}
+	if _, err := os.Stat(recent.Path); err != nil {
+		t.Errorf("%s was removed", recent.Path)
+	}
+	for _, path := range []string{abandoned.Path, temp} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was kept", path)
+		}
+	}
+}
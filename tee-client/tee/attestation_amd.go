@@ -4,31 +4,45 @@
 package tee
 
 import (
-	"bytes"
 	"encoding/hex"
 	"fmt"
 
 	"github.com/google/go-sev-guest/client"
-	"github.com/google/go-sev-guest/verify"
+	"github.com/vitwit/healthlock/tee-client/types"
 )
 
-type AmdAttestor struct{}
+type AmdAttestor struct {
+	policy *Policy
+	certs  *CertCache
+}
+
+func NewAttestor(ctx *types.Context) (Attestor, error) {
+	cfg := ctx.GetConfig().Attestation
+
+	policy, err := NewPolicy(cfg.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation policy: %v", err)
+	}
 
-func NewAttestor() (Attestor, error) {
-	return &AmdAttestor{}, nil
+	certs, err := NewCertCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AmdAttestor{policy: policy, certs: certs}, nil
 }
 
-func (a *AmdAttestor) GenerateAttestationReport(nonce string) ([]byte, error) {
+func (a *AmdAttestor) GenerateAttestationReport(nonce string) (*Evidence, error) {
 	nonceBytes, err := hex.DecodeString(nonce)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode nonce: %v", err)
 	}
-	if len(nonceBytes) > 64 {
-		return nil, fmt.Errorf("nonce too long; must be <= 64 bytes")
+	if a.policy.NonceOffset+len(nonceBytes) > 64 {
+		return nil, fmt.Errorf("nonce too long; must fit report_data at offset %d", a.policy.NonceOffset)
 	}
 
 	var reportData [64]byte
-	copy(reportData[:], nonceBytes)
+	copy(reportData[a.policy.NonceOffset:], nonceBytes)
 
 	dev, err := client.OpenDevice()
 	if err != nil {
@@ -36,33 +50,45 @@ func (a *AmdAttestor) GenerateAttestationReport(nonce string) ([]byte, error) {
 	}
 	defer dev.Close()
 
-	report, err := client.GetRawReport(dev, reportData)
+	report, certTable, err := client.GetRawExtendedReport(dev, reportData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get attestation report: %v", err)
 	}
 
-	return report, nil
-}
-
-func (a *AmdAttestor) VerifyAttestationReport(report []byte, expectedNonce string) error {
-	options := verify.DefaultOptions()
-	if err := verify.RawSnpReport(report, options); err != nil {
-		return fmt.Errorf("attestation signature verification failed: %v", err)
+	if err := a.certs.AddCertTable(report, certTable); err != nil {
+		fmt.Printf("⚠️  Failed to cache host certificates: %v\n", err)
 	}
 
+	return &Evidence{Report: report, CertTable: certTable}, nil
+}
+
+func (a *AmdAttestor) VerifyAttestationReport(evidence *Evidence, expectedNonce string) error {
 	nonceBytes, err := hex.DecodeString(expectedNonce)
 	if err != nil {
 		return fmt.Errorf("invalid nonce format: %v", err)
 	}
-	if len(report) < 128 {
-		return fmt.Errorf("attestation report too short")
+
+	return VerifySNPReport(evidence.Report, evidence.CertTable, a.certs.VerifyOptions(), a.policy, nonceBytes)
+}
+
+// SealingKey asks the AMD secure processor for a key derived from the chip's
+// VCEK, mixed with the launch measurement and guest policy of this VM
+func (a *AmdAttestor) SealingKey() ([]byte, error) {
+	dev, err := client.OpenDevice()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open /dev/sev-guest: %v", err)
 	}
+	defer dev.Close()
 
-	reportData := report[64:128]
-	for i := 0; i <= 64-len(nonceBytes); i++ {
-		if bytes.Equal(reportData[i:i+len(nonceBytes)], nonceBytes) {
-			return nil
-		}
+	resp, err := client.GetDerivedKeyAcknowledgingItsLimitations(dev, &client.SnpDerivedKeyReq{
+		UseVCEK:          true,
+		GuestFieldSelect: client.GuestFieldSelect{Measurement: true, GuestPolicy: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sealing key: %v", err)
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("failed to derive sealing key: status %d", resp.Status)
 	}
-	return fmt.Errorf("nonce hash not found in report_data")
+	return resp.Data[:], nil
 }
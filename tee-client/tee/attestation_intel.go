@@ -3,18 +3,26 @@
 
 package tee
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/vitwit/healthlock/tee-client/types"
+)
 
 type IntelAttestor struct{}
 
-func NewAttestor() (Attestor, error) {
+func NewAttestor(ctx *types.Context) (Attestor, error) {
 	return &IntelAttestor{}, nil
 }
 
-func (i *IntelAttestor) GenerateAttestationReport(nonce string) ([]byte, error) {
+func (i *IntelAttestor) GenerateAttestationReport(nonce string) (*Evidence, error) {
 	return nil, fmt.Errorf("Intel TDX attestation not implemented yet")
 }
 
-func (i *IntelAttestor) VerifyAttestationReport(report []byte, expectedNonce string) error {
+func (i *IntelAttestor) VerifyAttestationReport(evidence *Evidence, expectedNonce string) error {
 	return fmt.Errorf("Intel TDX attestation verification not implemented yet")
 }
+
+func (i *IntelAttestor) SealingKey() ([]byte, error) {
+	return nil, fmt.Errorf("Intel TDX sealing not implemented yet")
+}
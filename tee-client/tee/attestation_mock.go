@@ -4,28 +4,77 @@
 package tee
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+
+	"github.com/vitwit/healthlock/tee-client/types"
 )
 
-type MockAttestor struct{}
+// MockAttestor emits SNP-format reports signed by a test chain, so everything
+// downstream of the hardware runs as it would in production
+type MockAttestor struct {
+	policy *Policy
+	signer *TestSigner
+}
+
+func NewAttestor(ctx *types.Context) (Attestor, error) {
+	cfg := ctx.GetConfig()
+	if cfg.Solana.NetworkType == "mainnet" {
+		return nil, errors.New("mock attestor must not be used on mainnet; build with -tags amd")
+	}
+
+	policy, err := NewPolicy(cfg.Attestation.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation policy: %v", err)
+	}
+
+	signer, err := NewTestSigner(policy)
+	if err != nil {
+		return nil, err
+	}
 
-func NewAttestor() (Attestor, error) {
-	return &MockAttestor{}, nil
+	return &MockAttestor{policy: policy, signer: signer}, nil
 }
 
-func (d *MockAttestor) GenerateAttestationReport(nonce string) ([]byte, error) {
-	// Return 256 bytes of random data
-	randomBytes := make([]byte, 256)
-	_, err := rand.Read(randomBytes)
+func (d *MockAttestor) GenerateAttestationReport(nonce string) (*Evidence, error) {
+	nonceBytes, err := hex.DecodeString(nonce)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate random report: %v", err)
+		return nil, fmt.Errorf("failed to decode nonce: %v", err)
+	}
+	if d.policy.NonceOffset+len(nonceBytes) > reportDataSize {
+		return nil, fmt.Errorf("nonce too long; must fit report_data at offset %d", d.policy.NonceOffset)
 	}
-	fmt.Println("🧪 [Mock] Returning random attestation report")
-	return randomBytes, nil
+
+	var reportData [reportDataSize]byte
+	copy(reportData[d.policy.NonceOffset:], nonceBytes)
+
+	report, err := d.signer.SignReport(reportData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign mock report: %v", err)
+	}
+	certTable, err := d.signer.CertTable()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("🧪 [Mock] Returning test-signed SNP attestation report")
+	return &Evidence{Report: report, CertTable: certTable}, nil
+}
+
+func (d *MockAttestor) VerifyAttestationReport(evidence *Evidence, expectedNonce string) error {
+	nonceBytes, err := hex.DecodeString(expectedNonce)
+	if err != nil {
+		return fmt.Errorf("invalid nonce format: %v", err)
+	}
+
+	return VerifySNPReport(evidence.Report, evidence.CertTable, d.signer.VerifyOptions(), d.policy, nonceBytes)
 }
 
-func (d *MockAttestor) VerifyAttestationReport(report []byte, expectedNonce string) error {
-	fmt.Println("✅ [Mock] Attestation always verified successfully")
-	return nil
+// SealingKey returns a fixed key shared by all mock nodes, so sealed state
+// survives restarts in development but protects nothing
+func (d *MockAttestor) SealingKey() ([]byte, error) {
+	key := sha256.Sum256([]byte("healthlock mock sealing key"))
+	return key[:], nil
 }
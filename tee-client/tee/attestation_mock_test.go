@@ -0,0 +1,77 @@
+//go:build mock
+// +build mock
+
+package tee_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/tee"
+	"github.com/vitwit/healthlock/tee-client/types"
+)
+
+func newMockAttestor(t *testing.T, cfg *config.Config) tee.Attestor {
+	attestor, err := tee.NewAttestor(types.NewContext().WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attestor
+}
+
+func TestMockAttestorRoundTrip(t *testing.T) {
+	strict := defaultPolicyConfig()
+	strict.MinimumGuestSVN = 3
+	strict.MinimumTCB = config.TCBConfig{BlSpl: 2, TeeSpl: 1, SnpSpl: 8, UcodeSpl: 115}
+	strict.FamilyID = hex.EncodeToString(make([]byte, 16))
+	strict.NonceOffset = 16
+
+	configs := map[string]config.AttestationPolicyConfig{
+		"default": {},
+		"strict":  strict,
+	}
+
+	nonce := hex.EncodeToString(testNonce)
+	for name, policy := range configs {
+		t.Run(name, func(t *testing.T) {
+			attestor := newMockAttestor(t, &config.Config{Attestation: config.AttestationConfig{Policy: policy}})
+
+			evidence, err := attestor.GenerateAttestationReport(nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := attestor.VerifyAttestationReport(evidence, nonce); err != nil {
+				t.Fatalf("expected mock report to verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestMockAttestorRejects(t *testing.T) {
+	attestor := newMockAttestor(t, &config.Config{})
+	nonce := hex.EncodeToString(testNonce)
+
+	evidence, err := attestor.GenerateAttestationReport(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := attestor.VerifyAttestationReport(evidence, hex.EncodeToString(make([]byte, 32))); err == nil {
+		t.Error("expected report to be rejected for another nonce")
+	}
+
+	tampered := &tee.Evidence{Report: append([]byte(nil), evidence.Report...), CertTable: evidence.CertTable}
+	tampered.Report[0x90] ^= 0xff // measurement
+	if err := attestor.VerifyAttestationReport(tampered, nonce); err == nil {
+		t.Error("expected tampered report to be rejected")
+	}
+
+}
+
+func TestMockAttestorRefusesMainnet(t *testing.T) {
+	cfg := &config.Config{Solana: config.SolanaConfig{NetworkType: "mainnet"}}
+	if _, err := tee.NewAttestor(types.NewContext().WithConfig(cfg)); err == nil {
+		t.Fatal("expected mock attestor to refuse mainnet")
+	}
+}
@@ -0,0 +1,191 @@
+package tee
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+	"github.com/vitwit/healthlock/tee-client/config"
+)
+
+// CertCache is a local copy of the AMD KDS artifacts needed to verify SEV-SNP
+// reports: ASK/ARK chains, VCEKs and CRLs. Files are laid out by KDS URL, e.g.
+//
+//	<dir>/kdsintf.amd.com/vcek/v1/Milan/cert_chain
+//	<dir>/kdsintf.amd.com/vcek/v1/Milan/crl
+//	<dir>/kdsintf.amd.com/vcek/v1/Milan/<hwid>@blSPL=..&teeSPL=..&snpSPL=..&ucodeSPL=..
+//
+// so a directory populated on a connected machine can be copied as is into an
+// air-gapped deployment. CertCache implements trust.HTTPSGetter.
+type CertCache struct {
+	dir              string
+	offline          bool
+	checkRevocations bool
+	network          trust.HTTPSGetter
+	now              func() time.Time
+}
+
+// NewCertCache opens (and creates) the cache directory configured in
+// [attestation]
+func NewCertCache(cfg config.AttestationConfig) (*CertCache, error) {
+	if cfg.CertCacheDir == "" {
+		return nil, errors.New("attestation cert-cache-dir is not set")
+	}
+	if err := os.MkdirAll(cfg.CertCacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cert cache: %w", err)
+	}
+
+	c := &CertCache{
+		dir:              cfg.CertCacheDir,
+		offline:          cfg.Offline,
+		checkRevocations: cfg.CheckRevocations,
+		now:              time.Now,
+	}
+	if !cfg.Offline {
+		c.network = trust.DefaultHTTPSGetter()
+	}
+	return c, nil
+}
+
+// VerifyOptions returns go-sev-guest verification options that resolve
+// certificates and CRLs through the cache
+func (c *CertCache) VerifyOptions() *verify.Options {
+	opts := verify.DefaultOptions()
+	opts.Getter = c
+	opts.CheckRevocations = c.checkRevocations
+	opts.Now = c.now()
+	return opts
+}
+
+// Get returns the cached artifact for a KDS url. In online mode misses are
+// fetched and stored, and CRLs are always refreshed when the network allows.
+func (c *CertCache) Get(kdsURL string) ([]byte, error) {
+	path, err := c.path(kdsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.network != nil && isCRL(kdsURL) {
+		if data, err := c.network.Get(kdsURL); err == nil {
+			return data, c.write(path, data)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if isCRL(kdsURL) {
+			if err := c.checkCRLFresh(data); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if c.network == nil {
+		return nil, fmt.Errorf("%s not in offline cert cache", kdsURL)
+	}
+	data, err = c.network.Get(kdsURL)
+	if err != nil {
+		return nil, err
+	}
+	return data, c.write(path, data)
+}
+
+// AddCertTable stores the certificates returned with an extended report so
+// that later verifications of this chip's reports need no network access
+func (c *CertCache) AddCertTable(report, certTable []byte) error {
+	if len(certTable) == 0 {
+		return nil
+	}
+
+	proto, err := abi.ReportToProto(report)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation report: %v", err)
+	}
+
+	certs := new(abi.CertTable)
+	if err := certs.Unmarshal(certTable); err != nil {
+		return fmt.Errorf("failed to parse certificate table: %v", err)
+	}
+	chain := certs.Proto()
+	if len(chain.GetVcekCert()) == 0 {
+		return nil
+	}
+
+	vcek, err := x509.ParseCertificate(chain.GetVcekCert())
+	if err != nil {
+		return fmt.Errorf("failed to parse VCEK: %v", err)
+	}
+	exts, err := kds.VcekCertificateExtensions(vcek)
+	if err != nil {
+		return fmt.Errorf("failed to read VCEK extensions: %v", err)
+	}
+	productLine := kds.ProductLineOfProductName(exts.ProductName)
+
+	vcekURL := kds.VCEKCertURL(productLine, proto.GetChipId(), kds.TCBVersion(proto.GetReportedTcb()))
+	if err := c.store(vcekURL, chain.GetVcekCert()); err != nil {
+		return err
+	}
+
+	if len(chain.GetAskCert()) == 0 || len(chain.GetArkCert()) == 0 {
+		return nil
+	}
+	var bundle []byte
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.GetAskCert()})...)
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.GetArkCert()})...)
+	return c.store(kds.ProductCertChainURL(abi.VcekReportSigner, productLine), bundle)
+}
+
+func (c *CertCache) store(kdsURL string, data []byte) error {
+	path, err := c.path(kdsURL)
+	if err != nil {
+		return err
+	}
+	return c.write(path, data)
+}
+
+func (c *CertCache) write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cert cache directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (c *CertCache) path(kdsURL string) (string, error) {
+	u, err := url.Parse(kdsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid KDS url %q: %w", kdsURL, err)
+	}
+	name := filepath.Join(u.Host, filepath.FromSlash(filepath.Clean("/"+u.Path)))
+	if u.RawQuery != "" {
+		name += "@" + u.RawQuery
+	}
+	return filepath.Join(c.dir, name), nil
+}
+
+func (c *CertCache) checkCRLFresh(data []byte) error {
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("invalid cached CRL: %w", err)
+	}
+	if c.now().After(crl.NextUpdate) {
+		return fmt.Errorf("cached CRL expired at %s", crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func isCRL(kdsURL string) bool {
+	return filepath.Base(kdsURL) == "crl"
+}
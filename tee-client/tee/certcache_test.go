@@ -0,0 +1,124 @@
+package tee_test
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sgtest "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+func newOfflineCache(t *testing.T, checkRevocations bool) (*tee.CertCache, string) {
+	dir := t.TempDir()
+	cache, err := tee.NewCertCache(config.AttestationConfig{
+		CertCacheDir:     dir,
+		Offline:          true,
+		CheckRevocations: checkRevocations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, dir
+}
+
+// cachedVerifyOptions resolves certificates through the cache but trusts the
+// test ARK instead of AMD's
+func cachedVerifyOptions(t *testing.T, cache *tee.CertCache, signer *sgtest.AmdSigner) *verify.Options {
+	opts := cache.VerifyOptions()
+	root := trust.AMDRootCertsProduct(sgtest.GetProductLine())
+	root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: signer.Ask}
+	opts.TrustedRoots = map[string][]*trust.AMDRootCerts{sgtest.GetProductLine(): {root}}
+	opts.Product = sgtest.GetProduct(t)
+	return opts
+}
+
+func writeCRL(t *testing.T, dir string, signer *sgtest.AmdSigner, nextUpdate time.Time) {
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: nextUpdate.Add(-48 * time.Hour),
+		NextUpdate: nextUpdate,
+	}, signer.Ark, signer.Keys.Ark)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signer.Ask.CRLDistributionPoints) == 0 {
+		t.Fatal("test ASK has no CRL distribution point")
+	}
+	u, err := url.Parse(signer.Ask.CRLDistributionPoints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, u.Host, filepath.FromSlash(u.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, crl, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertCacheVerifiesWithoutCertTable(t *testing.T) {
+	signer := newTestSigner(t)
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := tee.NewPolicy(defaultPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := sampleReport(t, signer, nil)
+
+	cache, _ := newOfflineCache(t, false)
+
+	if err := tee.VerifySNPReport(report, nil, cachedVerifyOptions(t, cache, signer), policy, testNonce); err == nil {
+		t.Fatal("expected verification to fail with an empty offline cache")
+	}
+
+	if err := cache.AddCertTable(report, certs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tee.VerifySNPReport(report, nil, cachedVerifyOptions(t, cache, signer), policy, testNonce); err != nil {
+		t.Fatalf("expected verification from cache to succeed: %v", err)
+	}
+}
+
+func TestCertCacheRevocationCheck(t *testing.T) {
+	signer := newTestSigner(t)
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := tee.NewPolicy(defaultPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := sampleReport(t, signer, nil)
+
+	cache, dir := newOfflineCache(t, true)
+
+	if err := tee.VerifySNPReport(report, certs, cachedVerifyOptions(t, cache, signer), policy, testNonce); err == nil {
+		t.Fatal("expected verification to fail without a cached CRL")
+	}
+
+	writeCRL(t, dir, signer, time.Now().Add(-time.Hour))
+	if err := tee.VerifySNPReport(report, certs, cachedVerifyOptions(t, cache, signer), policy, testNonce); err == nil {
+		t.Fatal("expected verification to fail with an expired CRL")
+	}
+
+	writeCRL(t, dir, signer, time.Now().Add(24*time.Hour))
+	if err := tee.VerifySNPReport(report, certs, cachedVerifyOptions(t, cache, signer), policy, testNonce); err != nil {
+		t.Fatalf("expected verification with a fresh CRL to succeed: %v", err)
+	}
+}
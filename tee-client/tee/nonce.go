@@ -0,0 +1,174 @@
+package tee
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NonceVersion is the version of the attestation nonce encoding produced by
+// NonceInput.Encode
+const NonceVersion uint8 = 1
+
+var nonceDomain = []byte("healthlock-tee-nonce")
+
+// NonceInput is everything an attestation nonce binds. The nonce placed in
+// report_data is sha256 over the canonical encoding:
+//
+//	"healthlock-tee-nonce" || version:u8
+//	|| program_id:[32] || genesis_hash:[32] || slot:u64 || blockhash:[32]
+//	|| address:[32] || pubkey:vec
+//	|| binary_hash:[32] || config_hash:[32]
+//	|| version:string || client_nonce:vec
+//
+// Integers are little endian, vec and string are prefixed with their length
+// as u32 little endian, matching borsh. Test vectors are in
+// testdata/nonce_vectors.json.
+type NonceInput struct {
+	ProgramID   [32]byte
+	GenesisHash [32]byte
+	Slot        uint64
+	Blockhash   [32]byte
+
+	Address   [32]byte // node wallet
+	PublicKey []byte   // node encryption key as registered on chain
+
+	BinaryHash [32]byte // sha256 of the running executable
+	ConfigHash [32]byte // sha256 of the config file
+	Version    string
+
+	ClientNonce []byte // empty for registration
+}
+
+// Encode returns the canonical encoding of the input
+func (in *NonceInput) Encode() []byte {
+	var buf []byte
+	buf = append(buf, nonceDomain...)
+	buf = append(buf, NonceVersion)
+	buf = append(buf, in.ProgramID[:]...)
+	buf = append(buf, in.GenesisHash[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, in.Slot)
+	buf = append(buf, in.Blockhash[:]...)
+	buf = append(buf, in.Address[:]...)
+	buf = appendVec(buf, in.PublicKey)
+	buf = append(buf, in.BinaryHash[:]...)
+	buf = append(buf, in.ConfigHash[:]...)
+	buf = appendVec(buf, []byte(in.Version))
+	buf = appendVec(buf, in.ClientNonce)
+	return buf
+}
+
+// Nonce returns the hex encoded sha256 of the canonical encoding
+func (in *NonceInput) Nonce() string {
+	hash := sha256.Sum256(in.Encode())
+	return hex.EncodeToString(hash[:])
+}
+
+func appendVec(buf, data []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// nonceInputJSON is the wire form of NonceInput, every byte field hex encoded
+type nonceInputJSON struct {
+	ProgramID   string `json:"program_id"`
+	GenesisHash string `json:"genesis_hash"`
+	Slot        uint64 `json:"slot,string"`
+	Blockhash   string `json:"blockhash"`
+	Address     string `json:"address"`
+	PublicKey   string `json:"pubkey"`
+	BinaryHash  string `json:"binary_hash"`
+	ConfigHash  string `json:"config_hash"`
+	Version     string `json:"version"`
+	ClientNonce string `json:"client_nonce"`
+}
+
+func (in NonceInput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nonceInputJSON{
+		ProgramID:   hex.EncodeToString(in.ProgramID[:]),
+		GenesisHash: hex.EncodeToString(in.GenesisHash[:]),
+		Slot:        in.Slot,
+		Blockhash:   hex.EncodeToString(in.Blockhash[:]),
+		Address:     hex.EncodeToString(in.Address[:]),
+		PublicKey:   hex.EncodeToString(in.PublicKey),
+		BinaryHash:  hex.EncodeToString(in.BinaryHash[:]),
+		ConfigHash:  hex.EncodeToString(in.ConfigHash[:]),
+		Version:     in.Version,
+		ClientNonce: hex.EncodeToString(in.ClientNonce),
+	})
+}
+
+func (in *NonceInput) UnmarshalJSON(data []byte) error {
+	var raw nonceInputJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	fixed := []struct {
+		name  string
+		value string
+		dst   *[32]byte
+	}{
+		{"program_id", raw.ProgramID, &in.ProgramID},
+		{"genesis_hash", raw.GenesisHash, &in.GenesisHash},
+		{"blockhash", raw.Blockhash, &in.Blockhash},
+		{"address", raw.Address, &in.Address},
+		{"binary_hash", raw.BinaryHash, &in.BinaryHash},
+		{"config_hash", raw.ConfigHash, &in.ConfigHash},
+	}
+	for _, f := range fixed {
+		b, err := hex.DecodeString(f.value)
+		if err != nil || len(b) != 32 {
+			return fmt.Errorf("%s must be 32 hex encoded bytes", f.name)
+		}
+		copy(f.dst[:], b)
+	}
+
+	var err error
+	if in.PublicKey, err = hex.DecodeString(raw.PublicKey); err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	if in.ClientNonce, err = hex.DecodeString(raw.ClientNonce); err != nil {
+		return fmt.Errorf("invalid client_nonce: %w", err)
+	}
+	in.Slot = raw.Slot
+	in.Version = raw.Version
+	return nil
+}
+
+// MeasureSoftware hashes the running executable and the config file, to be
+// bound into NonceInput
+func MeasureSoftware(configPath string) (binaryHash, configHash [32]byte, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return binaryHash, configHash, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if binaryHash, err = hashFile(exe); err != nil {
+		return binaryHash, configHash, err
+	}
+	if configHash, err = hashFile(configPath); err != nil {
+		return binaryHash, configHash, err
+	}
+	return binaryHash, configHash, nil
+}
+
+func hashFile(path string) ([32]byte, error) {
+	var sum [32]byte
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
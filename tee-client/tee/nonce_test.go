@@ -0,0 +1,146 @@
+package tee_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+var updateVectors = flag.Bool("update", false, "regenerate testdata/nonce_vectors.json")
+
+const nonceVectorsPath = "testdata/nonce_vectors.json"
+
+// nonceVector is one entry of testdata/nonce_vectors.json, shared with the
+// TS client so both sides derive the same nonce
+type nonceVector struct {
+	Name     string         `json:"name"`
+	Input    tee.NonceInput `json:"input"`
+	Encoding string         `json:"encoding"` // hex
+	Nonce    string         `json:"nonce"`    // hex
+}
+
+func fill(b byte) [32]byte {
+	var out [32]byte
+	for i := range out {
+		out[i] = b
+	}
+	return out
+}
+
+func vectorInputs() []nonceVector {
+	registration := tee.NonceInput{
+		ProgramID:   fill(0x01),
+		GenesisHash: fill(0x02),
+		Slot:        123456789,
+		Blockhash:   fill(0x03),
+		Address:     fill(0x04),
+		PublicKey:   []byte("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA"),
+		BinaryHash:  fill(0x05),
+		ConfigHash:  fill(0x06),
+		Version:     "v1.0.0",
+	}
+
+	request := registration
+	request.Slot = 1<<64 - 1
+	request.ClientNonce = bytes.Repeat([]byte{0xab}, 32)
+
+	return []nonceVector{
+		{Name: "empty", Input: tee.NonceInput{}},
+		{Name: "registration", Input: registration},
+		{Name: "request with client nonce", Input: request},
+	}
+}
+
+func TestNonceVectors(t *testing.T) {
+	if *updateVectors {
+		vectors := vectorInputs()
+		for i := range vectors {
+			vectors[i].Encoding = hex.EncodeToString(vectors[i].Input.Encode())
+			vectors[i].Nonce = vectors[i].Input.Nonce()
+		}
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(nonceVectorsPath, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.FromSlash(nonceVectorsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []nonceVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			if got := hex.EncodeToString(v.Input.Encode()); got != v.Encoding {
+				t.Errorf("encoding mismatch\n got: %s\nwant: %s", got, v.Encoding)
+			}
+			if got := v.Input.Nonce(); got != v.Nonce {
+				t.Errorf("nonce mismatch: got %s, want %s", got, v.Nonce)
+			}
+		})
+	}
+}
+
+func TestNonceBindsEveryField(t *testing.T) {
+	base := vectorInputs()[2].Input
+	mutations := map[string]func(*tee.NonceInput){
+		"program id":   func(in *tee.NonceInput) { in.ProgramID[0] ^= 1 },
+		"genesis hash": func(in *tee.NonceInput) { in.GenesisHash[0] ^= 1 },
+		"slot":         func(in *tee.NonceInput) { in.Slot-- },
+		"blockhash":    func(in *tee.NonceInput) { in.Blockhash[0] ^= 1 },
+		"address":      func(in *tee.NonceInput) { in.Address[0] ^= 1 },
+		"pubkey":       func(in *tee.NonceInput) { in.PublicKey = in.PublicKey[1:] },
+		"binary hash":  func(in *tee.NonceInput) { in.BinaryHash[0] ^= 1 },
+		"config hash":  func(in *tee.NonceInput) { in.ConfigHash[0] ^= 1 },
+		"version":      func(in *tee.NonceInput) { in.Version = "v1.0.1" },
+		"client nonce": func(in *tee.NonceInput) { in.ClientNonce = in.ClientNonce[1:] },
+	}
+
+	for name, mutate := range mutations {
+		t.Run(name, func(t *testing.T) {
+			in := base
+			in.PublicKey = bytes.Clone(base.PublicKey)
+			in.ClientNonce = bytes.Clone(base.ClientNonce)
+			mutate(&in)
+			if in.Nonce() == base.Nonce() {
+				t.Error("nonce did not change")
+			}
+		})
+	}
+}
+
+func TestNonceInputJSONRoundTrip(t *testing.T) {
+	in := vectorInputs()[2].Input
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out tee.NonceInput
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Nonce() != in.Nonce() {
+		t.Error("nonce changed across JSON round trip")
+	}
+
+	if err := json.Unmarshal([]byte(`{"program_id":"0102"}`), &out); err == nil {
+		t.Error("expected short program_id to be rejected")
+	}
+}
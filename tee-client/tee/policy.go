@@ -0,0 +1,118 @@
+package tee
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-sev-guest/validate"
+	"github.com/vitwit/healthlock/tee-client/config"
+)
+
+const reportDataSize = 64
+
+// Policy is the set of expectations a SEV-SNP attestation report must meet
+// on top of a valid signature
+type Policy struct {
+	// Measurements lists acceptable launch measurements, empty accepts any
+	Measurements [][]byte
+	// NonceOffset is where the attestation nonce starts in report_data
+	NonceOffset int
+
+	options validate.Options
+}
+
+// NewPolicy builds a Policy from the [attestation.policy] config section
+func NewPolicy(cfg config.AttestationPolicyConfig) (*Policy, error) {
+	p := &Policy{
+		NonceOffset: cfg.NonceOffset,
+		options: validate.Options{
+			GuestPolicy: abi.SnpPolicy{
+				Debug: cfg.AllowDebug,
+				SMT:   cfg.AllowSMT,
+			},
+			MinimumGuestSvn: cfg.MinimumGuestSVN,
+			MinimumTCB: kds.TCBParts{
+				BlSpl:    cfg.MinimumTCB.BlSpl,
+				TeeSpl:   cfg.MinimumTCB.TeeSpl,
+				SnpSpl:   cfg.MinimumTCB.SnpSpl,
+				UcodeSpl: cfg.MinimumTCB.UcodeSpl,
+			},
+			VMPL: cfg.VMPL,
+		},
+	}
+
+	if p.NonceOffset < 0 || p.NonceOffset >= reportDataSize {
+		return nil, fmt.Errorf("nonce-offset must be within report_data (0-%d)", reportDataSize-1)
+	}
+
+	for _, m := range cfg.Measurements {
+		measurement, err := hex.DecodeString(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid measurement %q: %w", m, err)
+		}
+		if len(measurement) != abi.MeasurementSize {
+			return nil, fmt.Errorf("measurement %q must be %d bytes", m, abi.MeasurementSize)
+		}
+		p.Measurements = append(p.Measurements, measurement)
+	}
+
+	var err error
+	if p.options.FamilyID, err = decodeOptionalID("family-id", cfg.FamilyID); err != nil {
+		return nil, err
+	}
+	if p.options.ImageID, err = decodeOptionalID("image-id", cfg.ImageID); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func decodeOptionalID(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if len(id) != abi.FamilyIDSize {
+		return nil, fmt.Errorf("%s must be %d bytes", name, abi.FamilyIDSize)
+	}
+	return id, nil
+}
+
+// Check validates an attestation whose signature has already been verified.
+// The nonce must sit at exactly NonceOffset in report_data with the rest of
+// report_data zeroed.
+func (p *Policy) Check(attestation *spb.Attestation, nonce []byte) error {
+	if p.NonceOffset+len(nonce) > reportDataSize {
+		return fmt.Errorf("nonce of %d bytes does not fit report_data at offset %d", len(nonce), p.NonceOffset)
+	}
+
+	if len(p.Measurements) > 0 {
+		measurement := attestation.GetReport().GetMeasurement()
+		trusted := false
+		for _, m := range p.Measurements {
+			if bytes.Equal(m, measurement) {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return fmt.Errorf("untrusted launch measurement %s", hex.EncodeToString(measurement))
+		}
+	}
+
+	opts := p.options
+	opts.ReportData = make([]byte, reportDataSize)
+	copy(opts.ReportData[p.NonceOffset:], nonce)
+
+	if err := validate.SnpAttestation(attestation, &opts); err != nil {
+		return fmt.Errorf("attestation policy check failed: %w", err)
+	}
+	return nil
+}
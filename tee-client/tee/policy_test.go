@@ -0,0 +1,215 @@
+package tee_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	sgtest "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+	"github.com/vitwit/healthlock/tee-client/config"
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+var (
+	testNonce       = bytes.Repeat([]byte{0xab}, 32)
+	testMeasurement = bytes.Repeat([]byte{0x42}, abi.MeasurementSize)
+)
+
+func newTestSigner(t *testing.T) *sgtest.AmdSigner {
+	signer, err := sgtest.DefaultTestOnlyCertChain(sgtest.GetProductName(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create test cert chain: %v", err)
+	}
+	return signer
+}
+
+// sampleReport returns a signed report that satisfies a default policy,
+// after applying mutate
+func sampleReport(t *testing.T, signer *sgtest.AmdSigner, mutate func(*spb.Report)) []byte {
+	var reportData [64]byte
+	copy(reportData[:], testNonce)
+	raw := sgtest.TestRawReport(reportData)
+
+	report, err := abi.ReportToProto(raw[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.Policy = abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: true})
+	report.Measurement = testMeasurement
+	if mutate != nil {
+		mutate(report)
+	}
+
+	out, err := abi.ReportToAbiBytes(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := signer.Sign(abi.SignedComponent(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := abi.SetSignature(r, s, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func verifyOptions(t *testing.T, signer *sgtest.AmdSigner) *verify.Options {
+	root := trust.AMDRootCertsProduct(sgtest.GetProductLine())
+	root.ProductCerts = &trust.ProductCerts{Ark: signer.Ark, Ask: signer.Ask}
+	return &verify.Options{
+		DisableCertFetching: true,
+		Now:                 time.Now(),
+		Product:             sgtest.GetProduct(t),
+		TrustedRoots:        map[string][]*trust.AMDRootCerts{sgtest.GetProductLine(): {root}},
+	}
+}
+
+func defaultPolicyConfig() config.AttestationPolicyConfig {
+	vmpl := 0
+	return config.AttestationPolicyConfig{
+		Measurements: []string{hex.EncodeToString(testMeasurement)},
+		AllowSMT:     true,
+		VMPL:         &vmpl,
+	}
+}
+
+func TestPolicyAcceptsSampleReport(t *testing.T) {
+	signer := newTestSigner(t)
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := tee.NewPolicy(defaultPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := sampleReport(t, signer, nil)
+	if err := tee.VerifySNPReport(report, certs, verifyOptions(t, signer), policy, testNonce); err != nil {
+		t.Fatalf("expected report to pass policy: %v", err)
+	}
+}
+
+func TestPolicyRejections(t *testing.T) {
+	signer := newTestSigner(t)
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*spb.Report)
+		cfg    func(*config.AttestationPolicyConfig)
+		nonce  []byte
+	}{
+		{
+			name:   "unknown measurement",
+			mutate: func(r *spb.Report) { r.Measurement = bytes.Repeat([]byte{0x01}, abi.MeasurementSize) },
+		},
+		{
+			name:   "debug enabled",
+			mutate: func(r *spb.Report) { r.Policy = abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: true, Debug: true}) },
+		},
+		{
+			name:   "wrong vmpl",
+			mutate: func(r *spb.Report) { r.Vmpl = 1 },
+		},
+		{
+			name: "guest svn too low",
+			cfg:  func(c *config.AttestationPolicyConfig) { c.MinimumGuestSVN = 2 },
+		},
+		{
+			name: "tcb too low",
+			cfg:  func(c *config.AttestationPolicyConfig) { c.MinimumTCB.SnpSpl = 8 },
+		},
+		{
+			name: "family id mismatch",
+			cfg: func(c *config.AttestationPolicyConfig) {
+				c.FamilyID = hex.EncodeToString(bytes.Repeat([]byte{0x07}, 16))
+			},
+		},
+		{
+			name: "image id mismatch",
+			cfg: func(c *config.AttestationPolicyConfig) {
+				c.ImageID = hex.EncodeToString(bytes.Repeat([]byte{0x07}, 16))
+			},
+		},
+		{
+			name: "nonce at wrong offset",
+			cfg:  func(c *config.AttestationPolicyConfig) { c.NonceOffset = 16 },
+		},
+		{
+			name:  "different nonce",
+			nonce: bytes.Repeat([]byte{0xcd}, 32),
+		},
+		{
+			name:  "nonce prefix only",
+			nonce: testNonce[:16],
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultPolicyConfig()
+			if tc.cfg != nil {
+				tc.cfg(&cfg)
+			}
+			policy, err := tee.NewPolicy(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nonce := testNonce
+			if tc.nonce != nil {
+				nonce = tc.nonce
+			}
+
+			report := sampleReport(t, signer, tc.mutate)
+			err = tee.VerifySNPReport(report, certs, verifyOptions(t, signer), policy, nonce)
+			if err == nil {
+				t.Fatal("expected policy to reject report")
+			}
+			t.Log(err)
+		})
+	}
+}
+
+func TestPolicyRejectsBadSignature(t *testing.T) {
+	signer := newTestSigner(t)
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := tee.NewPolicy(defaultPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := sampleReport(t, signer, nil)
+	report[0x50+40] ^= 0xff // flip a report_data byte after signing
+
+	if err := tee.VerifySNPReport(report, certs, verifyOptions(t, signer), policy, testNonce); err == nil {
+		t.Error("expected tampered report to fail verification")
+	}
+}
+
+func TestNewPolicyRejectsInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]config.AttestationPolicyConfig{
+		"bad measurement hex":    {Measurements: []string{"zz"}},
+		"short measurement":      {Measurements: []string{"abcd"}},
+		"short family id":        {FamilyID: "abcd"},
+		"nonce offset too large": {NonceOffset: 64},
+	} {
+		if _, err := tee.NewPolicy(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package tee
+
+import (
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-sev-guest/verify"
+)
+
+// VerifySNPReport checks the signature and certificate chain of a raw SEV-SNP
+// report and then enforces the policy. certTable may be empty, in which case
+// the chain is completed through opts.
+func VerifySNPReport(report, certTable []byte, opts *verify.Options, policy *Policy, nonce []byte) error {
+	proto, err := abi.ReportToProto(report)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation report: %v", err)
+	}
+
+	attestation := &spb.Attestation{Report: proto}
+	if len(certTable) > 0 {
+		certs := new(abi.CertTable)
+		if err := certs.Unmarshal(certTable); err != nil {
+			return fmt.Errorf("failed to parse certificate table: %v", err)
+		}
+		attestation.CertificateChain = certs.Proto()
+	}
+
+	if err := verify.SnpAttestation(attestation, opts); err != nil {
+		return fmt.Errorf("attestation signature verification failed: %v", err)
+	}
+
+	return policy.Check(attestation, nonce)
+}
+
+// ReportedTCB returns the CURRENT_TCB field of a raw SEV-SNP report, which
+// changes when the host applies a firmware or microcode update
+func ReportedTCB(report []byte) (uint64, error) {
+	proto, err := abi.ReportToProto(report)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse attestation report: %v", err)
+	}
+	return proto.GetCurrentTcb(), nil
+}
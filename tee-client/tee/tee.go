@@ -1,35 +1,79 @@
-//go:build !amd && !intel
-// +build !amd,!intel
-
 package tee
 
 import (
 	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"fmt"
 )
 
+// Evidence is an attestation report together with the certificate table
+// (AMD GUID table format) needed to verify it. CertTable may be empty.
+type Evidence struct {
+	Report    []byte
+	CertTable []byte
+}
+
 type Attestor interface {
-	GenerateAttestationReport(nonce string) ([]byte, error)
-	VerifyAttestationReport(report []byte, expectedNonce string) error
+	GenerateAttestationReport(nonce string) (*Evidence, error)
+	VerifyAttestationReport(evidence *Evidence, expectedNonce string) error
+}
+
+// Sealer derives a key that only the same TEE image on the same machine can
+// derive again, to keep state across restarts of the node. Attestors of
+// hardware that supports it implement it.
+type Sealer interface {
+	SealingKey() ([]byte, error)
+}
+
+// SEV-SNP ATTESTATION_REPORT layout, see AMD SEV-SNP ABI spec table 21
+const (
+	snpMeasurementOffset = 0x90
+	snpMeasurementSize   = 48
+)
+
+// AttestationCommitment is the part of an attestation that is stored on chain.
+// A full SNP report (~1.2KB) does not fit in TEEState, so only its hash and
+// launch measurement are registered and the report itself is published as an
+// AttestationBundle.
+type AttestationCommitment struct {
+	ReportHash  [32]byte
+	Measurement [snpMeasurementSize]byte
 }
 
-func BuildAttestationNonce(address, pubkey string) (string, error) {
-	payload := map[string]interface{}{
-		"address": address,
-		"pubkey":  pubkey,
+// NewAttestationCommitment hashes the raw report and extracts its measurement
+func NewAttestationCommitment(report []byte) (*AttestationCommitment, error) {
+	if len(report) < snpMeasurementOffset+snpMeasurementSize {
+		return nil, fmt.Errorf("attestation report too short: %d bytes", len(report))
 	}
-	nonce, err := GenerateNonce(payload)
-	return nonce, err
+
+	c := &AttestationCommitment{ReportHash: sha256.Sum256(report)}
+	copy(c.Measurement[:], report[snpMeasurementOffset:snpMeasurementOffset+snpMeasurementSize])
+	return c, nil
 }
 
-func GenerateNonce(payload map[string]interface{}) (string, error) {
-	tag := []byte("TEENONCE:")
-	jsonBytes, err := json.Marshal(payload)
+// AttestationBundle is the off-chain document holding the full attestation
+// evidence referenced by TEEState.attestation_uri
+type AttestationBundle struct {
+	Version    int        `json:"version"`
+	Report     []byte     `json:"report"`               // base64
+	CertChain  []byte     `json:"cert_chain,omitempty"` // base64
+	Nonce      string     `json:"nonce"`
+	NonceInput NonceInput `json:"nonce_input"`
+	// PublicKey is the node's encryption key as base64 DER. Keys too large
+	// for TEEState register a commitment and are only found here.
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// Verify checks that the bundle matches the commitment registered on chain
+func (b *AttestationBundle) Verify(c *AttestationCommitment) error {
+	got, err := NewAttestationCommitment(b.Report)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if got.ReportHash != c.ReportHash {
+		return fmt.Errorf("attestation report hash does not match commitment")
+	}
+	if got.Measurement != c.Measurement {
+		return fmt.Errorf("attestation measurement does not match commitment")
 	}
-	tagged := append(tag, jsonBytes...)
-	hash := sha256.Sum256(tagged)
-	return hex.EncodeToString(hash[:]), nil
+	return nil
 }
@@ -0,0 +1,36 @@
+package tee_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/vitwit/healthlock/tee-client/tee"
+)
+
+func TestAttestationBundleMatchesCommitment(t *testing.T) {
+	report := make([]byte, 1184)
+	if _, err := rand.Read(report); err != nil {
+		t.Fatal(err)
+	}
+
+	commitment, err := tee.NewAttestationCommitment(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := &tee.AttestationBundle{Version: 1, Report: report}
+	if err := bundle.Verify(commitment); err != nil {
+		t.Fatalf("bundle should match its own commitment: %v", err)
+	}
+
+	report[0] ^= 0xff
+	if err := bundle.Verify(commitment); err == nil {
+		t.Error("tampered report should not match commitment")
+	}
+}
+
+func TestAttestationCommitmentShortReport(t *testing.T) {
+	if _, err := tee.NewAttestationCommitment(make([]byte, 64)); err == nil {
+		t.Error("expected error for truncated report")
+	}
+}
@@ -0,0 +1,124 @@
+//go:build mock
+// +build mock
+
+package tee
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	sgtest "github.com/google/go-sev-guest/testing"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+)
+
+// mockMeasurement is reported when the policy does not pin any measurement
+var mockMeasurement = make([]byte, abi.MeasurementSize)
+
+// TestSigner produces SEV-SNP attestation reports signed by a test-only
+// ARK/ASK/VCEK chain generated at startup, so the full AMD verification path
+// can run without hardware. Reports are shaped to satisfy the given policy.
+// Nothing it signs chains to AMD's real root.
+type TestSigner struct {
+	signer  *sgtest.AmdSigner
+	product *spb.SevProduct
+	policy  *Policy
+}
+
+func NewTestSigner(policy *Policy) (*TestSigner, error) {
+	product, err := kds.ParseProductLine(sgtest.GetProductLine())
+	if err != nil {
+		return nil, err
+	}
+
+	// the VCEK must certify the TCB the reports claim
+	tcb := policy.options.MinimumTCB
+	var hwid [abi.ChipIDSize]byte
+	now := time.Now().Add(-time.Hour)
+	builder := &sgtest.AmdSignerBuilder{
+		Keys:             sgtest.DefaultAmdKeys(),
+		ProductName:      sgtest.GetProductName(),
+		CSPID:            "healthlock-mock",
+		ArkCreationTime:  now,
+		AskCreationTime:  now,
+		AsvkCreationTime: now,
+		VcekCreationTime: now,
+		VcekCustom: sgtest.CertOverride{
+			Extensions: sgtest.CustomExtensions(tcb, hwid[:], "healthlock-mock", sgtest.GetProductName()),
+		},
+	}
+	signer, err := builder.TestOnlyCertChain()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test cert chain: %w", err)
+	}
+
+	return &TestSigner{signer: signer, product: product, policy: policy}, nil
+}
+
+// SignReport returns a signed raw report carrying reportData
+func (s *TestSigner) SignReport(reportData [reportDataSize]byte) ([]byte, error) {
+	raw := sgtest.TestRawReport(reportData)
+	report, err := abi.ReportToProto(raw[:])
+	if err != nil {
+		return nil, err
+	}
+
+	opts := s.policy.options
+	tcb, err := kds.ComposeTCBParts(opts.MinimumTCB)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Policy = abi.SnpPolicyToBytes(abi.SnpPolicy{SMT: opts.GuestPolicy.SMT})
+	report.Measurement = mockMeasurement
+	if len(s.policy.Measurements) > 0 {
+		report.Measurement = s.policy.Measurements[0]
+	}
+	report.GuestSvn = opts.MinimumGuestSvn
+	report.CurrentTcb = uint64(tcb)
+	report.ReportedTcb = uint64(tcb)
+	report.CommittedTcb = uint64(tcb)
+	report.LaunchTcb = uint64(tcb)
+	if opts.VMPL != nil {
+		report.Vmpl = uint32(*opts.VMPL)
+	}
+	if opts.FamilyID != nil {
+		report.FamilyId = opts.FamilyID
+	}
+	if opts.ImageID != nil {
+		report.ImageId = opts.ImageID
+	}
+
+	out, err := abi.ReportToAbiBytes(report)
+	if err != nil {
+		return nil, err
+	}
+	r, sig, err := s.signer.Sign(abi.SignedComponent(out))
+	if err != nil {
+		return nil, err
+	}
+	if err := abi.SetSignature(r, sig, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CertTable returns the test chain in the layout of the SNP extended report
+func (s *TestSigner) CertTable() ([]byte, error) {
+	return s.signer.CertTableBytes()
+}
+
+// VerifyOptions trusts only the test ARK and never contacts AMD KDS
+func (s *TestSigner) VerifyOptions() *verify.Options {
+	root := trust.AMDRootCertsProduct(sgtest.GetProductLine())
+	root.ProductCerts = &trust.ProductCerts{Ark: s.signer.Ark, Ask: s.signer.Ask}
+	return &verify.Options{
+		DisableCertFetching: true,
+		Now:                 time.Now(),
+		Product:             s.product,
+		TrustedRoots:        map[string][]*trust.AMDRootCerts{sgtest.GetProductLine(): {root}},
+	}
+}
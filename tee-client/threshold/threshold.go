@@ -0,0 +1,333 @@
+// Package threshold recovers the key of a record sealed with
+// keys.SealThreshold by gathering key shares from peer TEE nodes over RA-TLS.
+// Every node checks the client's access proof itself and hands out its share
+// only wrapped to the registered key of an active, recently attested node, so
+// a single compromised enclave cannot recover record keys on its own.
+package threshold
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/solana"
+)
+
+// SharePath is where nodes serve ShareHandler
+const SharePath = "/v1/share"
+
+// maxResponseSize bounds a share response; shares are a few hundred bytes
+const maxResponseSize = 64 << 10
+
+// maxRequestSize bounds a share request, whose proof is the decrypt request
+// of the owner, itself at most 64 KB
+const maxRequestSize = 128 << 10
+
+// Lookup returns the on-chain state of the node registered by signer
+type Lookup func(ctx context.Context, signer solanago.PublicKey) (*solana.TEEState, error)
+
+// Authorize checks the access proof a client sent with its decrypt request
+// and returns the envelope of the record the proof grants access to
+type Authorize func(ctx context.Context, proof json.RawMessage) (*keys.Envelope, error)
+
+// ShareRequest asks a peer for its share of a record key. The proof is the
+// client's own decrypt request, forwarded as is.
+type ShareRequest struct {
+	Requester string          `json:"requester"` // wallet address of the gathering node
+	Proof     json.RawMessage `json:"proof"`
+}
+
+// ShareResponse carries a key share wrapped to the requester's registered key
+// and signed with the responder's
+type ShareResponse struct {
+	Signer       string `json:"signer"`
+	KeyID        string `json:"key_id"`
+	WrappedShare string `json:"wrapped_share"`
+	Signature    string `json:"signature"`
+}
+
+// Node is one TEE node taking part in threshold decryption, both as the node
+// serving a client and as a peer of others
+type Node struct {
+	KeyPair   *keys.KeyPair
+	Address   solanago.PublicKey
+	Lookup    Lookup
+	Authorize Authorize
+	MaxAge    time.Duration // how recently a peer must have attested
+}
+
+// ShareHandler serves this node's share of a record key to peers
+func (n *Node) ShareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ShareRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, "Request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		requester, err := solanago.PublicKeyFromBase58(req.Requester)
+		if err != nil {
+			writeError(w, "Invalid requester pubkey", http.StatusBadRequest)
+			return
+		}
+
+		requesterKey, err := n.usableNodeKey(r.Context(), requester)
+		if err != nil {
+			fmt.Printf("❌ Refusing key share to %s: %v\n", requester, err)
+			writeError(w, "Requester is not an active, attested TEE node", http.StatusForbidden)
+			return
+		}
+
+		env, err := n.Authorize(r.Context(), req.Proof)
+		if err != nil {
+			fmt.Printf("❌ Refusing key share to %s: %v\n", requester, err)
+			writeError(w, "Not authorized to view this document", http.StatusUnauthorized)
+			return
+		}
+
+		share, err := n.KeyPair.UnwrapShare(env)
+		if errors.Is(err, keys.ErrNotARecipient) {
+			writeError(w, "Record has no key share for this TEE node", http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			fmt.Printf("❌ Failed to unwrap key share: %v\n", err)
+			writeError(w, "Failed to unwrap key share", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := n.wrapShare(share, requester, requesterKey, env)
+		if err != nil {
+			fmt.Printf("❌ Failed to wrap key share: %v\n", err)
+			writeError(w, "Failed to wrap key share", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Open decrypts a threshold envelope with this node's share and those of
+// peers. client must verify peers with ratls.ClientConfig.
+func (n *Node) Open(ctx context.Context, client *http.Client, peers []string, data []byte, proof json.RawMessage) ([]byte, error) {
+	env, err := keys.ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := n.Gather(ctx, client, peers, env, proof)
+	if err != nil {
+		return nil, err
+	}
+	return keys.OpenWithShares(env, shares)
+}
+
+// Gather collects env.Threshold shares from distinct recipients of env,
+// starting with this node's own, and asks peers in order until it has enough
+func (n *Node) Gather(ctx context.Context, client *http.Client, peers []string, env *keys.Envelope, proof json.RawMessage) ([][]byte, error) {
+	recipients := make(map[string]bool)
+	for _, r := range env.Recipients {
+		recipients[r.KeyID] = true
+	}
+
+	have := make(map[string]bool)
+	var shares [][]byte
+
+	own, err := n.KeyPair.UnwrapShare(env)
+	switch {
+	case err == nil:
+		keyID, err := keys.KeyID(n.KeyPair.Public())
+		if err != nil {
+			return nil, err
+		}
+		have[keyID] = true
+		shares = append(shares, own)
+	case !errors.Is(err, keys.ErrNotARecipient):
+		return nil, err
+	}
+
+	var errs []error
+	for _, peer := range peers {
+		if len(shares) >= env.Threshold {
+			break
+		}
+
+		keyID, share, err := n.requestShare(ctx, client, peer, env, proof)
+		if err == nil && !recipients[keyID] {
+			err = fmt.Errorf("key %s is not a recipient of the envelope", keyID)
+		}
+		if err == nil && have[keyID] {
+			err = fmt.Errorf("duplicate share for key %s", keyID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer, err))
+			continue
+		}
+
+		have[keyID] = true
+		shares = append(shares, share)
+	}
+
+	if len(shares) < env.Threshold {
+		return nil, fmt.Errorf("gathered %d of %d key shares: %w", len(shares), env.Threshold, errors.Join(errs...))
+	}
+	return shares, nil
+}
+
+// requestShare asks one peer for its share and checks that it comes from an
+// active, attested node whose registered key the share was wrapped under
+func (n *Node) requestShare(ctx context.Context, client *http.Client, peer string, env *keys.Envelope, proof json.RawMessage) (string, []byte, error) {
+	body, err := json.Marshal(ShareRequest{Requester: n.Address.String(), Proof: proof})
+	if err != nil {
+		return "", nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(peer, "/")+SharePath, bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var shareResp ShareResponse
+	if err := json.Unmarshal(raw, &shareResp); err != nil {
+		return "", nil, fmt.Errorf("invalid share response: %w", err)
+	}
+
+	signer, err := solanago.PublicKeyFromBase58(shareResp.Signer)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid signer: %w", err)
+	}
+
+	peerKey, err := n.usableNodeKey(ctx, signer)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyID, err := keys.KeyID(peerKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if keyID != shareResp.KeyID {
+		return "", nil, fmt.Errorf("share key %s is not the registered key of %s", shareResp.KeyID, signer)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(shareResp.Signature)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	msg := shareMessage(n.Address, env, shareResp.KeyID, shareResp.WrappedShare)
+	if err := keys.VerifySignature(peerKey, msg, sig); err != nil {
+		return "", nil, fmt.Errorf("share signature verification failed: %w", err)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(shareResp.WrappedShare)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid wrapped share: %w", err)
+	}
+	share, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, n.KeyPair.PrivateKey, wrapped, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to unwrap share: %w", err)
+	}
+
+	return keyID, share, nil
+}
+
+// wrapShare encrypts share to the requester's registered key and signs it
+// together with the record it belongs to
+func (n *Node) wrapShare(share []byte, requester solanago.PublicKey, requesterKey *rsa.PublicKey, env *keys.Envelope) (*ShareResponse, error) {
+	keyID, err := keys.KeyID(n.KeyPair.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, requesterKey, share, nil)
+	if err != nil {
+		return nil, err
+	}
+	wrappedB64 := base64.StdEncoding.EncodeToString(wrapped)
+
+	sig, err := n.KeyPair.Sign(shareMessage(requester, env, keyID, wrappedB64))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShareResponse{
+		Signer:       n.Address.String(),
+		KeyID:        keyID,
+		WrappedShare: wrappedB64,
+		Signature:    base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// usableNodeKey returns the registered key of signer if its node is active
+// and attested within MaxAge. Shares are wrapped and signed with RSA, so
+// nodes with other key types cannot take part.
+func (n *Node) usableNodeKey(ctx context.Context, signer solanago.PublicKey) (*rsa.PublicKey, error) {
+	state, err := n.Lookup(ctx, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up TEE node %s: %w", signer, err)
+	}
+	if !state.Usable(n.MaxAge, time.Now()) {
+		return nil, fmt.Errorf("TEE node %s is %s or not recently attested", signer, state.Status)
+	}
+	pub, err := keys.ParseRegisteredPublicKey(state.Pubkey)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("TEE node %s has no RSA key", signer)
+	}
+	return rsaPub, nil
+}
+
+// shareMessage is what a peer signs: the share is bound to the node it was
+// wrapped for and, through the envelope nonce, to one record
+func shareMessage(requester solanago.PublicKey, env *keys.Envelope, keyID, wrappedShare string) []byte {
+	return []byte(fmt.Sprintf("threshold-share:%s:%s:%s:%s", requester, env.Nonce, keyID, wrappedShare))
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,222 @@
+package threshold_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	solanago "github.com/gagliardetto/solana-go"
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/ratls/ratlstest"
+	"github.com/vitwit/healthlock/tee-client/solana"
+	"github.com/vitwit/healthlock/tee-client/threshold"
+)
+
+// registry stands in for the TEEState accounts on chain
+type registry struct {
+	mu     sync.Mutex
+	states map[solanago.PublicKey]*solana.TEEState
+}
+
+func (r *registry) lookup(_ context.Context, signer solanago.PublicKey) (*solana.TEEState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[signer]
+	if !ok {
+		return nil, errors.New("not registered")
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (r *registry) setStatus(signer solanago.PublicKey, status solana.TEEStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[signer].Status = status
+}
+
+type cluster struct {
+	registry *registry
+	nodes    []*threshold.Node
+	urls     []string
+	client   *http.Client
+	// record is what every node's Authorize hands out for the proof "ok"
+	record []byte
+}
+
+// newCluster starts n in-process nodes, each registered and serving shares
+// over RA-TLS
+func newCluster(t *testing.T, n int) *cluster {
+	c := &cluster{
+		registry: &registry{states: make(map[solanago.PublicKey]*solana.TEEState)},
+		client:   ratlstest.Client(),
+	}
+
+	for i := 0; i < n; i++ {
+		kp, err := keys.GenerateKeyPair(2048, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registered, err := kp.ExportPublicKeyBase64()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		address := solanago.NewWallet().PublicKey()
+		c.registry.states[address] = &solana.TEEState{
+			Signer:         address,
+			Pubkey:         []byte(registered),
+			IsInitialized:  true,
+			LastAttestedAt: time.Now().Unix(),
+			Status:         solana.TEEStatusActive,
+		}
+
+		node := &threshold.Node{
+			KeyPair: kp,
+			Address: address,
+			Lookup:  c.registry.lookup,
+			Authorize: func(_ context.Context, proof json.RawMessage) (*keys.Envelope, error) {
+				if string(proof) != `"ok"` {
+					return nil, errors.New("access denied")
+				}
+				return keys.ParseEnvelope(c.record)
+			},
+			MaxAge: time.Hour,
+		}
+		c.nodes = append(c.nodes, node)
+		c.urls = append(c.urls, serve(t, node))
+	}
+
+	return c
+}
+
+func serve(t *testing.T, node *threshold.Node) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc(threshold.SharePath, node.ShareHandler())
+	return ratlstest.Serve(t, mux)
+}
+
+func (c *cluster) seal(t *testing.T, plaintext []byte, k int) {
+	var recipients []crypto.PublicKey
+	for _, node := range c.nodes {
+		recipients = append(recipients, node.KeyPair.PublicKey)
+	}
+
+	sealed, err := keys.SealThreshold(plaintext, keys.RecordBinding{RecordID: 1, MimeType: "image/png"}, recipients, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.record = sealed
+}
+
+func (c *cluster) open(i int, proof string) ([]byte, error) {
+	var peers []string
+	for j, url := range c.urls {
+		if j != i {
+			peers = append(peers, url)
+		}
+	}
+	return c.nodes[i].Open(context.Background(), c.client, peers, c.record, json.RawMessage(`"`+proof+`"`))
+}
+
+func TestThresholdDecryptGathersPeerShares(t *testing.T) {
+	c := newCluster(t, 3)
+	plaintext := []byte("x-ray report")
+	c.seal(t, plaintext, 3)
+
+	for i := range c.nodes {
+		decrypted, err := c.open(i, "ok")
+		if err != nil {
+			t.Fatalf("node %d failed to decrypt: %v", i, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("node %d got %q", i, decrypted)
+		}
+	}
+}
+
+func TestThresholdDecryptToleratesMissingPeers(t *testing.T) {
+	c := newCluster(t, 3)
+	plaintext := []byte("x-ray report")
+	c.seal(t, plaintext, 2)
+
+	// the revoked node is skipped, one peer is enough for 2-of-3
+	c.registry.setStatus(c.nodes[1].Address, solana.TEEStatusRevoked)
+
+	decrypted, err := c.open(0, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q", decrypted)
+	}
+}
+
+func TestThresholdDecryptFailsBelowThreshold(t *testing.T) {
+	c := newCluster(t, 3)
+	c.seal(t, []byte("x-ray report"), 3)
+
+	c.registry.setStatus(c.nodes[2].Address, solana.TEEStatusDeactivated)
+	if _, err := c.open(0, "ok"); err == nil {
+		t.Error("expected decrypt to fail with a deactivated peer")
+	}
+}
+
+func TestThresholdPeersCheckAccessProof(t *testing.T) {
+	c := newCluster(t, 3)
+	c.seal(t, []byte("x-ray report"), 2)
+
+	if _, err := c.open(0, "forged"); err == nil {
+		t.Error("expected peers to refuse shares without a valid proof")
+	}
+}
+
+func TestThresholdPeersRefuseUnregisteredRequester(t *testing.T) {
+	c := newCluster(t, 3)
+	c.seal(t, []byte("x-ray report"), 2)
+
+	// a node holding a recipient key but unknown on chain gets no shares
+	c.nodes[0].Address = solanago.NewWallet().PublicKey()
+	if _, err := c.open(0, "ok"); err == nil {
+		t.Error("expected peers to refuse an unregistered requester")
+	}
+}
+
+func TestThresholdRejectsShareFromUnregisteredKey(t *testing.T) {
+	c := newCluster(t, 3)
+	c.seal(t, []byte("x-ray report"), 3)
+
+	// node 2 answers with a key other than the one registered for it
+	other, err := keys.GenerateKeyPair(2048, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered, err := other.ExportPublicKeyBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.registry.mu.Lock()
+	c.registry.states[c.nodes[2].Address].Pubkey = []byte(registered)
+	c.registry.mu.Unlock()
+
+	if _, err := c.open(0, "ok"); err == nil {
+		t.Error("expected a share signed with an unregistered key to be rejected")
+	}
+}
+
+func TestShareHandlerBoundsRequest(t *testing.T) {
+	body := `{"requester":"x","proof":"` + strings.Repeat("A", 256<<10) + `"}`
+	w := httptest.NewRecorder()
+	(&threshold.Node{}).ShareHandler()(w, httptest.NewRequest(http.MethodPost, threshold.SharePath, strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+}
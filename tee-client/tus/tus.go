@@ -0,0 +1,569 @@
+// Package tus serves resumable uploads with version 1.0.0 of the tus
+// protocol (https://tus.io/protocols/resumable-upload), with its creation,
+// expiration and termination extensions, so patients on flaky mobile
+// connections can continue an upload where it broke off. Uploads are staged
+// encrypted with a keys.StagingKey and handed to Server.Finish once complete.
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/internal/fsutil"
+	"github.com/vitwit/healthlock/tee-client/keys"
+)
+
+const (
+	// Path is where uploads are created; each upload is served under
+	// Path/<id>
+	Path = "/v1/uploads"
+
+	// Version is the tus protocol version served
+	Version = "1.0.0"
+	// Extensions are the tus extensions served
+	Extensions = "creation,expiration,termination"
+
+	infoFileExt = ".info"
+	dataFileExt = ".bin"
+	idSize      = 16
+)
+
+// ErrUnknownUpload is returned for upload ids the server does not hold
+var ErrUnknownUpload = errors.New("unknown upload")
+
+// Upload is the state of one upload, sealed next to its data
+type Upload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+	// Records and Size describe the staged data up to Offset
+	Records uint64 `json:"records"`
+	Size    int64  `json:"size"`
+	// Result is what Finish returned, once the upload is finished
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Server stages resumable uploads in a local directory
+type Server struct {
+	// MaxSize is the largest upload accepted, in bytes
+	MaxSize int64
+	// Expiry is how long an upload is kept after it was last written to
+	Expiry time.Duration
+	// Validate checks the metadata of a new upload; its error is reported
+	// to the client
+	Validate func(metadata map[string]string) error
+	// Finish takes the content of a complete upload and returns the result
+	// to report to the client. If it fails, the client can retry by
+	// sending an empty PATCH at the final offset.
+	Finish func(metadata map[string]string, content io.Reader) (interface{}, error)
+
+	dir string
+	key *keys.StagingKey
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewServer stages uploads in dir, which is created if needed, encrypted
+// with key
+func NewServer(dir string, key *keys.StagingKey) (*Server, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Server{dir: dir, key: key, busy: make(map[string]bool)}, nil
+}
+
+// Handler serves the tus protocol at Path and below it
+func (s *Server) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", Version)
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", Version)
+			w.Header().Set("Tus-Extension", Extensions)
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.MaxSize, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// the result of a finished upload is read without the tus header
+		if r.Method != http.MethodGet && r.Header.Get("Tus-Resumable") != Version {
+			w.Header().Set("Tus-Version", Version)
+			writeError(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, Path), "/")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.create(w, r)
+			return
+		}
+		if !validID(id) {
+			writeError(w, "Unknown upload", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodHead:
+			s.head(w, id)
+		case http.MethodPatch:
+			s.patch(w, r, id)
+		case http.MethodDelete:
+			s.terminate(w, id)
+		case http.MethodGet:
+			s.result(w, id)
+		default:
+			writeError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeError(w, "Invalid or missing Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > s.MaxSize {
+		writeError(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeError(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	if s.Validate != nil {
+		if err := s.Validate(metadata); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		writeError(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	u := &Upload{
+		ID:       hex.EncodeToString(id),
+		Length:   length,
+		Metadata: metadata,
+		Expires:  time.Now().Add(s.Expiry),
+	}
+	release, _ := s.lock(u.ID)
+	defer release()
+
+	if err := os.WriteFile(s.path(u.ID, dataFileExt), nil, 0600); err != nil {
+		fmt.Printf("❌ Failed to create upload: %v\n", err)
+		writeError(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	if err := s.save(u); err != nil {
+		fmt.Printf("❌ Failed to create upload: %v\n", err)
+		writeError(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("📥 Created resumable upload %s of %d bytes\n", u.ID, u.Length)
+
+	w.Header().Set("Location", Path+"/"+u.ID)
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	// there is nothing to send for an empty file
+	if u.Length == 0 {
+		if err := s.complete(u); err != nil {
+			fmt.Printf("❌ Failed to finish upload %s: %v\n", u.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) head(w http.ResponseWriter, id string) {
+	u, ok := s.open(w, id)
+	if !ok {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, "Invalid or missing Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.lock(id)
+	if !ok {
+		writeError(w, "Upload is being written to", http.StatusLocked)
+		return
+	}
+	defer release()
+
+	u, ok := s.open(w, id)
+	if !ok {
+		return
+	}
+	if offset != u.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		writeError(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+
+	interrupted := false
+	if u.Offset < u.Length {
+		u.Expires = time.Now().Add(s.Expiry)
+		if interrupted, err = s.append(u, r.Body); err != nil {
+			fmt.Printf("❌ Failed to stage upload %s: %v\n", u.ID, err)
+			writeError(w, "Failed to save upload", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	if interrupted {
+		// what arrived is kept; the client resumes from Upload-Offset
+		writeError(w, "Upload interrupted", http.StatusBadRequest)
+		return
+	}
+
+	if u.Offset == u.Length && u.Result == nil {
+		if err := s.complete(u); err != nil {
+			fmt.Printf("❌ Failed to finish upload %s: %v\n", u.ID, err)
+			writeError(w, "Failed to finish upload", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// append stages what body holds of u and saves u. It tells whether reading
+// body failed, in which case what was read is kept; if writing fails, u is
+// left as it was.
+func (s *Server) append(u *Upload, body io.Reader) (bool, error) {
+	f, err := os.OpenFile(s.path(u.ID, dataFileExt), os.O_WRONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// drop a record cut off by an earlier failed write
+	if err := f.Truncate(u.Size); err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(u.Size, io.SeekStart); err != nil {
+		return false, err
+	}
+	sw, err := s.key.NewWriter(f, u.ID, u.Records)
+	if err != nil {
+		return false, err
+	}
+
+	in := &errReader{r: io.LimitReader(body, u.Length-u.Offset)}
+	n, _ := io.Copy(sw, in)
+	if u.Offset+n == u.Length {
+		err = sw.Close()
+	} else {
+		err = sw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return false, err
+	}
+
+	u.Offset += sw.Written()
+	u.Records = sw.Records()
+	u.Size += sw.Size()
+	if err := s.save(u); err != nil {
+		return false, err
+	}
+	return in.err != nil, nil
+}
+
+// complete hands the content of u to Finish and keeps the result for the
+// client, discarding the content
+func (s *Server) complete(u *Upload) error {
+	if u.Length == 0 && u.Records == 0 {
+		// an empty file still ends with its last record
+		f, err := os.OpenFile(s.path(u.ID, dataFileExt), os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return err
+		}
+		sw, err := s.key.NewWriter(f, u.ID, 0)
+		if err == nil {
+			err = sw.Close()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		u.Records, u.Size = sw.Records(), sw.Size()
+	}
+
+	f, err := os.Open(s.path(u.ID, dataFileExt))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	content, err := s.key.NewReader(io.LimitReader(f, u.Size), u.ID)
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	if s.Finish != nil {
+		if result, err = s.Finish(u.Metadata, content); err != nil {
+			return err
+		}
+	}
+	if u.Result, err = json.Marshal(result); err != nil {
+		return err
+	}
+	if err := s.save(u); err != nil {
+		return err
+	}
+	return removeIfExists(s.path(u.ID, dataFileExt))
+}
+
+func (s *Server) terminate(w http.ResponseWriter, id string) {
+	release, ok := s.lock(id)
+	if !ok {
+		writeError(w, "Upload is being written to", http.StatusLocked)
+		return
+	}
+	defer release()
+
+	if _, ok := s.open(w, id); !ok {
+		return
+	}
+	if err := s.remove(id); err != nil {
+		fmt.Printf("❌ Failed to remove upload %s: %v\n", id, err)
+		writeError(w, "Failed to remove upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// result serves what Finish returned for a finished upload
+func (s *Server) result(w http.ResponseWriter, id string) {
+	u, ok := s.open(w, id)
+	if !ok {
+		return
+	}
+	if u.Result == nil {
+		writeError(w, "Upload is not complete", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(u.Result)
+}
+
+// open loads the upload id, or responds with why it cannot
+func (s *Server) open(w http.ResponseWriter, id string) (*Upload, bool) {
+	u, err := s.load(id)
+	if errors.Is(err, ErrUnknownUpload) {
+		writeError(w, "Unknown upload", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to load upload %s: %v\n", id, err)
+		writeError(w, "Failed to load upload", http.StatusInternalServerError)
+		return nil, false
+	}
+	if time.Now().After(u.Expires) {
+		writeError(w, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+	return u, true
+}
+
+// Cleanup removes the uploads that expired before now, and those staged
+// under another key, which cannot be read anymore. Uploads being written to
+// are left for the next cleanup.
+func (s *Server) Cleanup(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var errs []error
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), infoFileExt)
+		if !ok {
+			// data without state is left over from a failed creation
+			if id, ok = strings.CutSuffix(entry.Name(), dataFileExt); !ok || !validID(id) {
+				continue
+			}
+			if _, err := os.Stat(s.path(id, infoFileExt)); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		if !validID(id) {
+			continue
+		}
+
+		release, ok := s.lock(id)
+		if !ok {
+			continue
+		}
+		u, err := s.load(id)
+		if err == nil && !now.After(u.Expires) {
+			release()
+			continue
+		}
+		if err := s.remove(id); err != nil {
+			errs = append(errs, err)
+		} else {
+			removed++
+		}
+		release()
+	}
+	return removed, errors.Join(errs...)
+}
+
+// lock reserves the upload id for one request, and tells if it was free
+func (s *Server) lock(id string) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return func() {}, false
+	}
+	s.busy[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.busy, id)
+		s.mu.Unlock()
+	}, true
+}
+
+func (s *Server) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+func (s *Server) load(id string) (*Upload, error) {
+	sealed, err := os.ReadFile(s.path(id, infoFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnknownUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.key.Open(id+infoFileExt, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal upload state: %w", err)
+	}
+
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *Server) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.key.Seal(u.ID+infoFileExt, data)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileSync(s.path(u.ID, infoFileExt), sealed)
+}
+
+func (s *Server) remove(id string) error {
+	if err := removeIfExists(s.path(id, dataFileExt)); err != nil {
+		return err
+	}
+	return removeIfExists(s.path(id, infoFileExt))
+}
+
+// parseMetadata parses an Upload-Metadata header: comma separated keys, each
+// followed by a space and its base64 value unless it has none
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, fmt.Errorf("duplicate metadata key %q", key)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of metadata key %q: %w", key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == idSize && hex.EncodeToString(b) == id
+}
+
+// errReader remembers the error of reading a request body, to tell it from
+// that of writing what was read
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		e.err = err
+	}
+	return n, err
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,225 @@
+package tus_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vitwit/healthlock/tee-client/keys"
+	"github.com/vitwit/healthlock/tee-client/tus"
+)
+
+type finished struct {
+	Signer string `json:"signer"`
+	Size   int    `json:"size"`
+}
+
+func newServer(t *testing.T, dir string, seed byte) (*tus.Server, *[]byte) {
+	key, err := keys.NewStagingKey(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := tus.NewServer(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var content []byte
+	server.MaxSize = 1 << 20
+	server.Expiry = time.Hour
+	server.Validate = func(metadata map[string]string) error {
+		if metadata["signer"] == "" {
+			return errors.New("Missing signer address")
+		}
+		return nil
+	}
+	server.Finish = func(metadata map[string]string, r io.Reader) (interface{}, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		content = data
+		return finished{Signer: metadata["signer"], Size: len(data)}, nil
+	}
+	return server, &content
+}
+
+func serve(t *testing.T, server *tus.Server) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc(tus.Path, server.Handler())
+	mux.HandleFunc(tus.Path+"/", server.Handler())
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func request(t *testing.T, method, url string, body []byte, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tus.Version)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func create(t *testing.T, base string, length int) string {
+	resp := request(t, http.MethodPost, base+tus.Path, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "signer " + base64.StdEncoding.EncodeToString([]byte("owner")) + ",filename " + base64.StdEncoding.EncodeToString([]byte("scan.dcm")),
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d", resp.StatusCode)
+	}
+	return base + resp.Header.Get("Location")
+}
+
+func patch(t *testing.T, url string, offset int, chunk []byte) *http.Response {
+	return request(t, http.MethodPatch, url, chunk, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func offset(t *testing.T, url string) int {
+	resp := request(t, http.MethodHead, url, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("head: status %d", resp.StatusCode)
+	}
+	n, err := strconv.Atoi(resp.Header.Get("Upload-Offset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUploadResumesAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	server, content := newServer(t, dir, 1)
+	base := serve(t, server)
+
+	file := make([]byte, 3*keys.StagingRecordSize+123)
+	rand.Read(file)
+	url := create(t, base, len(file))
+
+	if resp := patch(t, url, 0, file[:1000]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: status %d", resp.StatusCode)
+	}
+	if resp := patch(t, url, 0, file[:10]); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a stale offset, got %d", resp.StatusCode)
+	}
+
+	// nothing staged is in plaintext
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		data, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if bytes.Contains(data, file[:32]) || bytes.Contains(data, []byte("scan.dcm")) {
+			t.Fatalf("%s holds plaintext", entry.Name())
+		}
+	}
+
+	// a node restarted on the same machine picks the upload up
+	restarted, content := newServer(t, dir, 1)
+	base = serve(t, restarted)
+	url = base + tus.Path + "/" + filepath.Base(url)
+
+	at := offset(t, url)
+	if at != 1000 {
+		t.Fatalf("resuming at %d", at)
+	}
+	resp := patch(t, url, at, file[at:])
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(file)) {
+		t.Fatalf("final patch: status %d, offset %s", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if !bytes.Equal(*content, file) {
+		t.Fatalf("finished with %d bytes, want %d", len(*content), len(file))
+	}
+
+	resp = request(t, http.MethodGet, url, nil, nil)
+	var result finished
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Signer != "owner" || result.Size != len(file) {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestUploadRejectsInvalidRequests(t *testing.T) {
+	server, _ := newServer(t, t.TempDir(), 1)
+	base := serve(t, server)
+
+	resp := request(t, http.MethodPost, base+tus.Path, nil, map[string]string{"Upload-Length": "10"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without signer, got %d", resp.StatusCode)
+	}
+	resp = request(t, http.MethodPost, base+tus.Path, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(2 << 20),
+		"Upload-Metadata": "signer b3duZXI=",
+	})
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", resp.StatusCode)
+	}
+
+	url := create(t, base, 10)
+	req, _ := http.NewRequest(http.MethodHead, url, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable, got %v %v", resp, err)
+	}
+	if resp := patch(t, url, 0, make([]byte, 20)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: status %d", resp.StatusCode)
+	}
+	// what goes beyond Upload-Length is not staged
+	if at := offset(t, url); at != 10 {
+		t.Errorf("offset %d", at)
+	}
+
+	if resp := request(t, http.MethodDelete, url, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: status %d", resp.StatusCode)
+	}
+	if resp := request(t, http.MethodHead, url, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after termination, got %d", resp.StatusCode)
+	}
+}
+
+func TestCleanupRemovesExpiredAndUnreadableUploads(t *testing.T) {
+	dir := t.TempDir()
+	server, _ := newServer(t, dir, 1)
+	base := serve(t, server)
+	url := create(t, base, 100)
+	patch(t, url, 0, make([]byte, 50))
+
+	if removed, err := server.Cleanup(time.Now()); err != nil || removed != 0 {
+		t.Fatalf("removed %d fresh uploads: %v", removed, err)
+	}
+	if removed, err := server.Cleanup(time.Now().Add(2 * time.Hour)); err != nil || removed != 1 {
+		t.Fatalf("removed %d expired uploads: %v", removed, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left", len(entries))
+	}
+
+	// uploads staged under another sealing key cannot be resumed
+	create(t, base, 100)
+	other, _ := newServer(t, dir, 2)
+	if removed, err := other.Cleanup(time.Now()); err != nil || removed != 1 {
+		t.Fatalf("removed %d unreadable uploads: %v", removed, err)
+	}
+}
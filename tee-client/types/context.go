@@ -41,3 +41,7 @@ func (b *Context) WithKeyPairs(kp *keys.KeyPair) *Context {
 func (b *Context) GetConfig() *config.Config {
 	return b.config
 }
+
+func (b *Context) GetKeyPairs() *keys.KeyPair {
+	return b.keyPairs
+}
@@ -146,10 +146,12 @@ describe("Health Records Complete Flow Test", () => {
     console.log("\nStep 3: Registering TEE node...");
     // Mock TEE node public key and attestation data
     const teeNodePubkey = Buffer.from(teeNodeKeypair.publicKey.toBytes());
-    const teeNodeAttestation = Buffer.from("mock_attestation_data_for_tee_node_verification");
+    const teeNodeAttestationHash = Array.from(Buffer.alloc(32, 1));
+    const teeNodeMeasurement = Array.from(Buffer.alloc(48, 2));
+    const teeNodeAttestationUri = "ipfs://mock_attestation_bundle";
 
     const registerTEETx = await program.methods
-      .registerTee(teeNodePubkey, teeNodeAttestation)
+      .registerTee(teeNodePubkey, teeNodeAttestationHash, teeNodeMeasurement, teeNodeAttestationUri)
       .accountsStrict({
         state: teeStatePda,
         signer: teeNodeKeypair.publicKey,
@@ -164,8 +166,27 @@ describe("Health Records Complete Flow Test", () => {
     const teeStateAccount = await program.account.teeState.fetch(teeStatePda);
     assert.equal(teeStateAccount.signer.toString(), teeNodeKeypair.publicKey.toString());
     assert.equal(teeStateAccount.isInitialized, true);
+    assert.equal(teeStateAccount.attestationUri, teeNodeAttestationUri);
+    assert.isAbove(teeStateAccount.lastAttestedAt.toNumber(), 0);
     console.log("✓ TEE node registered with signer:", teeStateAccount.signer.toString());
 
+    // Refresh the attestation of the registered node
+    const refreshedAttestationUri = "ipfs://mock_attestation_bundle_refreshed";
+    await program.methods
+      .updateTeeAttestation(teeNodePubkey, Array.from(Buffer.alloc(32, 3)), teeNodeMeasurement, refreshedAttestationUri)
+      .accountsStrict({
+        state: teeStatePda,
+        signer: teeNodeKeypair.publicKey,
+      })
+      .signers([teeNodeKeypair])
+      .rpc();
+
+    const refreshedTeeState = await program.account.teeState.fetch(teeStatePda);
+    assert.equal(refreshedTeeState.attestationUri, refreshedAttestationUri);
+    assert.deepEqual(refreshedTeeState.attestationHash, Array.from(Buffer.alloc(32, 3)));
+    assert.isAtLeast(refreshedTeeState.lastAttestedAt.toNumber(), teeStateAccount.lastAttestedAt.toNumber());
+    console.log("✓ TEE attestation refreshed");
+
 
     // STEP 3: Upload 4 health records
     console.log("\nStep 4: Uploading 4 health records...");
@@ -344,6 +365,173 @@ describe("Health Records Complete Flow Test", () => {
     
     console.log("\n🎉 All tests passed! Complete flow executed successfully:");
   });
+
+  it("Should let a TEE node retire itself and governance revoke a node", async () => {
+    const registerNode = async () => {
+      const node = Keypair.generate();
+      await provider.connection.confirmTransaction(
+        await provider.connection.requestAirdrop(node.publicKey, anchor.web3.LAMPORTS_PER_SOL)
+      );
+      const [statePda] = PublicKey.findProgramAddressSync(
+        [Buffer.from("state"), node.publicKey.toBuffer()],
+        program.programId
+      );
+      await program.methods
+        .registerTee(Buffer.from(node.publicKey.toBytes()), Array.from(Buffer.alloc(32, 1)), Array.from(Buffer.alloc(48, 2)), "")
+        .accountsStrict({
+          state: statePda,
+          signer: node.publicKey,
+          systemProgram: SystemProgram.programId,
+        })
+        .signers([node])
+        .rpc();
+      return {node, statePda};
+    };
+
+    const [governancePda] = PublicKey.findProgramAddressSync(
+      [Buffer.from("governance")],
+      program.programId
+    );
+    const [programDataPda] = PublicKey.findProgramAddressSync(
+      [program.programId.toBuffer()],
+      new PublicKey("BPFLoaderUpgradeab1e11111111111111111111111")
+    );
+
+    // the deployer is the upgrade authority and hands governance to itself
+    await program.methods
+      .initializeGovernance(provider.wallet.publicKey)
+      .accountsStrict({
+        governance: governancePda,
+        program: program.programId,
+        programData: programDataPda,
+        payer: provider.wallet.publicKey,
+        systemProgram: SystemProgram.programId,
+      })
+      .rpc();
+
+    // a node retires itself
+    const retired = await registerNode();
+    await program.methods
+      .deactivateTee()
+      .accountsStrict({
+        state: retired.statePda,
+        governance: null,
+        authority: retired.node.publicKey,
+      })
+      .signers([retired.node])
+      .rpc();
+    assert.deepEqual((await program.account.teeState.fetch(retired.statePda)).status, {deactivated: {}});
+
+    // a deactivated node can no longer refresh its attestation
+    try {
+      await program.methods
+        .updateTeeAttestation(Buffer.from(retired.node.publicKey.toBytes()), Array.from(Buffer.alloc(32, 3)), Array.from(Buffer.alloc(48, 2)), "")
+        .accountsStrict({
+          state: retired.statePda,
+          signer: retired.node.publicKey,
+        })
+        .signers([retired.node])
+        .rpc();
+      assert.fail("deactivated node refreshed its attestation");
+    } catch (err) {
+      assert.include(err.toString(), "NodeNotActive");
+    }
+
+    // another node cannot revoke it
+    const compromised = await registerNode();
+    const stranger = Keypair.generate();
+    try {
+      await program.methods
+        .revokeTee()
+        .accountsStrict({
+          state: compromised.statePda,
+          governance: governancePda,
+          authority: stranger.publicKey,
+        })
+        .signers([stranger])
+        .rpc();
+      assert.fail("stranger revoked a node");
+    } catch (err) {
+      assert.include(err.toString(), "UnauthorizedAccess");
+    }
+
+    // governance can
+    await program.methods
+      .revokeTee()
+      .accountsStrict({
+        state: compromised.statePda,
+        governance: governancePda,
+        authority: provider.wallet.publicKey,
+      })
+      .rpc();
+    assert.deepEqual((await program.account.teeState.fetch(compromised.statePda)).status, {revoked: {}});
+    console.log("✓ TEE node deactivated by itself and revoked by governance");
+  });
+
+  it("Should let only the owner point a record at re-wrapped data", async () => {
+    const owner = Keypair.generate();
+    const stranger = Keypair.generate();
+    for (const kp of [owner, stranger]) {
+      await provider.connection.confirmTransaction(
+        await provider.connection.requestAirdrop(kp.publicKey, anchor.web3.LAMPORTS_PER_SOL)
+      );
+    }
+
+    const [recordCounterPda] = PublicKey.findProgramAddressSync(
+      [Buffer.from("record_counter")],
+      program.programId
+    );
+    const [userVaultPda] = PublicKey.findProgramAddressSync(
+      [Buffer.from("user_vault"), owner.publicKey.toBuffer()],
+      program.programId
+    );
+    const recordId = (await program.account.recordCounter.fetch(recordCounterPda)).recordId;
+    const [recordPda] = PublicKey.findProgramAddressSync(
+      [Buffer.from("health_record"), owner.publicKey.toBuffer(), recordId.toArrayLike(Buffer, "le", 8)],
+      program.programId
+    );
+
+    await program.methods
+      .uploadHealthRecord("QmOldCid", "application/pdf", new anchor.BN(1024), "lab results", "Labs")
+      .accountsStrict({
+        userVault: userVaultPda,
+        recordCounter: recordCounterPda,
+        healthRecord: recordPda,
+        owner: owner.publicKey,
+        systemProgram: SystemProgram.programId,
+      })
+      .signers([owner])
+      .rpc();
+
+    // the seeds tie the record to its owner, so nobody else can sign for it
+    try {
+      await program.methods
+        .updateRecordData(recordId, "QmStrangerCid")
+        .accountsStrict({
+          healthRecord: recordPda,
+          owner: stranger.publicKey,
+        })
+        .signers([stranger])
+        .rpc();
+      assert.fail("stranger updated the record data");
+    } catch (err) {
+      assert.include(err.toString(), "ConstraintSeeds");
+    }
+
+    await program.methods
+      .updateRecordData(recordId, "QmNewCid")
+      .accountsStrict({
+        healthRecord: recordPda,
+        owner: owner.publicKey,
+      })
+      .signers([owner])
+      .rpc();
+
+    const record = await program.account.healthRecord.fetch(recordPda);
+    assert.equal(record.encryptedData, "QmNewCid");
+    assert.equal(record.title, "Labs");
+    console.log("✓ Record data updated by its owner only");
+  });
 });
 
 